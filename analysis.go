@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a savestate analysis finds an address from savestates where the value is known (e.g. one with 1 life, one with 2
+// lives and one with 3 lives). It loads each savestate and scans the region after every load, which makes a scan
+// session (see scan.go) whose scans are done automatically, so the candidates that are left can be narrowed down
+// further like any other scan. Before anything is loaded, the current state is saved to a scratch slot and it's
+// loaded again at the end (even when the analysis fails or is cancelled) to put the emulator back the way it was.
+// Emulators answer savestate requests before the state has finished saving or loading, so the analysis waits a little
+// after each one.
+
+const maxAnalysisStates = 16
+const maxAnalyses = 4
+const defaultAnalysisDelay = 2 * time.Second
+const maxAnalysisDelay = time.Minute
+
+var ErrAnalysisNotFound = errors.New("analysis not found")
+var ErrTooManyAnalyses = fmt.Errorf("there can't be more than %v analyses. Delete one first", maxAnalyses)
+var ErrAnalysisRunning = errors.New("an analysis is already running. Wait for it to finish or cancel it first")
+var ErrAnalysisEmulator = errors.New("error while sending the PINE requests for the analysis")
+
+var errAnalysisCancelled = errors.New("analysis cancelled")
+
+type AnalysisManager struct {
+	lock   sync.Mutex
+	jobs   map[int]*stateAnalysis
+	nextID int
+}
+
+// a savestate slot and what the value is in it
+type analysisState struct {
+	slot  uint8
+	label string
+	value string
+	// the scan that's done after loading the state
+	comparison *scanComparison
+	// the candidates left after the scan, once it's done
+	candidates *int
+}
+
+type stateAnalysis struct {
+	// held while reading or changing the job since it runs in the background
+	lock sync.Mutex
+
+	id int
+	// the concrete connection name (e.g. "pcsx2:28011") and game that the analysis was started with
+	target      string
+	gameID      string
+	gameVersion string
+	states      []*analysisState
+	// exact (the value is the same as the label's value) or ordered (the value goes up and down with the labels)
+	match       string
+	scratchSlot uint8
+	delay       time.Duration
+	session     *scanSession
+	// the id of the scan session once the first state has been scanned
+	scanID int
+
+	// running, done, failed or cancelled
+	status string
+	// saving, loading, scanning or restoring while running, along with the index of the state
+	phase        string
+	currentState int
+	// whether the state from before the analysis was loaded again, once it's been tried
+	restored   *bool
+	errMessage string
+	cancel     chan struct{}
+	cancelled  bool
+	created    time.Time
+	updated    time.Time
+}
+
+func NewAnalysisManager() *AnalysisManager {
+	return &AnalysisManager{jobs: make(map[int]*stateAnalysis), nextID: 1}
+}
+
+func (manager *AnalysisManager) job(id string) (*stateAnalysis, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	number, err := strconv.Atoi(id)
+	job, found := manager.jobs[number]
+	if err != nil || !found {
+		return nil, fmt.Errorf("%w with id %v", ErrAnalysisNotFound, id)
+	}
+	return job, nil
+}
+
+func (manager *AnalysisManager) List() []map[string]any {
+	manager.lock.Lock()
+	jobs := make([]*stateAnalysis, 0, len(manager.jobs))
+	for _, job := range manager.jobs {
+		jobs = append(jobs, job)
+	}
+	manager.lock.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].id < jobs[j].id })
+	results := make([]map[string]any, len(jobs))
+	for i, job := range jobs {
+		results[i] = job.toResult()
+	}
+	return results
+}
+
+// starts the analysis in the background. Only one analysis can run at a time since each one takes over the
+// emulator's state
+func (manager *AnalysisManager) Start(pc *PineConnection, game gameInfo, job *stateAnalysis) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	for _, other := range manager.jobs {
+		other.lock.Lock()
+		running := other.status == "running"
+		other.lock.Unlock()
+		if running {
+			return ErrAnalysisRunning
+		}
+	}
+	if len(manager.jobs) >= maxAnalyses {
+		return ErrTooManyAnalyses
+	}
+	job.id = manager.nextID
+	manager.nextID++
+	job.target = pc.name()
+	job.gameID = game.id
+	job.gameVersion = game.gameVersion
+	job.status = "running"
+	job.phase = "saving"
+	job.currentState = -1
+	job.cancel = make(chan struct{})
+	job.created = time.Now()
+	job.updated = job.created
+	manager.jobs[job.id] = job
+
+	go job.run(pc, game)
+	return nil
+}
+
+// cancels the job when it's running (it's kept until the state from before has been loaded again) and otherwise
+// forgets it. The scan session of the job is kept either way. Returns whether the job was cancelled
+func (manager *AnalysisManager) Delete(id string) (bool, error) {
+	job, err := manager.job(id)
+	if err != nil {
+		return false, err
+	}
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if job.status == "running" {
+		if !job.cancelled {
+			job.cancelled = true
+			close(job.cancel)
+		}
+		return true, nil
+	}
+	manager.lock.Lock()
+	delete(manager.jobs, job.id)
+	manager.lock.Unlock()
+	return false, nil
+}
+
+func (job *stateAnalysis) setPhase(phase string, currentState int) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.phase = phase
+	job.currentState = currentState
+}
+
+// waits for the emulator to finish saving or loading a state, returning errAnalysisCancelled if the job is cancelled
+// in the meantime
+func (job *stateAnalysis) wait() error {
+	select {
+	case <-time.After(job.delay):
+		return nil
+	case <-job.cancel:
+		return errAnalysisCancelled
+	}
+}
+
+func (job *stateAnalysis) run(pc *PineConnection, game gameInfo) {
+	// frozen values and effect reverts would be written into the states as they're loaded, changing what's scanned
+	supervisor.PauseWrites(pc)
+	defer supervisor.ResumeWrites(pc)
+
+	resultCode, err := sendStateRequest(pc, PineSaveStateRequest{slot: job.scratchSlot}, &PineSaveStateAnswer{})
+	if err == nil && resultCode != 0 {
+		err = fmt.Errorf("the emulator couldn't save the current state to slot %v (result code %v)", job.scratchSlot, resultCode)
+	}
+	if err == nil {
+		err = job.wait()
+		if err == nil {
+			err = job.analyze(pc, game)
+		}
+		// the state from before is loaded even after a failure since the emulator could be in any of the states
+		restoreErr := job.restore(pc)
+		if restoreErr != nil {
+			err = errors.Join(err, restoreErr)
+		}
+	}
+	job.finish(pc, err)
+}
+
+// loads each state and scans the region
+func (job *stateAnalysis) analyze(pc *PineConnection, game gameInfo) error {
+	for i, state := range job.states {
+		job.setPhase("loading", i)
+		resultCode, err := sendStateRequest(pc, PineLoadStateRequest{slot: state.slot}, &PineLoadStateAnswer{})
+		if err != nil {
+			return err
+		}
+		if resultCode != 0 {
+			return fmt.Errorf("the emulator couldn't load slot %v (result code %v)", state.slot, resultCode)
+		}
+		err = job.wait()
+		if err != nil {
+			return err
+		}
+		loadedGame, err := detectGame(pc)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrAnalysisEmulator, err)
+		}
+		if loadedGame.id != game.id || loadedGame.gameVersion != game.gameVersion {
+			return fmt.Errorf("slot %v has game \"%v\" rather than \"%v\"", state.slot, loadedGame.id, game.id)
+		}
+
+		job.setPhase("scanning", i)
+		if i == 0 {
+			err = scans.Start(pc, game, job.session, state.comparison)
+		} else {
+			err = scans.Next(pc, job.session, state.comparison)
+		}
+		if err != nil {
+			return err
+		}
+		job.lock.Lock()
+		candidates := job.session.Candidates
+		state.candidates = &candidates
+		job.scanID = job.session.ID
+		job.lock.Unlock()
+	}
+	return nil
+}
+
+// loads the state that was saved before the analysis
+func (job *stateAnalysis) restore(pc *PineConnection) error {
+	job.setPhase("restoring", -1)
+	resultCode, err := sendStateRequest(pc, PineLoadStateRequest{slot: job.scratchSlot}, &PineLoadStateAnswer{})
+	restored := err == nil && resultCode == 0
+	job.lock.Lock()
+	job.restored = &restored
+	job.lock.Unlock()
+	if err == nil && resultCode != 0 {
+		err = fmt.Errorf("the emulator couldn't load the state from before the analysis from slot %v (result code %v)", job.scratchSlot, resultCode)
+	}
+	return err
+}
+
+func (job *stateAnalysis) finish(pc *PineConnection, err error) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.phase = ""
+	job.currentState = -1
+	job.updated = time.Now()
+	if errors.Is(err, ErrAnalysisEmulator) || errors.Is(err, ErrScanRead) {
+		supervisor.ConnectionFailed(pc)
+	}
+	switch {
+	case err == nil:
+		job.status = "done"
+		logger.Info("analysis done", "id", job.id, "scan", job.scanID)
+	case errors.Is(err, errAnalysisCancelled) && job.restored != nil && *job.restored:
+		job.status = "cancelled"
+		logger.Info("analysis cancelled", "id", job.id)
+	default:
+		job.status = "failed"
+		job.errMessage = err.Error()
+		logger.Error("analysis failed", "id", job.id, "err", err)
+	}
+}
+
+// asks the emulator to save or load a state, which it answers before the state has finished saving or loading
+func sendStateRequest(pc *PineConnection, request PineRequest, answer PineAnswer) (uint8, error) {
+	err := pc.SendRequest(request, answer)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrAnalysisEmulator, err)
+	}
+	resultCode, _ := pineAnswerToResult(answer)
+	return resultCode, nil
+}
+
+func (job *stateAnalysis) toResult() map[string]any {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	states := make([]map[string]any, len(job.states))
+	for i, state := range job.states {
+		states[i] = map[string]any{
+			"slot":  state.slot,
+			"label": state.label,
+			"value": state.value,
+			"scan":  state.comparison.name,
+		}
+		if state.candidates != nil {
+			states[i]["candidates"] = *state.candidates
+		}
+	}
+	result := map[string]any{
+		"id":          job.id,
+		"target":      job.target,
+		"gameId":      job.gameID,
+		"gameVersion": job.gameVersion,
+		"status":      job.status,
+		"states":      states,
+		"match":       job.match,
+		"scratchSlot": job.scratchSlot,
+		"delay":       job.delay.String(),
+		"type":        job.session.Type,
+		"address":     fmt.Sprintf("0x%X", job.session.Address),
+		"length":      job.session.Length,
+		"created":     job.created.Format(time.RFC3339Nano),
+		"updated":     job.updated.Format(time.RFC3339Nano),
+	}
+	if job.scanID != 0 {
+		result["scanId"] = job.scanID
+	}
+	if job.status == "running" {
+		result["phase"] = job.phase
+		if job.currentState >= 0 {
+			result["currentState"] = job.currentState
+		}
+		result["cancelled"] = job.cancelled
+	}
+	if job.restored != nil {
+		result["restored"] = *job.restored
+	}
+	if job.errMessage != "" {
+		result["errMessage"] = job.errMessage
+	}
+	return result
+}
+
+// the region, type and alignment are the same as for a scan session
+func parseStateAnalysis(params map[string]string, statesParams []map[string]any, pc *PineConnection) (*stateAnalysis, error) {
+	session, err := parseScanSession(params, pc)
+	if err != nil {
+		return nil, err
+	}
+	job := &stateAnalysis{session: session, match: strings.ToLower(params["woodymatch"]), delay: defaultAnalysisDelay}
+	if job.match == "" {
+		job.match = "exact"
+	}
+	if job.match != "exact" && job.match != "ordered" {
+		return nil, fmt.Errorf("unknown match \"%v\". Supported values are exact and ordered", params["woodymatch"])
+	}
+	scratchSlotString, found := params["woodyscratchslot"]
+	if !found {
+		return nil, errors.New("no scratch slot found in the parameters (the state from before the analysis is saved there)")
+	}
+	scratchSlot, err := parseInt(scratchSlotString, 8)
+	if err != nil {
+		return nil, errors.New("unable to parse scratch slot " + scratchSlotString)
+	}
+	job.scratchSlot = uint8(scratchSlot)
+	if delayString, found := params["woodydelay"]; found {
+		job.delay, err = time.ParseDuration(delayString)
+		if err != nil || job.delay < 0 || job.delay > maxAnalysisDelay {
+			return nil, fmt.Errorf("the delay has to be a duration between 0s and %v (e.g. 2s)", maxAnalysisDelay)
+		}
+	}
+
+	if len(statesParams) < 2 || len(statesParams) > maxAnalysisStates {
+		return nil, fmt.Errorf("an analysis needs between 2 and %v states", maxAnalysisStates)
+	}
+	var previousValue uint64
+	for i, stateParams := range statesParams {
+		state, value, err := parseAnalysisState(parseJSONParams(stateParams), session.memoryType)
+		if err == nil && state.slot == job.scratchSlot {
+			err = fmt.Errorf("slot %v is the scratch slot, which gets overwritten", state.slot)
+		}
+		if err == nil {
+			scanParams := map[string]string{"woodyscan": "exact", "woodydata": state.value}
+			if job.match == "ordered" {
+				scanParams["woodyscan"] = "unknown"
+				if i > 0 {
+					scanParams["woodyscan"], err = orderedScanName(session.memoryType, previousValue, value)
+				}
+			}
+			if err == nil {
+				state.comparison, err = parseScanComparison(scanParams, session.memoryType, i == 0)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("state %v in the analysis: %w", i, err)
+		}
+		previousValue = value
+		job.states = append(job.states, state)
+	}
+	return job, nil
+}
+
+func parseAnalysisState(params map[string]string, memoryType MemoryType) (*analysisState, uint64, error) {
+	slotString, found := params["woodyslot"]
+	if !found {
+		return nil, 0, errors.New("no slot found")
+	}
+	slot, err := parseInt(slotString, 8)
+	if err != nil {
+		return nil, 0, errors.New("unable to parse slot " + slotString)
+	}
+	state := &analysisState{slot: uint8(slot), label: params["woodylabel"]}
+	state.value, found = params["woodyvalue"]
+	if !found {
+		return nil, 0, errors.New("no value found")
+	}
+	value, err := memoryType.encode(state.value)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to parse value %v as %v", state.value, memoryType.name)
+	}
+	if state.label == "" {
+		state.label = state.value
+	}
+	return state, value, nil
+}
+
+// the next scan that keeps the values that changed the same way as the labelled values did
+func orderedScanName(memoryType MemoryType, previous uint64, value uint64) (string, error) {
+	order, comparable := memoryType.compare(value, previous)
+	switch {
+	case !comparable:
+		return "", errors.New("the values have to be numbers")
+	case order > 0:
+		return "increased", nil
+	case order < 0:
+		return "decreased", nil
+	default:
+		return "unchanged", nil
+	}
+}
+
+func handleAnalysesHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling analyses HTTP request")
+	// for savestate analyses:
+	// - a POST to /analyses starts an analysis. The body is a JSON array where each element is an object with the
+	//   Woody-Slot of a savestate, the Woody-Value in that savestate and an optional Woody-Label (e.g. "1 life")
+	// - the other parameters are headers or URL parameters: Woody-Address, Woody-Length, Woody-Type and
+	//   Woody-Alignment are the region like /scans takes, Woody-Match is exact (the default) or ordered and
+	//   Woody-Delay (2s by default) is how long to wait for the emulator to save or load a state
+	// - Woody-Scratch-Slot is required and is where the state from before the analysis is saved and loaded from at the
+	//   end, so anything that was in that slot is overwritten
+	// - a GET to /analyses lists the analyses and /analyses/<id> has the progress and, once it's done, a page of the
+	//   addresses that matched from Woody-Offset (up to Woody-Limit of them)
+	// - a DELETE to /analyses/<id> cancels the analysis when it's running, otherwise it forgets it
+	// - the candidates are a scan session, so they can be narrowed down further with /scans
+	params := parseHTTPParamsWithoutBody(httpRequest)
+	path := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/analyses"), "/")
+
+	switch {
+	case path == "" && httpRequest.Method == http.MethodPost:
+		startAnalysis(httpResponseWriter, httpRequest, params)
+		return
+	case path == "":
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"analyses": analyses.List()})
+		return
+	}
+	job, err := analyses.job(path)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	switch httpRequest.Method {
+	case http.MethodDelete:
+		cancelled, err := analyses.Delete(path)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 404, errMessage)
+			return
+		}
+		if cancelled {
+			sendHTTPJSON(httpResponseWriter, 202, job.toResult())
+			return
+		}
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"id": job.id, "deleted": true})
+	case http.MethodGet:
+		offset, limit, err := parseScanPage(params)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		result := job.toResult()
+		if result["status"] == "done" {
+			// the scan session can be deleted separately
+			session, err := scans.session(fmt.Sprint(result["scanId"]))
+			var page []map[string]any
+			if err == nil {
+				page, err = scans.Page(session, offset, limit)
+			}
+			if err != nil {
+				errMessage := "could not read the scan of the analysis"
+				logger.Error(errMessage, "id", job.id, "err", err)
+				sendHTTPError(httpResponseWriter, 500, errMessage)
+				return
+			}
+			session.busy.Lock()
+			result["candidates"] = session.Candidates
+			session.busy.Unlock()
+			result["offset"] = offset
+			result["results"] = page
+		}
+		sendHTTPJSON(httpResponseWriter, 200, result)
+	default:
+		errMessage := fmt.Sprintf("unsupported analysis request %v %v. Use GET or DELETE /analyses/<id>", httpRequest.Method, httpRequest.URL.Path)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+	}
+}
+
+func startAnalysis(httpResponseWriter http.ResponseWriter, httpRequest *http.Request, params map[string]string) {
+	var statesParams []map[string]any
+	decoder := json.NewDecoder(httpRequest.Body)
+	decoder.UseNumber()
+	err := decoder.Decode(&statesParams)
+	if err != nil {
+		errMessage := "could not parse the JSON body for the analysis"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	job, err := parseStateAnalysis(params, statesParams, pc)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	err = analyses.Start(pc, game, job)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+	sendHTTPJSON(httpResponseWriter, 202, job.toResult())
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// array-of-bytes (AOB) signatures find code or data by the bytes around it (e.g. "3C 02 ?? ?? 8C 42") rather than a
+// fixed address, which is different between the regions and revisions of a game. The matches for a signature are
+// cached per game since they don't move while the game is running.
+
+const maxSignatureLength = 256
+const maxSignatureMatches = 1000
+const maxSignatureCacheEntries = 256
+
+var ErrSignatureNotFound = errors.New("signature not found")
+var ErrSignatureAmbiguous = errors.New("signature matches more than one place")
+
+// the bytes to look for where a byte only has to match the bits that are set in its mask (so "??" has a mask of 0 and
+// "3?" has a mask of 0xF0)
+type bytePattern struct {
+	values []byte
+	masks  []byte
+}
+
+// the matches of a search
+type signatureMatches struct {
+	addresses       []uint32
+	truncated       bool
+	unreadableBytes int
+}
+
+type signatureSearch struct {
+	pattern   *bytePattern
+	address   uint32
+	length    int
+	alignment int
+}
+
+type signatureCacheKey struct {
+	game      gameInfo
+	pattern   string
+	address   uint32
+	length    int
+	alignment int
+}
+
+type SignatureCache struct {
+	lock    sync.Mutex
+	matches map[signatureCacheKey]*signatureMatches
+}
+
+func NewSignatureCache() *SignatureCache {
+	return &SignatureCache{matches: make(map[signatureCacheKey]*signatureMatches)}
+}
+
+// parses hex bytes with ?? (or ?) for any byte and ? for any nibble, with or without spaces between the bytes
+func parseBytePattern(patternString string) (*bytePattern, error) {
+	tokens := strings.Fields(patternString)
+	if len(tokens) == 1 && len(tokens[0]) > 2 {
+		// no spaces so every two characters are a byte
+		if len(tokens[0])%2 != 0 {
+			return nil, errors.New("a pattern without spaces needs two characters for every byte")
+		}
+		joined := tokens[0]
+		tokens = nil
+		for i := 0; i < len(joined); i += 2 {
+			tokens = append(tokens, joined[i:i+2])
+		}
+	}
+	pattern := &bytePattern{}
+	for _, token := range tokens {
+		if token == "?" {
+			token = "??"
+		}
+		if len(token) != 2 {
+			return nil, fmt.Errorf("\"%v\" isn't a byte (e.g. 3C, ?? or 3?)", token)
+		}
+		var value, mask byte
+		for _, nibble := range token {
+			value <<= 4
+			mask <<= 4
+			if nibble == '?' {
+				continue
+			}
+			digit, err := strconv.ParseUint(string(nibble), 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("\"%v\" isn't a byte (e.g. 3C, ?? or 3?)", token)
+			}
+			value |= byte(digit)
+			mask |= 0xF
+		}
+		pattern.values = append(pattern.values, value)
+		pattern.masks = append(pattern.masks, mask)
+	}
+	if len(pattern.values) == 0 || len(pattern.values) > maxSignatureLength {
+		return nil, fmt.Errorf("a pattern needs between 1 and %v bytes", maxSignatureLength)
+	}
+	anyFixed := false
+	for _, mask := range pattern.masks {
+		anyFixed = anyFixed || mask != 0
+	}
+	if !anyFixed {
+		return nil, errors.New("a pattern can't be only wildcards")
+	}
+	return pattern, nil
+}
+
+// written the same way no matter how it was given (e.g. "3C 02 ?? ?? 8C 42")
+func (pattern *bytePattern) String() string {
+	tokens := make([]string, len(pattern.values))
+	for i, value := range pattern.values {
+		token := []byte(fmt.Sprintf("%02X", value))
+		if pattern.masks[i]&0xF0 == 0 {
+			token[0] = '?'
+		}
+		if pattern.masks[i]&0x0F == 0 {
+			token[1] = '?'
+		}
+		tokens[i] = string(token)
+	}
+	return strings.Join(tokens, " ")
+}
+
+func (pattern *bytePattern) matchesAt(bytes []byte) bool {
+	for i, value := range pattern.values {
+		if bytes[i]&pattern.masks[i] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// reads the region one chunk at a time (so other requests still get their turn) and finds where the pattern is.
+// The bytes at the end of each chunk are kept so a match can go over the edge between chunks
+func (search *signatureSearch) run(pc *PineConnection) (*signatureMatches, error) {
+	matches := &signatureMatches{addresses: []uint32{}}
+	patternLength := len(search.pattern.values)
+	var carried []byte
+	for offset := 0; offset < search.length; offset += memoryChunkSize {
+		chunkLength := min(memoryChunkSize, search.length-offset)
+		chunk, resultCode, err := readMemoryChunk(pc, search.address+uint32(offset), chunkLength)
+		if err != nil {
+			return nil, err
+		}
+		if resultCode != 0 {
+			matches.unreadableBytes += chunkLength
+			carried = nil
+			continue
+		}
+		bytes := append(carried, chunk...)
+		bytesAddress := search.address + uint32(offset) - uint32(len(carried))
+		for start := 0; start+patternLength <= len(bytes); start++ {
+			address := bytesAddress + uint32(start)
+			if address%uint32(search.alignment) != 0 || !search.pattern.matchesAt(bytes[start:]) {
+				continue
+			}
+			if len(matches.addresses) == maxSignatureMatches {
+				matches.truncated = true
+				return matches, nil
+			}
+			matches.addresses = append(matches.addresses, address)
+		}
+		carried = append([]byte(nil), bytes[max(len(bytes)-patternLength+1, 0):]...)
+	}
+	return matches, nil
+}
+
+// the matches for the running game, searching only when they aren't cached already. No game means nothing is cached
+func (cache *SignatureCache) Find(pc *PineConnection, game gameInfo, search *signatureSearch, useCache bool) (*signatureMatches, bool, error) {
+	key := signatureCacheKey{game: game, pattern: search.pattern.String(), address: search.address, length: search.length, alignment: search.alignment}
+	useCache = useCache && game.id != ""
+	if useCache {
+		cache.lock.Lock()
+		matches, found := cache.matches[key]
+		cache.lock.Unlock()
+		if found {
+			return matches, true, nil
+		}
+	}
+
+	matches, err := search.run(pc)
+	if err != nil {
+		return nil, false, err
+	}
+	// matches in memory that couldn't be read might show up later, so those aren't kept
+	if game.id != "" && matches.unreadableBytes == 0 {
+		cache.lock.Lock()
+		if len(cache.matches) >= maxSignatureCacheEntries {
+			// searching again is only slow, so there's no need to be clever about what to forget
+			clear(cache.matches)
+		}
+		cache.matches[key] = matches
+		cache.lock.Unlock()
+	}
+	return matches, false, nil
+}
+
+// the region for a search, which is all of PS2 EE RAM for PCSX2 when no length is given
+func parseSignatureSearch(patternString string, addressString string, lengthString string, alignmentString string, pc *PineConnection) (*signatureSearch, error) {
+	pattern, err := parseBytePattern(patternString)
+	if err != nil {
+		return nil, err
+	}
+	search := &signatureSearch{pattern: pattern, alignment: 1}
+	var address uint64
+	if addressString != "" {
+		address, err = parseInt(addressString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse address " + addressString)
+		}
+	}
+	search.address = uint32(address)
+	switch {
+	case lengthString != "":
+		length, err := parseInt(lengthString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse length " + lengthString)
+		}
+		search.length = int(length)
+	case pc.target == "pcsx2":
+		search.length = defaultScanLength - int(min(address, defaultScanLength))
+	default:
+		return nil, errors.New("no length found for the search (it's only optional for PCSX2)")
+	}
+	err = checkMemoryRange(search.address, search.length)
+	if err != nil {
+		return nil, err
+	}
+	if alignmentString != "" {
+		search.alignment, err = strconv.Atoi(alignmentString)
+		if err != nil || (search.alignment != 1 && search.alignment != 2 && search.alignment != 4 && search.alignment != 8) {
+			return nil, errors.New("the alignment has to be 1, 2, 4 or 8")
+		}
+	}
+	return search, nil
+}
+
+// parses an offset that can be negative (e.g. "-0x8")
+func parseOffset(offsetString string) (int64, error) {
+	negative := strings.HasPrefix(offsetString, "-")
+	offset, err := parseInt(strings.TrimPrefix(strings.TrimPrefix(offsetString, "-"), "+"), 32)
+	if err != nil {
+		return 0, errors.New("unable to parse offset " + offsetString)
+	}
+	if negative {
+		return -int64(offset), nil
+	}
+	return int64(offset), nil
+}
+
+func handleAOBHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling AOB HTTP request")
+	// for array-of-bytes searches:
+	// - Woody-Pattern is the bytes to look for in hex with ?? for any byte (e.g. 3C 02 ?? ?? 8C 42)
+	// - Woody-Address and Woody-Length are the region to search (all of PS2 EE RAM for PCSX2 when not given)
+	// - Woody-Alignment only keeps matches at a multiple of 1 (the default), 2, 4 or 8 bytes
+	// - the matches are cached for the running game unless Woody-Cache is false
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	search, err := parseSignatureSearch(params["woodypattern"], params["woodyaddress"], params["woodylength"], params["woodyalignment"], pc)
+	useCache := true
+	if err == nil && params["woodycache"] != "" {
+		useCache, err = strconv.ParseBool(params["woodycache"])
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	matches, cached, err := signatures.Find(pc, game, search, useCache)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while searching for the pattern", err)
+		return
+	}
+	addresses := make([]string, len(matches.addresses))
+	for i, address := range matches.addresses {
+		addresses[i] = fmt.Sprintf("0x%X", address)
+	}
+	sendHTTPJSON(httpResponseWriter, 200, map[string]any{
+		"pattern":         search.pattern.String(),
+		"address":         fmt.Sprintf("0x%X", search.address),
+		"length":          search.length,
+		"gameId":          game.id,
+		"matches":         addresses,
+		"truncated":       matches.truncated,
+		"unreadableBytes": matches.unreadableBytes,
+		"cached":          cached,
+	})
+}
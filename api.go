@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"maps"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 )
@@ -12,9 +14,40 @@ import (
 func serviceAPIRequests() {
 	logger.Info("configuring API server")
 	http.HandleFunc("/", handleHTTPRequest)
+	http.HandleFunc("/batch", handleBatchHTTPRequest)
+	http.HandleFunc("/connections", handleConnectionsHTTPRequest)
+	http.HandleFunc("/dump", handleDumpHTTPRequest)
+	http.HandleFunc("/write", handleWriteHTTPRequest)
+	http.HandleFunc("/watch", handleWatchHTTPRequest)
+	http.HandleFunc("/ws", handleWebSocketHTTPRequest)
+	http.HandleFunc("/vars", handleVarsHTTPRequest)
+	http.HandleFunc("/vars/", handleVarsHTTPRequest)
+	http.HandleFunc("/cheats", handleCheatsHTTPRequest)
+	http.HandleFunc("/cheats/", handleCheatsHTTPRequest)
+	http.HandleFunc("/codes", handleCodesHTTPRequest)
+	http.HandleFunc("/convert", handleConvertHTTPRequest)
+	http.HandleFunc("/freeze", handleFreezeHTTPRequest)
+	http.HandleFunc("/unfreeze", handleUnfreezeHTTPRequest)
+	http.HandleFunc("/freezes", handleFreezesHTTPRequest)
+	http.HandleFunc("/effect", handleEffectHTTPRequest)
+	http.HandleFunc("/effects", handleEffectsHTTPRequest)
+	http.HandleFunc("/effects/", handleEffectsHTTPRequest)
+	http.HandleFunc("/modify", handleModifyHTTPRequest)
+	http.HandleFunc("/transaction", handleTransactionHTTPRequest)
+	http.HandleFunc("/scans", handleScansHTTPRequest)
+	http.HandleFunc("/scans/", handleScansHTTPRequest)
+	http.HandleFunc("/aob", handleAOBHTTPRequest)
+	http.HandleFunc("/pointerscans", handlePointerScansHTTPRequest)
+	http.HandleFunc("/pointerscans/", handlePointerScansHTTPRequest)
+	http.HandleFunc("/snapshots", handleSnapshotsHTTPRequest)
+	http.HandleFunc("/snapshots/", handleSnapshotsHTTPRequest)
+	http.HandleFunc("/analyses", handleAnalysesHTTPRequest)
+	http.HandleFunc("/analyses/", handleAnalysesHTTPRequest)
 
-	logger.Info("starting API server")
-	http.ListenAndServe("localhost:6669", nil)
+	logger.Info("starting API server", "address", config.ListenHostPort())
+	err := http.ListenAndServe(config.ListenHostPort(), nil)
+	logger.Error("API server stopped", "err", err)
+	os.Exit(1)
 }
 
 func handleHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
@@ -25,33 +58,16 @@ func handleHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http
 	// - the Request type (e.g. Version) can be a parameter (e.g. localhost:6669/?woodyRequestType=Version) or a header (e.g. "Woody-Request-Type=Version")
 	// - Request parameters can be a parameter (e.g. localhost:6669/?woodyRequestType=Read8&woodyAddress=address) or a header (e.g. "Woody-Address=address")
 	// - HTTP headers must always start with "Woody-" while URL parameters must always start with "woody"
-	var pineRequestType string = ""
-	var pineRequestParams map[string]string = make(map[string]string)
-	// process the HTTP path parameters and headers
-	err := httpRequest.ParseForm()
+	pineRequestParams, err := parseHTTPParams(httpRequest)
 	if err != nil {
 		errMessage := "could not parse HTTP form and/or path parameters"
 		logger.Error(errMessage, "httpRequest", httpRequest)
 		sendHTTPError(httpResponseWriter, 400, errMessage)
 		return
 	}
-	var combinedRequestParams map[string][]string = make(map[string][]string)
-	maps.Copy(combinedRequestParams, httpRequest.Form)
-	maps.Copy(combinedRequestParams, httpRequest.Header)
-	for combinedKey, combinedValue := range combinedRequestParams {
-		// since HTTP headers and form parameters have different naming styles, we normalize on lowercase with no underscores
-		adjustedKey := strings.ToLower(combinedKey)
-		adjustedKey = strings.ReplaceAll(adjustedKey, "-", "")
-		adjustedKey = strings.ReplaceAll(adjustedKey, "_", "")
-		adjustedValue := combinedValue[0]
-
-		// don't put the PINE Request type in the map
-		if adjustedKey == "woodyrequesttype" {
-			pineRequestType = strings.ToLower(adjustedValue)
-		} else {
-			pineRequestParams[adjustedKey] = adjustedValue
-		}
-	}
+	// don't put the PINE Request type in the map
+	pineRequestType := normalizeParamKey(pineRequestParams["woodyrequesttype"])
+	delete(pineRequestParams, "woodyrequesttype")
 	if pineRequestType == "" {
 		errMessage := "no PINE request type found in HTTP request"
 		logger.Error(errMessage, "httpRequest", httpRequest)
@@ -62,6 +78,183 @@ func handleHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http
 	handlePineRequest(httpResponseWriter, pineRequestType, pineRequestParams)
 }
 
+func handleBatchHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling batch HTTP request")
+	// for batch Requests:
+	// - only POST HTTP requests are supported
+	// - the body is a JSON array where each element is an object with the same headers/parameters as a single request
+	//   (e.g. [{"Woody-Request-Type": "Read32", "Woody-Address": "0x35459C"}, {"woodyRequestType": "Title"}])
+	// - every operation is sent to the emulator as a single PINE batch message
+	// - the emulator can be chosen with a Woody-Target header or woodyTarget URL parameter (not per operation)
+	// - the response is a JSON array with the result for each operation in the same order
+	if httpRequest.Method != http.MethodPost {
+		errMessage := "batch requests must use POST"
+		logger.Error(errMessage, "method", httpRequest.Method)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+		return
+	}
+	var operationsParams []map[string]any
+	decoder := json.NewDecoder(httpRequest.Body)
+	decoder.UseNumber()
+	err := decoder.Decode(&operationsParams)
+	if err != nil {
+		errMessage := "could not parse the JSON body for the batch request"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if len(operationsParams) == 0 {
+		errMessage := "no operations found in the batch request"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	batchRequest := PineBatchRequest{}
+	batchAnswer := &PineBatchAnswer{}
+	var operations []*pineOperation
+	for i, operationParams := range operationsParams {
+		pineRequestParams := parseJSONParams(operationParams)
+		pineRequestType := normalizeParamKey(pineRequestParams["woodyrequesttype"])
+		delete(pineRequestParams, "woodyrequesttype")
+		if pineRequestType == "" {
+			errMessage := fmt.Sprintf("no PINE request type found for operation %v in the batch request", i)
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		operation, err := newPineOperation(pineRequestType, pineRequestParams)
+		if err != nil {
+			errMessage := fmt.Sprintf("operation %v in the batch request: %v", i, err)
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		operations = append(operations, operation)
+		batchRequest.requests = append(batchRequest.requests, operation.request)
+		batchAnswer.answers = append(batchAnswer.answers, operation.answer)
+	}
+
+	requestBytes, err := batchRequest.toBytes()
+	if err != nil {
+		errMessage := "error while creating requestBytes for the PINE batch request"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	// the emulator can't send back more than this, so the batch would fail without saying why
+	if batchAnswer.expectedSize() > pineMaxAnswerSize {
+		errMessage := fmt.Sprintf("the answer for the batch request would be %v bytes, which is more than the emulator can send (%v bytes). Split it into smaller batches", batchAnswer.expectedSize(), pineMaxAnswerSize)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, findHTTPParam(httpRequest, "woodytarget"))
+	if !found {
+		return
+	}
+	answerBytes, err := pc.Send(requestBytes)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while sending requestBytes for the PINE batch request", err)
+		return
+	}
+	err = batchAnswer.fromBytes(answerBytes)
+	if err != nil {
+		errMessage := "error while converting answerBytes to Answer structs for the PINE batch request"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	// the emulator only gives a single result code for the whole batch so every operation shares it
+	results := make([]map[string]any, len(operations))
+	for i, operation := range operations {
+		if batchAnswer.resultCode != 0 {
+			results[i] = map[string]any{"resultCode": batchAnswer.resultCode}
+			continue
+		}
+		_, results[i] = operation.result()
+	}
+	sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(batchAnswer.resultCode), results)
+}
+
+func handleConnectionsHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling connections HTTP request")
+	sendHTTPJSON(httpResponseWriter, 200, supervisor.Connections())
+}
+
+// finds the connection for the Woody-Target header/parameter (an empty target means the default target)
+// if there isn't one then an error response is sent and false is returned
+func connectionForTarget(httpResponseWriter http.ResponseWriter, target string) (*PineConnection, bool) {
+	pc, err := supervisor.Connection(target)
+	if errors.Is(err, ErrUnknownTarget) {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return nil, false
+	}
+	if err != nil {
+		sendNoEmulatorError(httpResponseWriter, "no emulator to send the PINE request to", err)
+		return nil, false
+	}
+	return pc, true
+}
+
+// finds a header or URL parameter without parsing the body (which is needed for endpoints that take a JSON body)
+// the key needs to already be normalized (e.g. "woodytarget")
+func findHTTPParam(httpRequest *http.Request, key string) string {
+	return parseHTTPParamsWithoutBody(httpRequest)[key]
+}
+
+// like parseHTTPParams but only the URL parameters and headers are used so the body is left alone
+func parseHTTPParamsWithoutBody(httpRequest *http.Request) map[string]string {
+	var params map[string]string = make(map[string]string)
+	for _, combinedRequestParams := range []map[string][]string{httpRequest.URL.Query(), httpRequest.Header} {
+		for combinedKey, combinedValue := range combinedRequestParams {
+			if len(combinedValue) > 0 {
+				params[normalizeParamKey(combinedKey)] = combinedValue[0]
+			}
+		}
+	}
+	return params
+}
+
+// combines the HTTP path parameters, form parameters and headers into one map with normalized keys
+func parseHTTPParams(httpRequest *http.Request) (map[string]string, error) {
+	err := httpRequest.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	var combinedRequestParams map[string][]string = make(map[string][]string)
+	maps.Copy(combinedRequestParams, httpRequest.Form)
+	maps.Copy(combinedRequestParams, httpRequest.Header)
+	var params map[string]string = make(map[string]string)
+	for combinedKey, combinedValue := range combinedRequestParams {
+		params[normalizeParamKey(combinedKey)] = combinedValue[0]
+	}
+	return params, nil
+}
+
+// converts a JSON object with the same headers/parameters as a single request (e.g. an operation in a batch request)
+// into a map with normalized keys. Numbers and booleans are converted back to strings so they parse like any other
+// parameter
+func parseJSONParams(jsonParams map[string]any) map[string]string {
+	var params map[string]string = make(map[string]string)
+	for key, value := range jsonParams {
+		params[normalizeParamKey(key)] = fmt.Sprint(value)
+	}
+	return params
+}
+
+// since HTTP headers and form parameters have different naming styles, we normalize on lowercase with no dashes or underscores
+func normalizeParamKey(key string) string {
+	adjustedKey := strings.ToLower(key)
+	adjustedKey = strings.ReplaceAll(adjustedKey, "-", "")
+	adjustedKey = strings.ReplaceAll(adjustedKey, "_", "")
+	return adjustedKey
+}
+
 func sendHTTPError(httpResponseWriter http.ResponseWriter, statusCode int, errMessage string) {
 	logger.Debug("sendHTTPError", "statusCode", statusCode)
 	httpResponseWriter.Header().Set("Content-Type", "application/json")
@@ -82,6 +275,110 @@ func handlePineRequest(httpResponseWriter http.ResponseWriter, pineRequestType s
 	//   - other result code map to a 501 (Not Implemented) HTTP response code
 	// - the HTTP response is a JSON document where any Answer parameters are returned
 
+	// some request types need more than a single PINE Request (and follow pointer expressions themselves)
+	switch pineRequestType {
+	case "readstring", "writestring":
+		handleStringRequest(httpResponseWriter, pineRequestType, pineRequestParams)
+		return
+	}
+	// and pointer expressions in the address are followed before the request is sent
+	if isAddressExpression(pineRequestParams["woodyaddress"]) {
+		handlePointerRequest(httpResponseWriter, nil, pineRequestType, pineRequestParams, nil)
+		return
+	}
+
+	// create and send the request
+	operation, err := newPineOperation(pineRequestType, pineRequestParams)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	requestBytes, err := operation.request.toBytes()
+	if err != nil {
+		errMessage := "error while creating requestBytes for " + pineRequestType + " PINE request"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, pineRequestParams["woodytarget"])
+	if !found {
+		return
+	}
+	answerBytes, err := pc.Send(requestBytes)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while sending requestBytes for "+pineRequestType+" PINE request", err)
+		return
+	}
+
+	// convert the bytes into an Answer struct then convert that into JSON
+	err = operation.answer.fromBytes(answerBytes)
+	if err != nil {
+		errMessage := "error while converting answerBytes to Answer struct for " + pineRequestType + " PINE request"
+		logger.Error(errMessage, "err", err, "answerBytes", answerBytes)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	resultCode, result := operation.result()
+
+	// send the HTTP response
+	sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), result)
+}
+
+// a single PINE Request from the API along with the empty Answer of the matching type
+type pineOperation struct {
+	request PineRequest
+	answer  PineAnswer
+	// set when Woody-Type was given so the memory value can be decoded
+	memoryType *MemoryType
+}
+
+// parses the parameters for a PINE request and creates the Request along with an empty Answer of the matching type
+func newPineOperation(pineRequestType string, pineRequestParams map[string]string) (*pineOperation, error) {
+	// a type picks the width when it isn't part of the request type (e.g. Read with a Woody-Type of f32 is a Read32)
+	var memoryType *MemoryType
+	if typeString, found := pineRequestParams["woodytype"]; found {
+		parsedType, err := parseMemoryType(typeString)
+		if err != nil {
+			return nil, errors.New(err.Error() + " for " + pineRequestType + " PINE request")
+		}
+		memoryType = &parsedType
+		switch pineRequestType {
+		case "read", "write":
+			pineRequestType = pineRequestType + strconv.Itoa(memoryType.width)
+		case "read8", "read16", "read32", "read64", "write8", "write16", "write32", "write64":
+			if !strings.HasSuffix(pineRequestType, strconv.Itoa(memoryType.width)) {
+				return nil, errors.New("type " + memoryType.name + " doesn't match the width of " + pineRequestType + " PINE request")
+			}
+		default:
+			return nil, errors.New("a type can only be given for reads and writes, not " + pineRequestType + " PINE request")
+		}
+	} else if pineRequestType == "read" || pineRequestType == "write" {
+		return nil, errors.New("no type provided for " + pineRequestType + " PINE request")
+	}
+
+	request, answer, err := newPineRequestAndAnswer(pineRequestType, pineRequestParams, memoryType)
+	if err != nil {
+		return nil, err
+	}
+	return &pineOperation{request: request, answer: answer, memoryType: memoryType}, nil
+}
+
+// converts the Answer into the result code and the elements for the JSON response
+func (operation *pineOperation) result() (uint8, map[string]any) {
+	resultCode, result := pineAnswerToResult(operation.answer)
+	if operation.memoryType != nil {
+		result["type"] = operation.memoryType.name
+		if memoryValue, found := pineAnswerMemoryValue(operation.answer); found && resultCode == 0 {
+			result["memoryValue"] = operation.memoryType.decode(memoryValue)
+		}
+	}
+	return resultCode, result
+}
+
+func newPineRequestAndAnswer(pineRequestType string, pineRequestParams map[string]string, memoryType *MemoryType) (PineRequest, PineAnswer, error) {
 	// parse the parameters for the request
 	var address uint32
 	var dataUInt64 uint64
@@ -89,267 +386,188 @@ func handlePineRequest(httpResponseWriter http.ResponseWriter, pineRequestType s
 	var slot uint8
 	switch pineRequestType {
 	case "read8", "read16", "read32", "read64", "write8", "write16", "write32", "write64":
-		addressString, found := pineRequestParams["woodyaddress"]
-		logger.Debug("parsing parameters for read/write", "addressString", addressString, "found", found)
-		if !found {
-			errMessage := "no address provided for " + pineRequestType + " PINE request"
-			logger.Error(errMessage)
-			sendHTTPError(httpResponseWriter, 400, errMessage)
-			return
-		}
-		addressUInt64, err := parseInt(addressString, 32)
-		logger.Debug("parsing parameters for read/write", "addressUInt64", addressUInt64, "err", err)
+		// pointer expressions are followed by handlePointerRequest before getting here, so in a batch or a watch they're
+		// an error
+		var err error
+		address, err = parseAddressParam(pineRequestParams, pineRequestType)
+		logger.Debug("parsing parameters for read/write", "address", address, "err", err)
 		if err != nil {
-			errMessage := "unable to parse address " + addressString + " for " + pineRequestType + " PINE request"
-			logger.Error(errMessage)
-			sendHTTPError(httpResponseWriter, 400, errMessage)
-			return
+			return nil, nil, err
 		}
-		address = uint32(addressUInt64)
 
 		// for write requests, we also need the data
 		if strings.HasPrefix(pineRequestType, "write") {
 			dataString, found := pineRequestParams["woodydata"]
 			if !found {
-				errMessage := "no data provided for " + pineRequestType + " PINE request"
-				logger.Error(errMessage)
-				sendHTTPError(httpResponseWriter, 400, errMessage)
-				return
+				return nil, nil, errors.New("no data provided for " + pineRequestType + " PINE request")
 			}
 			widthInt64, _ := strconv.ParseInt(strings.TrimPrefix(pineRequestType, "write"), 10, 8)
 			width = int(widthInt64)
-			dataUInt64, err = parseInt(dataString, width)
+			if memoryType != nil {
+				dataUInt64, err = memoryType.encode(dataString)
+			} else {
+				dataUInt64, err = parseInt(dataString, width)
+			}
 			if err != nil {
-				errMessage := "unable to parse data " + dataString + " for " + pineRequestType + " PINE request"
-				logger.Error(errMessage)
-				sendHTTPError(httpResponseWriter, 400, errMessage)
-				return
+				return nil, nil, errors.New("unable to parse data " + dataString + " for " + pineRequestType + " PINE request")
 			}
 		}
 	case "savestate", "loadstate":
-		slotString, found := pineRequestParams["woodyslot"]
-		if !found {
-			errMessage := "no slot provided for " + pineRequestType + " PINE request"
-			logger.Error(errMessage)
-			sendHTTPError(httpResponseWriter, 400, errMessage)
-			return
-		}
-		slotUInt64, err := parseInt(slotString, 8)
+		slotUInt64, err := parseIntParam(pineRequestParams, "woodyslot", "slot", 8, pineRequestType)
 		if err != nil {
-			errMessage := "unable to parse slot " + slotString + " for " + pineRequestType + " PINE request"
-			logger.Error(errMessage)
-			sendHTTPError(httpResponseWriter, 400, errMessage)
-			return
+			return nil, nil, err
 		}
 		slot = uint8(slotUInt64)
 	}
 	logger.Debug("after parsing the parameters for the request", "address", address, "dataUInt64", dataUInt64, "width", width, "slot", slot)
 
-	// create and send the request
-	var requestBytes []byte
-	var err error
 	switch pineRequestType {
 	case "read8":
-		requestBytes, err = PineRead8Request{address: address}.toBytes()
+		return PineRead8Request{address: address}, &PineRead8Answer{}, nil
 	case "read16":
-		requestBytes, err = PineRead16Request{address: address}.toBytes()
+		return PineRead16Request{address: address}, &PineRead16Answer{}, nil
 	case "read32":
-		requestBytes, err = PineRead32Request{address: address}.toBytes()
+		return PineRead32Request{address: address}, &PineRead32Answer{}, nil
 	case "read64":
-		requestBytes, err = PineRead64Request{address: address}.toBytes()
+		return PineRead64Request{address: address}, &PineRead64Answer{}, nil
 	case "write8":
-		requestBytes, err = PineWrite8Request{address: address, data: uint8(dataUInt64)}.toBytes()
+		return PineWrite8Request{address: address, data: uint8(dataUInt64)}, &PineWrite8Answer{}, nil
 	case "write16":
-		requestBytes, err = PineWrite16Request{address: address, data: uint16(dataUInt64)}.toBytes()
+		return PineWrite16Request{address: address, data: uint16(dataUInt64)}, &PineWrite16Answer{}, nil
 	case "write32":
-		requestBytes, err = PineWrite32Request{address: address, data: uint32(dataUInt64)}.toBytes()
+		return PineWrite32Request{address: address, data: uint32(dataUInt64)}, &PineWrite32Answer{}, nil
 	case "write64":
-		requestBytes, err = PineWrite64Request{address: address, data: uint64(dataUInt64)}.toBytes()
+		return PineWrite64Request{address: address, data: uint64(dataUInt64)}, &PineWrite64Answer{}, nil
 	case "version":
-		requestBytes, err = PineVersionRequest{}.toBytes()
+		return PineVersionRequest{}, &PineVersionAnswer{}, nil
 	case "savestate":
-		requestBytes, err = PineSaveStateRequest{slot: slot}.toBytes()
+		return PineSaveStateRequest{slot: slot}, &PineSaveStateAnswer{}, nil
 	case "loadstate":
-		requestBytes, err = PineLoadStateRequest{slot: slot}.toBytes()
+		return PineLoadStateRequest{slot: slot}, &PineLoadStateAnswer{}, nil
 	case "title":
-		requestBytes, err = PineTitleRequest{}.toBytes()
+		return PineTitleRequest{}, &PineTitleAnswer{}, nil
 	case "id":
-		requestBytes, err = PineIDRequest{}.toBytes()
+		return PineIDRequest{}, &PineIDAnswer{}, nil
 	case "uuid":
-		requestBytes, err = PineUUIDRequest{}.toBytes()
+		return PineUUIDRequest{}, &PineUUIDAnswer{}, nil
 	case "gameversion":
-		requestBytes, err = PineGameVersionRequest{}.toBytes()
+		return PineGameVersionRequest{}, &PineGameVersionAnswer{}, nil
 	case "status":
-		requestBytes, err = PineStatusRequest{}.toBytes()
+		return PineStatusRequest{}, &PineStatusAnswer{}, nil
 	default:
-		errMessage := "unknown request type when creating requestBytes for " + pineRequestType + " PINE request"
-		logger.Error(errMessage)
-		sendHTTPError(httpResponseWriter, 400, errMessage)
-		return
-	}
-	if err != nil {
-		errMessage := "error while creating requestBytes for " + pineRequestType + " PINE request"
-		logger.Error(errMessage, "err", err)
-		sendHTTPError(httpResponseWriter, 400, errMessage)
-		return
-	}
-	answerBytes, err := pc.Send(requestBytes)
-	if err != nil {
-		errMessage := "error while sending requestBytes for " + pineRequestType + " PINE request"
-		logger.Error(errMessage, "err", err)
-		sendHTTPError(httpResponseWriter, 400, errMessage)
-		return
+		return nil, nil, errors.New("unknown request type when creating requestBytes for " + pineRequestType + " PINE request")
 	}
+}
 
-	// convert the bytes into an Answer struct then convert that into JSON
-	var fromBytesErr error
-	var jsonString string
-	var resultCode uint8
-	switch pineRequestType {
-	case "read8":
-		var answer *PineRead8Answer = &PineRead8Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"memoryValue\": %v }", answer.resultCode, answer.memoryValue)
-		}
-	case "read16":
-		var answer *PineRead16Answer = &PineRead16Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"memoryValue\": %v }", answer.resultCode, answer.memoryValue)
-		}
-	case "read32":
-		var answer *PineRead32Answer = &PineRead32Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"memoryValue\": %v }", answer.resultCode, answer.memoryValue)
-		}
-	case "read64":
-		var answer *PineRead64Answer = &PineRead64Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"memoryValue\": %v }", answer.resultCode, answer.memoryValue)
-		}
-	case "write8":
-		var answer *PineWrite8Answer = &PineWrite8Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v }", answer.resultCode)
-		}
-	case "write16":
-		var answer *PineWrite16Answer = &PineWrite16Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v }", answer.resultCode)
-		}
-	case "write32":
-		var answer *PineWrite32Answer = &PineWrite32Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v }", answer.resultCode)
-		}
-	case "write64":
-		var answer *PineWrite64Answer = &PineWrite64Answer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v }", answer.resultCode)
-		}
-	case "version":
-		var answer *PineVersionAnswer = &PineVersionAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"version\": \"%v\" }", answer.resultCode, answer.version)
-		}
-	case "savestate":
-		var answer *PineSaveStateAnswer = &PineSaveStateAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v }", answer.resultCode)
-		}
-	case "loadstate":
-		var answer *PineLoadStateAnswer = &PineLoadStateAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v }", answer.resultCode)
-		}
-	case "title":
-		var answer *PineTitleAnswer = &PineTitleAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"title\": \"%v\" }", answer.resultCode, answer.title)
-		}
-	case "id":
-		var answer *PineIDAnswer = &PineIDAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"id\": \"%v\" }", answer.resultCode, answer.id)
-		}
-	case "uuid":
-		var answer *PineUUIDAnswer = &PineUUIDAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"uuid\": \"%v\" }", answer.resultCode, answer.uuid)
-		}
-	case "gameversion":
-		var answer *PineGameVersionAnswer = &PineGameVersionAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			logger.Debug("blarg", "len(answer.gameVersion)", len(answer.gameVersion))
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"gameVersion\": \"%v\" }", answer.resultCode, answer.gameVersion)
-		}
-	case "status":
-		var answer *PineStatusAnswer = &PineStatusAnswer{}
-		fromBytesErr = answer.fromBytes(answerBytes)
-		if fromBytesErr == nil {
-			resultCode = answer.resultCode
-			jsonString = fmt.Sprintf("{ \"resultCode\": %v, \"status\": \"%v\" }", answer.resultCode, answer.status)
-		}
+// converts an Answer into the result code and the elements for the JSON response
+func pineAnswerToResult(answer PineAnswer) (uint8, map[string]any) {
+	switch answer := answer.(type) {
+	case *PineRead8Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "memoryValue": answer.memoryValue}
+	case *PineRead16Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "memoryValue": answer.memoryValue}
+	case *PineRead32Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "memoryValue": answer.memoryValue}
+	case *PineRead64Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "memoryValue": answer.memoryValue}
+	case *PineWrite8Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode}
+	case *PineWrite16Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode}
+	case *PineWrite32Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode}
+	case *PineWrite64Answer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode}
+	case *PineVersionAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "version": answer.version}
+	case *PineSaveStateAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode}
+	case *PineLoadStateAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode}
+	case *PineTitleAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "title": answer.title}
+	case *PineIDAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "id": answer.id}
+	case *PineUUIDAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "uuid": answer.uuid}
+	case *PineGameVersionAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "gameVersion": answer.gameVersion}
+	case *PineStatusAnswer:
+		return answer.resultCode, map[string]any{"resultCode": answer.resultCode, "status": fmt.Sprint(answer.status)}
 	default:
-		errMessage := "unknown request type when creating Answer struct for " + pineRequestType + " PINE request"
-		logger.Error(errMessage)
-		sendHTTPError(httpResponseWriter, 400, errMessage)
-		return
-	}
-	if fromBytesErr != nil {
-		errMessage := "error while converting answerBytes to Answer struct for " + pineRequestType + " PINE request"
-		logger.Error(errMessage, "err", err, "answerBytes", answerBytes)
-		sendHTTPError(httpResponseWriter, 400, errMessage)
-		return
+		logger.Error("unknown Answer type when converting to JSON", "answer", answer)
+		return 255, map[string]any{"resultCode": 255}
 	}
+}
 
-	// send the HTTP response
-	var statusCode int
+func statusCodeForResultCode(resultCode uint8) int {
 	if resultCode == 0 {
-		statusCode = 200
+		return 200
 	} else if resultCode == 255 {
-		statusCode = 500
+		return 500
 	} else {
-		statusCode = 501
+		return 501
 	}
-	logger.Debug("when building the response body", "jsonString", jsonString)
+}
+
+// used when there isn't an emulator to send to (or it went away while sending) so clients can tell it apart from a bad request
+func sendNoEmulatorError(httpResponseWriter http.ResponseWriter, errMessage string, err error) {
+	sendNoEmulatorErrorWithReport(httpResponseWriter, errMessage, err, map[string]any{})
+}
+
+// for when part of the work was done before the emulator went away, so the report says what happened
+func sendNoEmulatorErrorWithReport(httpResponseWriter http.ResponseWriter, errMessage string, err error, report map[string]any) {
+	logger.Error(errMessage, "err", err)
+	httpResponseWriter.Header().Set("Retry-After", fmt.Sprint(int(supervisor.interval.Seconds())))
+	report["errMessage"] = errMessage
+	report["errType"] = "noEmulator"
+	report["errDetails"] = err.Error()
+	sendHTTPJSON(httpResponseWriter, 503, report)
+}
+
+func sendHTTPJSON(httpResponseWriter http.ResponseWriter, statusCode int, body any) {
+	jsonBytes, err := json.Marshal(body)
+	if err != nil {
+		errMessage := "error while converting the response to JSON"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 500, errMessage)
+		return
+	}
+	logger.Debug("when building the response body", "jsonString", string(jsonBytes))
 	httpResponseWriter.Header().Set("Content-Type", "application/json")
 	httpResponseWriter.WriteHeader(statusCode)
-	httpResponseWriter.Write([]byte(jsonString))
+	httpResponseWriter.Write(jsonBytes)
+}
+
+// parses a required parameter with parseInt (e.g. parseIntParam(pineRequestParams, "woodyaddress", "address", 32, "read8"))
+func parseIntParam(pineRequestParams map[string]string, key string, name string, bitSize int, pineRequestType string) (uint64, error) {
+	valueString, found := pineRequestParams[key]
+	if !found {
+		return 0, errors.New("no " + name + " provided for " + pineRequestType + " PINE request")
+	}
+	value, err := parseInt(valueString, bitSize)
+	if err != nil {
+		return 0, errors.New("unable to parse " + name + " " + valueString + " for " + pineRequestType + " PINE request")
+	}
+	return value, nil
+}
+
+// parses the required Woody-Address with parsePlainAddress so that a pointer expression gets an error saying it isn't
+// supported rather than that it can't be parsed
+func parseAddressParam(pineRequestParams map[string]string, pineRequestType string) (uint32, error) {
+	addressString, found := pineRequestParams["woodyaddress"]
+	if found && isAddressExpression(addressString) {
+		return parsePlainAddress(addressString)
+	}
+	address, err := parseIntParam(pineRequestParams, "woodyaddress", "address", 32, pineRequestType)
+	return uint32(address), err
 }
 
 func parseInt(num string, bitSize int) (uint64, error) {
 	if strings.Index(num, "0x") == 0 {
 		return strconv.ParseUint(strings.TrimPrefix(num, "0x"), 16, bitSize)
+	} else if strings.Index(num, "0b") == 0 {
+		return strconv.ParseUint(strings.TrimPrefix(num, "0b"), 2, bitSize)
 	} else {
 		return strconv.ParseUint(num, 10, bitSize)
 	}
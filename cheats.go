@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the cheat library has the cheats from every .pnach file in the cheats directory. Cheats for the running game can be
+// enabled and disabled over the API. The patches are run with the code interpreter, and the ones that PCSX2 would
+// apply continuously are run again every interval while the cheat is enabled. When a cheat is disabled, the memory it
+// changed is put back the way it was.
+type CheatLibrary struct {
+	lock    sync.Mutex
+	files   []*pnachFile
+	enabled []*enabledCheat
+	// how often continuous patches are written again
+	interval time.Duration
+	// the loop that writes continuous patches only runs while a cheat is enabled
+	running bool
+}
+
+type enabledCheat struct {
+	cheat      *Cheat
+	connection *PineConnection
+	// the game the cheat was enabled for. The cheat is dropped if a different game starts
+	game gameInfo
+	// keeps the memory from before the cheat changed it
+	runner *codeRunner
+}
+
+var ErrCheatNotFound = errors.New("cheat not found")
+
+// loads every .pnach file in the directory. A missing directory means there aren't any cheats unless required is set
+func loadCheatLibrary(directory string, required bool, interval time.Duration) (*CheatLibrary, error) {
+	library := &CheatLibrary{interval: interval}
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return library, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the cheats directory \"%v\": %w", directory, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".pnach") {
+			continue
+		}
+		path := filepath.Join(directory, entry.Name())
+		pnach, err := loadPnachFile(path)
+		if err != nil {
+			// one bad file shouldn't stop the rest of the cheats from being used
+			logger.Error("could not load cheats from \""+path+"\"", "err", err)
+			continue
+		}
+		if pnach.serial == "" && pnach.crc == "" {
+			logger.Error("could not tell which game the cheats in \"" + path + "\" are for. The file name should have the CRC or serial of the game")
+			continue
+		}
+		logger.Debug("loaded cheats", "path", path, "serial", pnach.serial, "crc", pnach.crc, "cheats", len(pnach.cheats))
+		library.files = append(library.files, pnach)
+	}
+	logger.Info("loaded cheat files", "directory", directory, "files", len(library.files))
+	return library, nil
+}
+
+// the cheats for the game, from the file for its CRC or, if there isn't one, the file for its serial
+func (library *CheatLibrary) cheatsForGame(game gameInfo) []*Cheat {
+	if game.id == "" {
+		return nil
+	}
+	var serialFile *pnachFile
+	for _, pnach := range library.files {
+		if pnach.crc != "" && strings.EqualFold(pnach.crc, game.uuid) {
+			return pnach.cheats
+		}
+		if serialFile == nil && pnach.serial != "" && pnach.serial == parseSerial(game.id) {
+			serialFile = pnach
+		}
+	}
+	if serialFile == nil {
+		return nil
+	}
+	return serialFile.cheats
+}
+
+func (library *CheatLibrary) findCheat(game gameInfo, id string) (*Cheat, error) {
+	for _, cheat := range library.cheatsForGame(game) {
+		if cheat.id == id {
+			return cheat, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no cheat with the id \"%v\" for the running game", ErrCheatNotFound, id)
+}
+
+func (library *CheatLibrary) isEnabled(connection *PineConnection, cheat *Cheat) bool {
+	library.lock.Lock()
+	defer library.lock.Unlock()
+
+	return library.findEnabled(connection, cheat) >= 0
+}
+
+// the index in enabled or -1 (the lock must be held)
+func (library *CheatLibrary) findEnabled(connection *PineConnection, cheat *Cheat) int {
+	for i, enabled := range library.enabled {
+		if enabled.connection == connection && enabled.cheat == cheat {
+			return i
+		}
+	}
+	return -1
+}
+
+// runs every patch, keeping the memory from before the cheat changed it
+func (library *CheatLibrary) Enable(connection *PineConnection, game gameInfo, cheat *Cheat) (uint8, error) {
+	if cheat.unsupported != "" {
+		return 0, errors.New("cheat " + cheat.id + " can't be used: " + cheat.unsupported)
+	}
+	library.lock.Lock()
+	defer library.lock.Unlock()
+
+	if library.findEnabled(connection, cheat) >= 0 {
+		return 0, nil
+	}
+	runner := &codeRunner{pc: connection, recordOriginals: true}
+	report, err := runner.run(cheat.lines)
+	if err != nil {
+		return 0, err
+	}
+	// a cheat that only partly applied could leave the game in a strange state so it's undone
+	if resultCode := failedResultCode(report); resultCode != 0 {
+		logger.Error("enabling cheat failed", "id", cheat.id, "report", report)
+		_, err = runner.restoreOriginals()
+		return resultCode, err
+	}
+	library.enabled = append(library.enabled, &enabledCheat{cheat: cheat, connection: connection, game: game, runner: runner})
+	logger.Info("enabled cheat", "id", cheat.id, "name", cheat.name)
+	if !library.running {
+		library.running = true
+		go library.run()
+	}
+	return 0, nil
+}
+
+// puts back the memory from before the cheat was enabled
+func (library *CheatLibrary) Disable(connection *PineConnection, cheat *Cheat) (uint8, error) {
+	library.lock.Lock()
+	defer library.lock.Unlock()
+
+	i := library.findEnabled(connection, cheat)
+	if i < 0 {
+		return 0, nil
+	}
+	resultCode, err := library.enabled[i].runner.restoreOriginals()
+	if err != nil || resultCode != 0 {
+		return resultCode, err
+	}
+	library.enabled = append(library.enabled[:i], library.enabled[i+1:]...)
+	logger.Info("disabled cheat", "id", cheat.id, "name", cheat.name)
+	return 0, nil
+}
+
+func (library *CheatLibrary) run() {
+	logger.Info("starting the cheat loop", "interval", library.interval)
+	ticker := time.NewTicker(library.interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		if !library.applyContinuous() {
+			logger.Info("stopping the cheat loop since no cheats are enabled")
+			return
+		}
+	}
+}
+
+// runs the continuous patches of every enabled cheat and returns false when there aren't any enabled cheats left
+func (library *CheatLibrary) applyContinuous() bool {
+	library.lock.Lock()
+	defer library.lock.Unlock()
+
+	var stillEnabled []*enabledCheat
+	for _, enabled := range library.enabled {
+		// the memory belongs to a different game now so there's nothing to put back
+		if supervisor.Game(enabled.connection) != enabled.game {
+			logger.Info("dropping cheat since the game changed", "id", enabled.cheat.id, "name", enabled.cheat.name)
+			continue
+		}
+		stillEnabled = append(stillEnabled, enabled)
+		report, err := enabled.runner.run(enabled.cheat.continuousLines)
+		if err != nil {
+			// the supervisor will notice and the cheat carries on once the emulator is back
+			logger.Debug("error while applying cheat", "id", enabled.cheat.id, "err", err)
+		} else if resultCode := failedResultCode(report); resultCode != 0 {
+			logger.Debug("applying cheat failed", "id", enabled.cheat.id, "resultCode", resultCode)
+		}
+	}
+	library.enabled = stillEnabled
+	if len(library.enabled) == 0 {
+		library.running = false
+		return false
+	}
+	return true
+}
+
+func (cheat *Cheat) toResult(enabled bool) map[string]any {
+	var patches []string
+	for _, patch := range cheat.patches {
+		patches = append(patches, patch.line)
+	}
+	result := map[string]any{
+		"id":          cheat.id,
+		"name":        cheat.name,
+		"description": cheat.description,
+		"author":      cheat.author,
+		"patches":     patches,
+		"enabled":     enabled,
+		"supported":   cheat.unsupported == "",
+	}
+	if cheat.unsupported != "" {
+		result["unsupportedReason"] = cheat.unsupported
+	}
+	return result
+}
+
+func handleCheatsHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling cheats HTTP request")
+	// for cheats:
+	// - /cheats lists the cheats for the running game (matched by the CRC or the serial in the .pnach file name)
+	// - /cheats/<id> is a single cheat
+	// - /cheats/<id>/enable applies the cheat and /cheats/<id>/disable puts the memory back the way it was
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/cheats"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	if id == "" {
+		cheatResults := []map[string]any{}
+		for _, cheat := range cheats.cheatsForGame(game) {
+			cheatResults = append(cheatResults, cheat.toResult(cheats.isEnabled(pc, cheat)))
+		}
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{
+			"id":     game.id,
+			"uuid":   game.uuid,
+			"cheats": cheatResults,
+		})
+		return
+	}
+	cheat, err := cheats.findCheat(game, id)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+
+	var resultCode uint8
+	switch action {
+	case "":
+	case "enable":
+		resultCode, err = cheats.Enable(pc, game, cheat)
+	case "disable":
+		resultCode, err = cheats.Disable(pc, cheat)
+	default:
+		errMessage := "unknown cheat action \"" + action + "\". Supported values are enable and disable"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if err != nil && cheat.unsupported != "" {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while sending the PINE requests for cheat "+id, err)
+		return
+	}
+	if resultCode != 0 {
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), map[string]any{"resultCode": resultCode})
+		return
+	}
+	result := cheat.toResult(cheats.isEnabled(pc, cheat))
+	result["resultCode"] = resultCode
+	sendHTTPJSON(httpResponseWriter, 200, result)
+}
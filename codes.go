@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// an interpreter for raw PS2 codes (the decrypted CodeBreaker format that pnach extended patches also use)
+// every code line is two 32-bit words where the first digit of the first word is the code type:
+// - 0aaaaaaa 000000vv is an 8-bit write, 1aaaaaaa 0000vvvv is a 16-bit write and 2aaaaaaa vvvvvvvv is a 32-bit write
+// - 300000vv 0aaaaaaa and 301000vv 0aaaaaaa are 8-bit increments and decrements
+// - 3020vvvv 0aaaaaaa and 3030vvvv 0aaaaaaa are 16-bit increments and decrements
+// - 30400000 0aaaaaaa then vvvvvvvv 00000000 and 30500000 0aaaaaaa then vvvvvvvv 00000000 are 32-bit increments and
+//   decrements
+// - 4aaaaaaa vvvvvvvv then nnnnssss iiiiiiii writes v to n 32-bit words, s words apart, adding i to v each time
+// - 5aaaaaaa nnnnnnnn then 0bbbbbbb 00000000 copies n bytes from a to b
+// - 6aaaaaaa vvvvvvvv then 000tnnnn pppppppp (then pppppppp pppppppp for any more offsets) follows the pointer at a
+//   through n offsets and writes v with the width t (0 is 8-bit, 1 is 16-bit and 2 is 32-bit)
+// - 7aaaaaaa 00t0vvvv is a bitwise operation where t is 0 (8-bit OR), 1 (16-bit OR), 2 (8-bit AND), 3 (16-bit AND),
+//   4 (8-bit XOR) or 5 (16-bit XOR)
+// - Caaaaaaa vvvvvvvv runs the rest of the lines only if the 32-bit value at a is v
+// - Daaaaaaa 00t0vvvv (16-bit) and Daaaaaaa 00t100vv (8-bit) run the next line only if the value at a compares to v
+// - E0nnvvvv taaaaaaa (16-bit) and E1nn00vv taaaaaaa (8-bit) run the next n lines only if the value at a compares to v
+// for the comparisons, t is 0 (equal), 1 (not equal), 2 (less than) or 3 (greater than)
+
+// the biggest block of codes we'll accept
+const maxCodesBodySize = 1024 * 1024
+
+// the most bytes a single 5-type code can copy
+const maxCodeCopySize = 16 * 1024 * 1024
+
+// a single line of a code
+type codeLine struct {
+	lineNumber int
+	text       string
+	// the first word, which starts with the code type (e.g. 2035459C is a 32-bit write to 0x35459C)
+	address uint32
+	value   uint32
+	// set for pnach patches that aren't extended (e.g. word), which are plain writes of data
+	span *memorySpan
+	data uint64
+}
+
+// runs codes and keeps track of what was written
+type codeRunner struct {
+	pc PineSender
+	// when set, the memory from before the first write to each address is kept (so a cheat can be undone)
+	recordOriginals bool
+	originals       map[memorySpan]uint64
+	originalSpans   []memorySpan
+}
+
+// parses raw code lines (e.g. "2035459C 0000270F") and pnach patch lines (e.g. "patch=1,EE,2035459C,extended,0000270F")
+// empty lines and comments (starting with //, ; or #) are skipped
+func parseCodeLines(reader io.Reader) ([]codeLine, error) {
+	var lines []codeLine
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "//") || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+		line, err := parseCodeLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", lineNumber, err)
+		}
+		line.lineNumber = lineNumber
+		lines = append(lines, line)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return lines, checkCodeLines(lines)
+}
+
+// parses a raw code line or a pnach patch line
+func parseCodeLine(text string) (codeLine, error) {
+	if key, value, found := strings.Cut(text, "="); found && strings.ToLower(strings.TrimSpace(key)) == "patch" {
+		patch, err := parsePnachPatch(value)
+		if err != nil {
+			return codeLine{}, err
+		}
+		if patch.cpu != "EE" {
+			return codeLine{}, fmt.Errorf("only EE patches are supported but found %v", patch.cpu)
+		}
+		return patch.codeLine()
+	}
+	words := strings.Fields(text)
+	if len(words) < 2 {
+		return codeLine{}, fmt.Errorf("expected two 8 digit hex words but found \"%v\"", text)
+	}
+	address, err := strconv.ParseUint(words[0], 16, 32)
+	if err != nil {
+		return codeLine{}, fmt.Errorf("unable to parse \"%v\" as hex", words[0])
+	}
+	value, err := strconv.ParseUint(words[1], 16, 32)
+	if err != nil {
+		return codeLine{}, fmt.Errorf("unable to parse \"%v\" as hex", words[1])
+	}
+	return codeLine{text: words[0] + " " + words[1], address: uint32(address), value: uint32(value)}, nil
+}
+
+// extended patches are code lines and the rest are plain writes
+func (patch pnachPatch) codeLine() (codeLine, error) {
+	line := codeLine{text: patch.line, address: patch.address, value: uint32(patch.data), data: patch.data}
+	if patch.patchType == "extended" {
+		return line, nil
+	}
+	var span memorySpan
+	switch patch.patchType {
+	case "byte":
+		span = memorySpan{address: patch.address, width: 1}
+	case "short":
+		span = memorySpan{address: patch.address, width: 2}
+	case "word":
+		span = memorySpan{address: patch.address, width: 4}
+	case "double":
+		span = memorySpan{address: patch.address, width: 8}
+	default:
+		return line, fmt.Errorf("patch type %v isn't supported in \"%v\"", patch.patchType, patch.line)
+	}
+	line.span = &span
+	return line, nil
+}
+
+// makes sure every code type is known and multi-line codes have all their lines, so that nothing is run from a
+// block that would fail part way through
+func checkCodeLines(lines []codeLine) error {
+	for i := 0; i < len(lines); {
+		length, err := codeLength(lines, i)
+		if err != nil {
+			return fmt.Errorf("line %v: %w", lines[i].lineNumber, err)
+		}
+		i += length
+	}
+	return nil
+}
+
+// the number of lines the code starting at i takes up
+func codeLength(lines []codeLine, i int) (int, error) {
+	line := lines[i]
+	if line.span != nil {
+		return 1, nil
+	}
+	length := 1
+	switch line.address >> 28 {
+	case 0x0, 0x1, 0x2, 0x7, 0xC, 0xD, 0xE:
+	case 0x3:
+		switch (line.address >> 20) & 0xF {
+		case 0, 1, 2, 3:
+		case 4, 5:
+			length = 2
+		default:
+			return 0, fmt.Errorf("unknown increment/decrement type in \"%v\"", line.text)
+		}
+	case 0x4, 0x5:
+		length = 2
+	case 0x6:
+		if i+1 >= len(lines) {
+			return 0, fmt.Errorf("missing the second line of the pointer code \"%v\"", line.text)
+		}
+		offsets := int(lines[i+1].address & 0xFFFF)
+		if offsets == 0 {
+			return 0, fmt.Errorf("no offsets for the pointer code \"%v\"", line.text)
+		}
+		// the first offset is on the second line and the rest are two to a line
+		length = 2 + offsets/2
+	default:
+		return 0, fmt.Errorf("code type %X isn't supported in \"%v\"", line.address>>28, line.text)
+	}
+	if i+length > len(lines) {
+		return 0, fmt.Errorf("the code \"%v\" needs %v lines", line.text, length)
+	}
+	return length, nil
+}
+
+// runs every line and returns a report entry for each code (multi-line codes are reported under their first line)
+// an error is only returned when the emulator can't be reached. Lines that the emulator fails are reported and the
+// rest of the lines still run
+func (runner *codeRunner) run(lines []codeLine) ([]map[string]any, error) {
+	var report []map[string]any
+	skipLines := 0
+	for i := 0; i < len(lines); {
+		length, err := codeLength(lines, i)
+		if err != nil {
+			return report, err
+		}
+		code := lines[i : i+length]
+		var texts []string
+		for _, line := range code {
+			texts = append(texts, line.text)
+		}
+		entry := map[string]any{"line": lines[i].lineNumber, "code": strings.Join(texts, "\n")}
+		report = append(report, entry)
+
+		i += length
+
+		// conditionals skip a number of lines (a multi-line code counts as all of its lines)
+		if skipLines > 0 {
+			entry["status"] = "skipped"
+			skipLines = max(skipLines-length, 0)
+			continue
+		}
+
+		description, resultCode, skip, err := runner.runCode(code)
+		if err != nil {
+			return report, err
+		}
+		entry["description"] = description
+		if resultCode != 0 {
+			entry["status"] = "failed"
+			entry["resultCode"] = resultCode
+		} else {
+			entry["status"] = "executed"
+			if code[0].isConditional() {
+				entry["conditionMet"] = skip == 0
+			}
+		}
+		// a conditional that can't be checked skips its lines just like one that isn't met
+		if skip < 0 {
+			skipLines = len(lines) - i
+		} else {
+			skipLines = skip
+		}
+	}
+	return report, nil
+}
+
+// the result code of the first line that failed or zero when none did
+func failedResultCode(report []map[string]any) uint8 {
+	for _, entry := range report {
+		if entry["status"] == "failed" {
+			return entry["resultCode"].(uint8)
+		}
+	}
+	return 0
+}
+
+func (line codeLine) isConditional() bool {
+	switch line.address >> 28 {
+	case 0xC, 0xD, 0xE:
+		return line.span == nil
+	}
+	return false
+}
+
+// runs a single code and returns what it did, the result code and the number of lines to skip (-1 for all of them)
+// conditionals that fail return the lines they would skip when the condition isn't met
+func (runner *codeRunner) runCode(code []codeLine) (string, uint8, int, error) {
+	line := code[0]
+	if line.span != nil {
+		resultCode, err := runner.write(*line.span, line.data)
+		return fmt.Sprintf("%v-bit write of 0x%X to 0x%X", line.span.width*8, line.data, line.span.address), resultCode, 0, err
+	}
+	address := line.address & 0x0FFFFFFF
+	switch line.address >> 28 {
+	case 0x0:
+		resultCode, err := runner.write(memorySpan{address: address, width: 1}, uint64(line.value&0xFF))
+		return fmt.Sprintf("8-bit write of 0x%X to 0x%X", line.value&0xFF, address), resultCode, 0, err
+	case 0x1:
+		resultCode, err := runner.write(memorySpan{address: address, width: 2}, uint64(line.value&0xFFFF))
+		return fmt.Sprintf("16-bit write of 0x%X to 0x%X", line.value&0xFFFF, address), resultCode, 0, err
+	case 0x2:
+		resultCode, err := runner.write(memorySpan{address: address, width: 4}, uint64(line.value))
+		return fmt.Sprintf("32-bit write of 0x%X to 0x%X", line.value, address), resultCode, 0, err
+	case 0x3:
+		return runner.runIncrement(code)
+	case 0x4:
+		count := code[1].address >> 16
+		step := (code[1].address & 0xFFFF) * 4
+		increment := code[1].value
+		value := line.value
+		for n := uint32(0); n < count; n++ {
+			resultCode, err := runner.write(memorySpan{address: address + n*step, width: 4}, uint64(value))
+			if err != nil || resultCode != 0 {
+				return fmt.Sprintf("32-bit write %v of %v to 0x%X", n+1, count, address+n*step), resultCode, 0, err
+			}
+			value += increment
+		}
+		return fmt.Sprintf("%v 32-bit writes starting with 0x%X at 0x%X (0x%X apart, adding 0x%X each time)", count, line.value, address, step, increment), 0, 0, nil
+	case 0x5:
+		length := int(line.value)
+		destination := code[1].address & 0x0FFFFFFF
+		description := fmt.Sprintf("copy of 0x%X bytes from 0x%X to 0x%X", length, address, destination)
+		if length > maxCodeCopySize {
+			return description + " is too big", 255, 0, nil
+		}
+		bytes, resultCode, err := readMemory(runner.pc, address, length)
+		if err != nil || resultCode != 0 {
+			return description, resultCode, 0, err
+		}
+		runner.recordBytes(destination, length)
+		resultCode, err = writeMemory(runner.pc, destination, bytes)
+		return description, resultCode, 0, err
+	case 0x6:
+		return runner.runPointerWrite(code)
+	case 0x7:
+		return runner.runBitwise(line, address)
+	case 0xC:
+		current, resultCode, err := readMemoryValue(runner.pc, memorySpan{address: address, width: 4})
+		description := fmt.Sprintf("run the rest of the lines if the 32-bit value at 0x%X is 0x%X", address, line.value)
+		if err != nil || resultCode != 0 {
+			return description, resultCode, -1, err
+		}
+		if uint32(current) != line.value {
+			return description, 0, -1, nil
+		}
+		return description, 0, 0, nil
+	case 0xD:
+		width := 2
+		value := line.value & 0xFFFF
+		if (line.value>>16)&0xF == 1 {
+			width = 1
+			value = line.value & 0xFF
+		}
+		return runner.runConditional(memorySpan{address: address, width: width}, (line.value>>20)&0xF, value, 1)
+	case 0xE:
+		// the value is in the first word and the address is in the second word for E codes
+		width := 2
+		value := line.address & 0xFFFF
+		if (line.address>>24)&0xF == 1 {
+			width = 1
+			value = line.address & 0xFF
+		}
+		span := memorySpan{address: line.value & 0x0FFFFFFF, width: width}
+		return runner.runConditional(span, line.value>>28, value, int((line.address>>16)&0xFF))
+	default:
+		// checkCodeLines makes sure this doesn't happen
+		return "", 0, 0, fmt.Errorf("code type %X isn't supported", line.address>>28)
+	}
+}
+
+func (runner *codeRunner) runIncrement(code []codeLine) (string, uint8, int, error) {
+	line := code[0]
+	address := code[0].value & 0x0FFFFFFF
+	var span memorySpan
+	var amount uint64
+	switch (line.address >> 20) & 0xF {
+	case 0, 1:
+		span = memorySpan{address: address, width: 1}
+		amount = uint64(line.address & 0xFF)
+	case 2, 3:
+		span = memorySpan{address: address, width: 2}
+		amount = uint64(line.address & 0xFFFF)
+	default:
+		span = memorySpan{address: address, width: 4}
+		amount = uint64(code[1].address)
+	}
+	decrement := (line.address>>20)&1 == 1
+	operation := "increment"
+	if decrement {
+		operation = "decrement"
+	}
+	description := fmt.Sprintf("%v-bit %v of 0x%X at 0x%X", span.width*8, operation, amount, address)
+	current, resultCode, err := readMemoryValue(runner.pc, span)
+	if err != nil || resultCode != 0 {
+		return description, resultCode, 0, err
+	}
+	if decrement {
+		current -= amount
+	} else {
+		current += amount
+	}
+	resultCode, err = runner.write(span, current)
+	return description, resultCode, 0, err
+}
+
+func (runner *codeRunner) runPointerWrite(code []codeLine) (string, uint8, int, error) {
+	line := code[0]
+	widthType := (code[1].address >> 16) & 0xF
+	width := 1 << widthType
+	if width > 4 {
+		return fmt.Sprintf("pointer write with an unknown width type %v", widthType), 255, 0, nil
+	}
+	offsets := []uint32{code[1].value}
+	for _, offsetLine := range code[2:] {
+		offsets = append(offsets, offsetLine.address, offsetLine.value)
+	}
+	offsets = offsets[:code[1].address&0xFFFF]
+
+	address := line.address & 0x0FFFFFFF
+	description := fmt.Sprintf("%v-bit write of 0x%X through the pointer at 0x%X with the offsets %X", width*8, line.value, address, offsets)
+	for _, offset := range offsets {
+		pointer, resultCode, err := readMemoryValue(runner.pc, memorySpan{address: address, width: 4})
+		if err != nil || resultCode != 0 {
+			return description, resultCode, 0, err
+		}
+		// nothing is written through a null pointer (e.g. when the game hasn't loaded the thing it points to)
+		if pointer == 0 {
+			return description + " (skipped since the pointer is null)", 0, 0, nil
+		}
+		address = uint32(pointer) + offset
+	}
+	mask := uint64(1)<<(width*8) - 1
+	resultCode, err := runner.write(memorySpan{address: address, width: width}, uint64(line.value)&mask)
+	return description, resultCode, 0, err
+}
+
+func (runner *codeRunner) runBitwise(line codeLine, address uint32) (string, uint8, int, error) {
+	operationType := (line.value >> 16) & 0xFF
+	span := memorySpan{address: address, width: 1}
+	value := uint64(line.value & 0xFF)
+	if operationType%0x10 != 0 || operationType > 0x50 {
+		return fmt.Sprintf("unknown bitwise operation %X", operationType), 255, 0, nil
+	}
+	if (operationType>>4)%2 == 1 {
+		span.width = 2
+		value = uint64(line.value & 0xFFFF)
+	}
+	operations := []string{"OR", "AND", "XOR"}
+	operation := operations[operationType>>5]
+	description := fmt.Sprintf("%v-bit %v of 0x%X at 0x%X", span.width*8, operation, value, address)
+	current, resultCode, err := readMemoryValue(runner.pc, span)
+	if err != nil || resultCode != 0 {
+		return description, resultCode, 0, err
+	}
+	switch operation {
+	case "OR":
+		current |= value
+	case "AND":
+		current &= value
+	case "XOR":
+		current ^= value
+	}
+	resultCode, err = runner.write(span, current)
+	return description, resultCode, 0, err
+}
+
+// skips the lines when the comparison is false
+func (runner *codeRunner) runConditional(span memorySpan, comparison uint32, value uint32, lines int) (string, uint8, int, error) {
+	comparisons := []string{"equal to", "not equal to", "less than", "greater than"}
+	if comparison >= uint32(len(comparisons)) {
+		return fmt.Sprintf("unknown comparison %v", comparison), 255, lines, nil
+	}
+	description := fmt.Sprintf("run the next %v line(s) if the %v-bit value at 0x%X is %v 0x%X", lines, span.width*8, span.address, comparisons[comparison], value)
+	current, resultCode, err := readMemoryValue(runner.pc, span)
+	if err != nil || resultCode != 0 {
+		return description, resultCode, lines, err
+	}
+	var met bool
+	switch comparison {
+	case 0:
+		met = uint32(current) == value
+	case 1:
+		met = uint32(current) != value
+	case 2:
+		met = uint32(current) < value
+	case 3:
+		met = uint32(current) > value
+	}
+	if met {
+		return description, 0, 0, nil
+	}
+	return description, 0, lines, nil
+}
+
+func (runner *codeRunner) write(span memorySpan, value uint64) (uint8, error) {
+	if runner.recordOriginals {
+		if _, found := runner.originals[span]; !found {
+			original, resultCode, err := readMemoryValue(runner.pc, span)
+			if err != nil || resultCode != 0 {
+				return resultCode, err
+			}
+			runner.recordOriginal(span, original)
+		}
+	}
+	return writeMemoryValue(runner.pc, span, value)
+}
+
+// keeps the bytes a copy is about to overwrite
+func (runner *codeRunner) recordBytes(address uint32, length int) {
+	if !runner.recordOriginals {
+		return
+	}
+	bytes, resultCode, err := readMemory(runner.pc, address, length)
+	if err != nil || resultCode != 0 {
+		logger.Debug("could not keep the memory from before a copy", "address", address, "resultCode", resultCode, "err", err)
+		return
+	}
+	for i, value := range bytes {
+		span := memorySpan{address: address + uint32(i), width: 1}
+		if _, found := runner.originals[span]; !found {
+			runner.recordOriginal(span, uint64(value))
+		}
+	}
+}
+
+func (runner *codeRunner) recordOriginal(span memorySpan, value uint64) {
+	if runner.originals == nil {
+		runner.originals = make(map[memorySpan]uint64)
+	}
+	runner.originals[span] = value
+	runner.originalSpans = append(runner.originalSpans, span)
+}
+
+// puts back everything the runner wrote, in reverse so overlapping writes end up with the oldest value
+func (runner *codeRunner) restoreOriginals() (uint8, error) {
+	for i := len(runner.originalSpans) - 1; i >= 0; i-- {
+		span := runner.originalSpans[i]
+		resultCode, err := writeMemoryValue(runner.pc, span, runner.originals[span])
+		if err != nil || resultCode != 0 {
+			return resultCode, err
+		}
+	}
+	return 0, nil
+}
+
+func handleCodesHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling codes HTTP request")
+	// for running codes:
+	// - only POST HTTP requests are supported and the body has the code lines (see the top of this file)
+	// - parameters have to be headers or URL parameters since the body is the codes
+	// - pnach patch lines (e.g. patch=1,EE,2035459C,extended,0000270F) can be mixed in with raw code lines
+	// - every line is checked before any are run, so a typo doesn't leave the codes half applied
+	// - the response has a report entry for every code with the line number, the code, a description of what it
+	//   does and the status (executed, skipped or failed)
+	if httpRequest.Method != http.MethodPost {
+		errMessage := "running codes must use POST"
+		logger.Error(errMessage, "method", httpRequest.Method)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+		return
+	}
+	params := parseHTTPParamsWithoutBody(httpRequest)
+	lines, err := parseCodeLines(http.MaxBytesReader(httpResponseWriter, httpRequest.Body, maxCodesBodySize))
+	if err == nil && len(lines) == 0 {
+		err = errors.New("no code lines found in the body")
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	runner := &codeRunner{pc: pc}
+	report, err := runner.run(lines)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while running codes", err)
+		return
+	}
+	resultCode := failedResultCode(report)
+	sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), map[string]any{
+		"resultCode": resultCode,
+		"lines":      report,
+	})
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	os.Exit(m.Run())
+}
+
+// answers Requests like PCSX2 would from memory that starts at address 0, failing anything past the end of it
+type fakePineSender struct {
+	memory []byte
+}
+
+func newFakePineSender(size int) *fakePineSender {
+	return &fakePineSender{memory: make([]byte, size)}
+}
+
+func (sender *fakePineSender) SendRequest(request PineRequest, answer PineAnswer) error {
+	requestBytes, err := request.toBytes()
+	if err != nil {
+		return err
+	}
+	return answer.fromBytes(sender.answer(requestBytes))
+}
+
+// the bytes of the Answer for the bytes of a Request (or a batch of them)
+func (sender *fakePineSender) answer(requestBytes []byte) []byte {
+	failed := []byte{5, 0, 0, 0, 0xFF}
+	answerBytes := make([]byte, 5)
+	for i := 4; i < len(requestBytes); {
+		opcode := requestBytes[i]
+		address := int(binary.LittleEndian.Uint32(requestBytes[i+1:]))
+		i += 5
+		switch opcode {
+		case 0, 1, 2, 3:
+			width := 1 << opcode
+			if address+width > len(sender.memory) {
+				return failed
+			}
+			answerBytes = append(answerBytes, sender.memory[address:address+width]...)
+		case 4, 5, 6, 7:
+			width := 1 << (opcode - 4)
+			if address+width > len(sender.memory) {
+				return failed
+			}
+			copy(sender.memory[address:], requestBytes[i:i+width])
+			i += width
+		default:
+			return failed
+		}
+	}
+	binary.LittleEndian.PutUint32(answerBytes, uint32(len(answerBytes)))
+	return answerBytes
+}
+
+func (sender *fakePineSender) read32(address uint32) uint32 {
+	return binary.LittleEndian.Uint32(sender.memory[address:])
+}
+
+func runTestCodes(t *testing.T, sender *fakePineSender, codes string) []map[string]any {
+	t.Helper()
+	lines, err := parseCodeLines(strings.NewReader(codes))
+	if err != nil {
+		t.Fatalf("parseCodeLines() error = %v", err)
+	}
+	runner := &codeRunner{pc: sender}
+	report, err := runner.run(lines)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	return report
+}
+
+func reportStatuses(report []map[string]any) []string {
+	var statuses []string
+	for _, entry := range report {
+		statuses = append(statuses, entry["status"].(string))
+	}
+	return statuses
+}
+
+func TestCodeRunnerFailedConditionalSkipsLines(t *testing.T) {
+	// the conditionals read past the end of the fake memory so the emulator fails them
+	tests := []struct {
+		name     string
+		codes    string
+		statuses []string
+		written  []uint32
+		skipped  []uint32
+	}{
+		{
+			name:     "D skips the next line",
+			codes:    "D0FFFFF0 00000001\n20001000 00000063\n20001004 00000001",
+			statuses: []string{"failed", "skipped", "executed"},
+			written:  []uint32{0x1004},
+			skipped:  []uint32{0x1000},
+		},
+		{
+			name:     "E skips the next n lines",
+			codes:    "E0020001 00FFFFF0\n20001000 00000063\n20001004 00000063\n20001008 00000001",
+			statuses: []string{"failed", "skipped", "skipped", "executed"},
+			written:  []uint32{0x1008},
+			skipped:  []uint32{0x1000, 0x1004},
+		},
+		{
+			name:     "C skips the rest of the lines",
+			codes:    "20001000 00000001\nC0FFFFF0 00000001\n20001004 00000063\n20001008 00000063",
+			statuses: []string{"executed", "failed", "skipped", "skipped"},
+			written:  []uint32{0x1000},
+			skipped:  []uint32{0x1004, 0x1008},
+		},
+		{
+			name:     "unknown comparison skips the next line",
+			codes:    "D0001000 00400001\n20001004 00000063\n20001008 00000001",
+			statuses: []string{"failed", "skipped", "executed"},
+			written:  []uint32{0x1008},
+			skipped:  []uint32{0x1004},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sender := newFakePineSender(0x10000)
+			report := runTestCodes(t, sender, test.codes)
+			if got := reportStatuses(report); strings.Join(got, ",") != strings.Join(test.statuses, ",") {
+				t.Errorf("statuses = %v, want %v", got, test.statuses)
+			}
+			if failedResultCode(report) != 0xFF {
+				t.Errorf("failedResultCode() = %v, want 255", failedResultCode(report))
+			}
+			for _, address := range test.written {
+				if sender.read32(address) != 1 {
+					t.Errorf("value at 0x%X = %v, want 1", address, sender.read32(address))
+				}
+			}
+			for _, address := range test.skipped {
+				if sender.read32(address) != 0 {
+					t.Errorf("value at 0x%X = %v, want 0 since it's guarded by the failed conditional", address, sender.read32(address))
+				}
+			}
+		})
+	}
+}
+
+func TestParseCodeLine(t *testing.T) {
+	tests := []struct {
+		text    string
+		address uint32
+		value   uint32
+		width   int
+		wantErr bool
+	}{
+		{text: "2035459C 0000270F", address: 0x2035459C, value: 0x270F},
+		{text: "2035459c 0000270f", address: 0x2035459C, value: 0x270F},
+		{text: "2035459C   0000270F  extra", address: 0x2035459C, value: 0x270F},
+		{text: "patch=1,EE,2035459C,extended,0000270F", address: 0x2035459C, value: 0x270F},
+		{text: "patch=1,EE,0035459C,word,0000270F", address: 0x35459C, value: 0x270F, width: 4},
+		{text: "patch=0,EE,0035459C,byte,0000000F", address: 0x35459C, value: 0xF, width: 1},
+		{text: "2035459C", wantErr: true},
+		{text: "2035459G 0000270F", wantErr: true},
+		{text: "2035459C 1000270F0", wantErr: true},
+		{text: "patch=1,IOP,0035459C,word,0000270F", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			line, err := parseCodeLine(test.text)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseCodeLine() = %+v, want an error", line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCodeLine() error = %v", err)
+			}
+			if line.address != test.address || line.value != test.value {
+				t.Errorf("parseCodeLine() = %08X %08X, want %08X %08X", line.address, line.value, test.address, test.value)
+			}
+			width := 0
+			if line.span != nil {
+				width = line.span.width
+			}
+			if width != test.width {
+				t.Errorf("width of the span = %v, want %v", width, test.width)
+			}
+		})
+	}
+}
+
+func TestCodeLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		codes   []string
+		length  int
+		wantErr bool
+	}{
+		{name: "8-bit write", codes: []string{"0035459C 000000FF"}, length: 1},
+		{name: "16-bit increment", codes: []string{"30200001 0035459C"}, length: 1},
+		{name: "32-bit increment", codes: []string{"30400000 0035459C", "00000001 00000000"}, length: 2},
+		{name: "unknown increment", codes: []string{"30600000 0035459C"}, wantErr: true},
+		{name: "multi-write", codes: []string{"4035459C 00000001", "00040001 00000000"}, length: 2},
+		{name: "multi-write without its second line", codes: []string{"4035459C 00000001"}, wantErr: true},
+		{name: "copy", codes: []string{"5035459C 00000010", "00360000 00000000"}, length: 2},
+		{name: "pointer with one offset", codes: []string{"6035459C 00000001", "00020001 00000010"}, length: 2},
+		{name: "pointer with two offsets", codes: []string{"6035459C 00000001", "00020002 00000010", "00000020 00000000"}, length: 3},
+		{name: "pointer with three offsets", codes: []string{"6035459C 00000001", "00020003 00000010", "00000020 00000030"}, length: 3},
+		{name: "pointer with four offsets", codes: []string{"6035459C 00000001", "00020004 00000010", "00000020 00000030", "00000040 00000000"}, length: 4},
+		{name: "pointer missing an offset line", codes: []string{"6035459C 00000001", "00020002 00000010"}, wantErr: true},
+		{name: "pointer without offsets", codes: []string{"6035459C 00000001", "00020000 00000000"}, wantErr: true},
+		{name: "pointer without its second line", codes: []string{"6035459C 00000001"}, wantErr: true},
+		{name: "bitwise", codes: []string{"7035459C 001000FF"}, length: 1},
+		{name: "conditional", codes: []string{"E0020001 0035459C"}, length: 1},
+		{name: "unknown code type", codes: []string{"8035459C 00000001"}, wantErr: true},
+		{name: "pnach word", codes: []string{"patch=1,EE,0035459C,word,0000270F"}, length: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lines []codeLine
+			for _, text := range test.codes {
+				line, err := parseCodeLine(text)
+				if err != nil {
+					t.Fatalf("parseCodeLine() error = %v", err)
+				}
+				lines = append(lines, line)
+			}
+			length, err := codeLength(lines, 0)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("codeLength() = %v, want an error", length)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("codeLength() error = %v", err)
+			}
+			if length != test.length {
+				t.Errorf("codeLength() = %v, want %v", length, test.length)
+			}
+		})
+	}
+}
+
+func TestCheckCodeLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		codes   string
+		wantErr string
+	}{
+		{name: "single lines", codes: "2035459C 0000270F\n0035459D 00000001"},
+		{name: "multi-line codes", codes: "4035459C 00000001\n00040001 00000000\n6035459C 00000001\n00020001 00000010"},
+		{name: "pnach and raw lines", codes: "patch=1,EE,2035459C,extended,0000270F\n1035459C 00000001"},
+		{name: "unknown code type after a multi-line code", codes: "4035459C 00000001\n00040001 00000000\nA035459C 00000001", wantErr: "line 3"},
+		{name: "truncated code at the end", codes: "2035459C 0000270F\n5035459C 00000010", wantErr: "line 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCodeLines(strings.NewReader(test.codes))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseCodeLines() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Fatalf("parseCodeLines() error = %v, want one starting with %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCodeRunner(t *testing.T) {
+	tests := []struct {
+		name string
+		// 32-bit values in memory before the codes run
+		memory   map[uint32]uint32
+		codes    string
+		want     map[uint32]uint32
+		statuses []string
+	}{
+		{
+			name:     "writes",
+			codes:    "00001000 000012AB\n10001004 1234ABCD\n20001008 1234ABCD",
+			want:     map[uint32]uint32{0x1000: 0xAB, 0x1004: 0xABCD, 0x1008: 0x1234ABCD},
+			statuses: []string{"executed", "executed", "executed"},
+		},
+		{
+			name:     "increments and decrements",
+			memory:   map[uint32]uint32{0x1000: 10, 0x1004: 0x1000, 0x1008: 0x100, 0x100C: 0xFFFFFFFF},
+			codes:    "30000005 00001000\n30300100 00001004\n30400000 00001008\n00000010 00000000\n30500000 0000100C\n00000001 00000000",
+			want:     map[uint32]uint32{0x1000: 15, 0x1004: 0xF00, 0x1008: 0x110, 0x100C: 0xFFFFFFFE},
+			statuses: []string{"executed", "executed", "executed", "executed"},
+		},
+		{
+			name:     "multi-write",
+			codes:    "40001000 00000001\n00030002 00000005",
+			want:     map[uint32]uint32{0x1000: 1, 0x1004: 0, 0x1008: 6, 0x1010: 11, 0x1018: 0},
+			statuses: []string{"executed"},
+		},
+		{
+			name:     "copy",
+			memory:   map[uint32]uint32{0x1000: 0x11223344, 0x1004: 0x55667788},
+			codes:    "50001000 00000006\n00002001 00000000",
+			want:     map[uint32]uint32{0x2000: 0x22334400, 0x2004: 0x00778811},
+			statuses: []string{"executed"},
+		},
+		{
+			name:     "pointer write",
+			memory:   map[uint32]uint32{0x1000: 0x2000, 0x2010: 0x3000},
+			codes:    "60001000 0000ABCD\n00010002 00000010\n00000020 00000000",
+			want:     map[uint32]uint32{0x3020: 0xABCD},
+			statuses: []string{"executed"},
+		},
+		{
+			name:     "null pointer",
+			memory:   map[uint32]uint32{0x1000: 0},
+			codes:    "60001000 0000ABCD\n00020001 00000010",
+			want:     map[uint32]uint32{0x10: 0},
+			statuses: []string{"executed"},
+		},
+		{
+			name:     "bitwise operations",
+			memory:   map[uint32]uint32{0x1000: 0x0F, 0x1004: 0xFF, 0x1008: 0xF0F0},
+			codes:    "70001000 000000F0\n70001004 0020000F\n70001008 0050FFFF",
+			want:     map[uint32]uint32{0x1000: 0xFF, 0x1004: 0x0F, 0x1008: 0x0F0F},
+			statuses: []string{"executed", "executed", "executed"},
+		},
+		{
+			name:     "D met runs the next line",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "D0001000 00000005\n20001004 00000001\n20001008 00000001",
+			want:     map[uint32]uint32{0x1004: 1, 0x1008: 1},
+			statuses: []string{"executed", "executed", "executed"},
+		},
+		{
+			name:     "D not met skips the next line",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "D0001000 00100005\n20001004 00000001\n20001008 00000001",
+			want:     map[uint32]uint32{0x1004: 0, 0x1008: 1},
+			statuses: []string{"executed", "skipped", "executed"},
+		},
+		{
+			name:     "8-bit D compares only the low byte",
+			memory:   map[uint32]uint32{0x1000: 0x1205},
+			codes:    "D0001000 00010005\n20001004 00000001",
+			want:     map[uint32]uint32{0x1004: 1},
+			statuses: []string{"executed", "executed"},
+		},
+		{
+			name:     "D not met skips the whole multi-line code after it",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "D0001000 00200005\n40001004 00000001\n00020001 00000000\n2000100C 00000001",
+			want:     map[uint32]uint32{0x1004: 0, 0x1008: 0, 0x100C: 1},
+			statuses: []string{"executed", "skipped", "executed"},
+		},
+		{
+			name:     "E not met skips n lines",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "E0020006 00001000\n20001004 00000001\n20001008 00000001\n2000100C 00000001",
+			want:     map[uint32]uint32{0x1004: 0, 0x1008: 0, 0x100C: 1},
+			statuses: []string{"executed", "skipped", "skipped", "executed"},
+		},
+		{
+			name:     "E greater than",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "E1020004 30001000\n20001004 00000001\n20001008 00000001",
+			want:     map[uint32]uint32{0x1004: 1, 0x1008: 1},
+			statuses: []string{"executed", "executed", "executed"},
+		},
+		{
+			name:     "C not met skips the rest",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "C0001000 00000006\n20001004 00000001\n20001008 00000001",
+			want:     map[uint32]uint32{0x1004: 0, 0x1008: 0},
+			statuses: []string{"executed", "skipped", "skipped"},
+		},
+		{
+			name:     "C met runs the rest",
+			memory:   map[uint32]uint32{0x1000: 5},
+			codes:    "C0001000 00000005\n20001004 00000001\n20001008 00000001",
+			want:     map[uint32]uint32{0x1004: 1, 0x1008: 1},
+			statuses: []string{"executed", "executed", "executed"},
+		},
+		{
+			name:     "failed write keeps going",
+			codes:    "200FFFF0 00000001\n20001004 00000001",
+			want:     map[uint32]uint32{0x1004: 1},
+			statuses: []string{"failed", "executed"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sender := newFakePineSender(0x10000)
+			for address, value := range test.memory {
+				binary.LittleEndian.PutUint32(sender.memory[address:], value)
+			}
+			report := runTestCodes(t, sender, test.codes)
+			if got := reportStatuses(report); strings.Join(got, ",") != strings.Join(test.statuses, ",") {
+				t.Errorf("statuses = %v, want %v", got, test.statuses)
+			}
+			for address, value := range test.want {
+				if sender.read32(address) != value {
+					t.Errorf("value at 0x%X = 0x%X, want 0x%X", address, sender.read32(address), value)
+				}
+			}
+		})
+	}
+}
+
+func TestCodeRunnerRestoreOriginals(t *testing.T) {
+	sender := newFakePineSender(0x10000)
+	binary.LittleEndian.PutUint32(sender.memory[0x1000:], 7)
+	lines, err := parseCodeLines(strings.NewReader("20001000 00000001\n20001000 00000002\n00001004 00000003"))
+	if err != nil {
+		t.Fatalf("parseCodeLines() error = %v", err)
+	}
+	runner := &codeRunner{pc: sender, recordOriginals: true}
+	_, err = runner.run(lines)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if sender.read32(0x1000) != 2 || sender.read32(0x1004) != 3 {
+		t.Fatalf("values after run = %v and %v, want 2 and 3", sender.read32(0x1000), sender.read32(0x1004))
+	}
+	resultCode, err := runner.restoreOriginals()
+	if err != nil || resultCode != 0 {
+		t.Fatalf("restoreOriginals() = %v, %v", resultCode, err)
+	}
+	if sender.read32(0x1000) != 7 || sender.read32(0x1004) != 0 {
+		t.Errorf("values after restoreOriginals() = %v and %v, want 7 and 0", sender.read32(0x1000), sender.read32(0x1004))
+	}
+}
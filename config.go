@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// settings can come from (in order of precedence, highest first):
+// - command line flags (e.g. -port 6670)
+// - environment variables (e.g. WOODY_PORT=6670)
+// - the JSON config file (woody.json in the working directory unless -config or WOODY_CONFIG say otherwise)
+// - the defaults in defaultConfig
+type Config struct {
+	ListenAddress string         `json:"listenAddress"`
+	Port          int            `json:"port"`
+	Targets       []TargetConfig `json:"targets"`
+	// the target (e.g. "pcsx2" or "pcsx2:28011") used for requests that don't have a Woody-Target
+	DefaultTarget string `json:"defaultTarget"`
+	// how long to wait for the emulator to answer a Request
+	Timeout Duration `json:"timeout"`
+	// how often to check that the emulators are still there
+	SupervisorInterval Duration `json:"supervisorInterval"`
+	// how often watched values are sampled
+	WatchInterval Duration `json:"watchInterval"`
+	// the directory with the per-game profiles of named variables
+	ProfilesDirectory string `json:"profilesDirectory"`
+	// the directory with the .pnach cheat files
+	CheatsDirectory string `json:"cheatsDirectory"`
+	// how often the continuous patches of enabled cheats are written again
+	CheatInterval Duration `json:"cheatInterval"`
+	// how often frozen values are written again
+	FreezeInterval Duration `json:"freezeInterval"`
+	// the directory that scan sessions are saved in
+	ScansDirectory string `json:"scansDirectory"`
+	// the directory that snapshots are saved in
+	SnapshotsDirectory string `json:"snapshotsDirectory"`
+	// the web page origins (e.g. "http://localhost:8080") that can use the WebSocket API besides Woody's own
+	AllowedOrigins []string `json:"allowedOrigins"`
+	LogLevel       string   `json:"logLevel"`
+	// same format as the GOMEMLIMIT environment variable (e.g. "64MiB" or "off")
+	GoMemLimit string `json:"goMemLimit"`
+	// same as the GOGC environment variable with -1 meaning off
+	GoGC int `json:"goGC"`
+}
+
+type TargetConfig struct {
+	Target string `json:"target"`
+	// zero means the default slot for the target
+	Slot uint16 `json:"slot"`
+	// overrides the socket file (Mac and Linux) or host:port (Windows) that is found from the target and slot
+	SocketPath string `json:"socketPath"`
+}
+
+// a time.Duration that is written as a string (e.g. "15s") in the config file
+type Duration time.Duration
+
+func (duration *Duration) UnmarshalJSON(bytes []byte) error {
+	var durationString string
+	err := json.Unmarshal(bytes, &durationString)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(durationString)
+	if err != nil {
+		return err
+	}
+	*duration = Duration(parsed)
+	return nil
+}
+
+func (duration Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(duration).String())
+}
+
+func defaultConfig() *Config {
+	config := &Config{
+		ListenAddress:      "localhost",
+		Port:               6669,
+		Timeout:            Duration(defaultPineTimeout),
+		SupervisorInterval: Duration(5 * time.Second),
+		WatchInterval:      Duration(100 * time.Millisecond),
+		CheatInterval:      Duration(100 * time.Millisecond),
+		FreezeInterval:     Duration(100 * time.Millisecond),
+		LogLevel:           "info",
+		GoMemLimit:         "64MiB",
+		GoGC:               10,
+	}
+	for _, target := range sortedTargetNames() {
+		config.Targets = append(config.Targets, TargetConfig{Target: target})
+	}
+	return config
+}
+
+// every setting that can be given as a flag or an environment variable
+type configOption struct {
+	flagName string
+	envVar   string
+	usage    string
+	set      func(config *Config, value string) error
+}
+
+var configOptions = []configOption{
+	{"listen-address", "WOODY_LISTEN_ADDRESS", "the address to listen for API requests on", func(config *Config, value string) error {
+		config.ListenAddress = value
+		return nil
+	}},
+	{"port", "WOODY_PORT", "the port to listen for API requests on", func(config *Config, value string) error {
+		port, err := strconv.ParseUint(value, 10, 16)
+		config.Port = int(port)
+		return err
+	}},
+	{"targets", "WOODY_TARGETS", "comma separated targets to connect to, each as target[:slot][=socketPath] (e.g. pcsx2,rpcs3:28013)", func(config *Config, value string) error {
+		targets, err := parseTargets(value)
+		config.Targets = targets
+		return err
+	}},
+	{"default-target", "WOODY_DEFAULT_TARGET", "the target used for requests without a Woody-Target (e.g. pcsx2 or pcsx2:28011)", func(config *Config, value string) error {
+		config.DefaultTarget = value
+		return nil
+	}},
+	{"timeout", "WOODY_TIMEOUT", "how long to wait for the emulator to answer (e.g. 15s)", func(config *Config, value string) error {
+		timeout, err := time.ParseDuration(value)
+		config.Timeout = Duration(timeout)
+		return err
+	}},
+	{"supervisor-interval", "WOODY_SUPERVISOR_INTERVAL", "how often to check that the emulators are still there (e.g. 5s)", func(config *Config, value string) error {
+		interval, err := time.ParseDuration(value)
+		config.SupervisorInterval = Duration(interval)
+		return err
+	}},
+	{"watch-interval", "WOODY_WATCH_INTERVAL", "how often watched values are sampled (e.g. 100ms)", func(config *Config, value string) error {
+		interval, err := time.ParseDuration(value)
+		config.WatchInterval = Duration(interval)
+		return err
+	}},
+	{"profiles-directory", "WOODY_PROFILES_DIRECTORY", "the directory with the per-game profiles (defaults to profiles if it exists)", func(config *Config, value string) error {
+		config.ProfilesDirectory = value
+		return nil
+	}},
+	{"cheats-directory", "WOODY_CHEATS_DIRECTORY", "the directory with the .pnach cheat files (defaults to cheats if it exists)", func(config *Config, value string) error {
+		config.CheatsDirectory = value
+		return nil
+	}},
+	{"cheat-interval", "WOODY_CHEAT_INTERVAL", "how often enabled cheats are written again (e.g. 100ms)", func(config *Config, value string) error {
+		interval, err := time.ParseDuration(value)
+		config.CheatInterval = Duration(interval)
+		return err
+	}},
+	{"freeze-interval", "WOODY_FREEZE_INTERVAL", "how often frozen values are written again (e.g. 100ms)", func(config *Config, value string) error {
+		interval, err := time.ParseDuration(value)
+		config.FreezeInterval = Duration(interval)
+		return err
+	}},
+	{"scans-directory", "WOODY_SCANS_DIRECTORY", "the directory that scan sessions are saved in (defaults to scans)", func(config *Config, value string) error {
+		config.ScansDirectory = value
+		return nil
+	}},
+	{"snapshots-directory", "WOODY_SNAPSHOTS_DIRECTORY", "the directory that snapshots are saved in (defaults to snapshots)", func(config *Config, value string) error {
+		config.SnapshotsDirectory = value
+		return nil
+	}},
+	{"allowed-origins", "WOODY_ALLOWED_ORIGINS", "comma separated web page origins that can use the WebSocket API (e.g. http://localhost:8080)", func(config *Config, value string) error {
+		config.AllowedOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			origin = strings.TrimSpace(origin)
+			if origin != "" {
+				config.AllowedOrigins = append(config.AllowedOrigins, origin)
+			}
+		}
+		return nil
+	}},
+	{"log-level", "WOODY_LOG_LEVEL", "one of debug, info, warn or error", func(config *Config, value string) error {
+		config.LogLevel = value
+		return nil
+	}},
+	{"go-mem-limit", "GOMEMLIMIT", "the soft memory limit for the Go runtime (e.g. 64MiB or off)", func(config *Config, value string) error {
+		config.GoMemLimit = value
+		return nil
+	}},
+	{"go-gc", "GOGC", "the garbage collection target percentage for the Go runtime (e.g. 10 or off)", func(config *Config, value string) error {
+		if strings.ToLower(value) == "off" {
+			config.GoGC = -1
+			return nil
+		}
+		goGC, err := strconv.Atoi(value)
+		config.GoGC = goGC
+		return err
+	}},
+}
+
+func loadConfig(args []string) (*Config, error) {
+	config := defaultConfig()
+
+	flagSet := flag.NewFlagSet("woody", flag.ContinueOnError)
+	configPathFlag := flagSet.String("config", "", "the JSON config file to load (defaults to woody.json if it exists)")
+	for _, option := range configOptions {
+		flagSet.String(option.flagName, "", option.usage+" (env "+option.envVar+")")
+	}
+	err := flagSet.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// the config file
+	configPath := *configPathFlag
+	if configPath == "" {
+		configPath = os.Getenv("WOODY_CONFIG")
+	}
+	configPathRequired := configPath != ""
+	if configPath == "" {
+		configPath = "woody.json"
+	}
+	err = config.loadFile(configPath)
+	if errors.Is(err, fs.ErrNotExist) && !configPathRequired {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load config file \"%v\": %w", configPath, err)
+	}
+
+	// then environment variables
+	for _, option := range configOptions {
+		value := os.Getenv(option.envVar)
+		if value == "" {
+			continue
+		}
+		err = option.set(config, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value \"%v\" for the %v environment variable: %w", value, option.envVar, err)
+		}
+	}
+
+	// and flags last (only the ones that were given)
+	flagSet.Visit(func(visited *flag.Flag) {
+		for _, option := range configOptions {
+			if option.flagName != visited.Name || err != nil {
+				continue
+			}
+			value := visited.Value.String()
+			setErr := option.set(config, value)
+			if setErr != nil {
+				err = fmt.Errorf("invalid value \"%v\" for the -%v flag: %w", value, option.flagName, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return config, config.validate()
+}
+
+func (config *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+func (config *Config) validate() error {
+	if config.Port <= 0 || config.Port > math.MaxUint16 {
+		return fmt.Errorf("invalid port %v", config.Port)
+	}
+	if len(config.Targets) == 0 {
+		return errors.New("no targets to connect to")
+	}
+	for _, target := range config.Targets {
+		if target.Target == "" {
+			return errors.New("empty target name in targets")
+		}
+		if target.Slot == 0 && target.SocketPath == "" {
+			if _, found := defaultSlotForTargetMap[target.Target]; !found {
+				return fmt.Errorf("no slot or socket path for unknown target \"%v\"", target.Target)
+			}
+		}
+	}
+	if config.Timeout <= 0 {
+		return errors.New("timeout must be greater than zero")
+	}
+	if config.SupervisorInterval <= 0 {
+		return errors.New("supervisor interval must be greater than zero")
+	}
+	if config.WatchInterval <= 0 {
+		return errors.New("watch interval must be greater than zero")
+	}
+	if config.CheatInterval <= 0 {
+		return errors.New("cheat interval must be greater than zero")
+	}
+	if config.FreezeInterval <= 0 {
+		return errors.New("freeze interval must be greater than zero")
+	}
+	_, err := parseLogLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	_, err = parseMemoryLimit(config.GoMemLimit)
+	return err
+}
+
+func (config *Config) ListenHostPort() string {
+	return net.JoinHostPort(config.ListenAddress, strconv.Itoa(config.Port))
+}
+
+// parses a comma separated list of target[:slot][=socketPath]
+func parseTargets(value string) ([]TargetConfig, error) {
+	var targets []TargetConfig
+	for _, targetString := range strings.Split(value, ",") {
+		targetString = strings.TrimSpace(targetString)
+		if targetString == "" {
+			continue
+		}
+		var target TargetConfig
+		targetString, target.SocketPath, _ = strings.Cut(targetString, "=")
+		var slotString string
+		var hasSlot bool
+		target.Target, slotString, hasSlot = strings.Cut(targetString, ":")
+		target.Target = strings.ToLower(target.Target)
+		if hasSlot {
+			slot, err := strconv.ParseUint(slotString, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid slot \"%v\" for target \"%v\"", slotString, target.Target)
+			}
+			target.Slot = uint16(slot)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// parses the same format as the GOMEMLIMIT environment variable
+func parseMemoryLimit(value string) (int64, error) {
+	if value == "" || strings.ToLower(value) == "off" {
+		return math.MaxInt64, nil
+	}
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"TiB", 1 << 40},
+		{"GiB", 1 << 30},
+		{"MiB", 1 << 20},
+		{"KiB", 1 << 10},
+		{"B", 1},
+	}
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid memory limit \"%v\"", value)
+	}
+	return number * multiplier, nil
+}
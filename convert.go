@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// converts PS2 codes from the formats they're published in to the raw lines that the code interpreter runs (see
+// codes.go) or to pnach cheats that can go in the cheats directory
+
+// the biggest block of codes we'll convert
+const maxConvertBodySize = 1024 * 1024
+
+type codeFormat struct {
+	name        string
+	description string
+	// other names for the format (e.g. "ar2" for "gameshark2")
+	aliases []string
+	// turns the lines into raw lines, or is nil for the encrypted formats
+	decrypt func(lines []codeLine) ([]codeLine, error)
+	// whether a line is written like a code in this format rather than like a cheat name
+	isCode func(text string) bool
+}
+
+// a named group of converted lines
+type convertedCheat struct {
+	name  string
+	lines []codeLine
+}
+
+// a line that was left out of the converted codes along with why
+type droppedCodeLine struct {
+	line   codeLine
+	reason string
+}
+
+var ErrDecryptionUnsupported = errors.New("decryption isn't supported")
+
+var hexCodePattern = regexp.MustCompile(`(?i)^[0-9A-F]{8}\s+[0-9A-F]{8}\b`)
+var armaxCodePattern = regexp.MustCompile(`(?i)^[0-9A-Z]{4}-[0-9A-Z]{4}-[0-9A-Z]{5}$`)
+
+var codeFormats = []codeFormat{
+	{
+		name:        "raw",
+		description: "decrypted CodeBreaker, GameShark 2 and Action Replay 2 codes (they all use the same raw format)",
+		aliases:     []string{"decrypted"},
+		decrypt:     func(lines []codeLine) ([]codeLine, error) { return lines, nil },
+		isCode:      hexCodePattern.MatchString,
+	},
+}
+
+// the encrypted formats can't be decrypted, so they aren't listed as supported. They're still known by name (and found
+// by auto) so that they get an error saying to use the raw codes rather than being converted as if they were raw
+var encryptedCodeFormats = []codeFormat{
+	{
+		name:        "codebreaker",
+		description: "encrypted CodeBreaker v1 to v7 codes",
+		aliases:     []string{"cb"},
+		isCode:      hexCodePattern.MatchString,
+	},
+	{
+		name:        "gameshark2",
+		description: "encrypted GameShark 2 and Action Replay 2 codes",
+		aliases:     []string{"gs2", "actionreplay2", "ar2"},
+		isCode:      hexCodePattern.MatchString,
+	},
+	{
+		name:        "armax",
+		description: "encrypted Action Replay MAX codes (e.g. ABCD-EFGH-IJKLM)",
+		aliases:     []string{"actionreplaymax", "arm"},
+		isCode:      armaxCodePattern.MatchString,
+	},
+}
+
+// finds the format by its name or one of its aliases
+func parseCodeFormat(name string) (*codeFormat, error) {
+	normalized := normalizeParamKey(name)
+	var names []string
+	for i, format := range codeFormats {
+		if format.matches(normalized) {
+			return &codeFormats[i], nil
+		}
+		names = append(names, format.name)
+	}
+	for i, format := range encryptedCodeFormats {
+		if format.matches(normalized) {
+			return &encryptedCodeFormats[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown code format \"%v\". Supported values are auto, %v", name, strings.Join(names, ", "))
+}
+
+func (format codeFormat) matches(normalized string) bool {
+	return normalized == format.name || slices.Contains(format.aliases, normalized)
+}
+
+// guesses the format from what the codes look like. Encrypted CodeBreaker and GameShark 2 codes look just like raw
+// codes, so they're only found by the codes that the devices use to set up their encryption
+func detectCodeFormat(texts []string) *codeFormat {
+	formatName := "raw"
+	for _, text := range texts {
+		upper := strings.ToUpper(text)
+		if armaxCodePattern.MatchString(text) {
+			formatName = "armax"
+		} else if strings.HasPrefix(upper, "BEEFC0DE") || strings.HasPrefix(upper, "BEEFC0DF") {
+			formatName = "codebreaker"
+		} else if strings.HasPrefix(upper, "0E3C7DF2") {
+			formatName = "gameshark2"
+		} else {
+			continue
+		}
+		break
+	}
+	format, _ := parseCodeFormat(formatName)
+	return format
+}
+
+// reads codes as they're usually pasted, with the name of each cheat on the line before its codes (or in [brackets]
+// like a pnach group). Pnach patch lines can be mixed in with any format. Codes before the first name go under
+// defaultName
+func convertCodes(reader io.Reader, formatName string, defaultName string) (*codeFormat, []convertedCheat, []droppedCodeLine, error) {
+	var texts []string
+	var lineNumbers []int
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "//") || strings.HasPrefix(text, ";") || strings.HasPrefix(text, "#") {
+			continue
+		}
+		texts = append(texts, text)
+		lineNumbers = append(lineNumbers, lineNumber)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var format *codeFormat
+	if formatName == "" || normalizeParamKey(formatName) == "auto" {
+		format = detectCodeFormat(texts)
+	} else {
+		format, err = parseCodeFormat(formatName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if format.decrypt == nil {
+		return format, nil, nil, fmt.Errorf("%w for %v. Use the raw (decrypted) version of the codes instead", ErrDecryptionUnsupported, format.description)
+	}
+
+	// group the lines by cheat
+	var cheats []convertedCheat
+	name := defaultName
+	var lines []codeLine
+	addCheat := func() {
+		if len(lines) > 0 {
+			cheats = append(cheats, convertedCheat{name: name, lines: lines})
+		}
+		lines = nil
+	}
+	for i, text := range texts {
+		key, _, found := strings.Cut(text, "=")
+		if format.isCode(text) || (found && strings.ToLower(strings.TrimSpace(key)) == "patch") {
+			line, err := parseCodeLine(text)
+			if err != nil {
+				return format, nil, nil, fmt.Errorf("line %v: %w", lineNumbers[i], err)
+			}
+			line.lineNumber = lineNumbers[i]
+			lines = append(lines, line)
+			continue
+		}
+		addCheat()
+		name = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "["), "]"))
+	}
+	addCheat()
+
+	var dropped []droppedCodeLine
+	for i := range cheats {
+		decrypted, err := format.decrypt(cheats[i].lines)
+		if err != nil {
+			return format, nil, nil, err
+		}
+		kept, droppedLines, err := dropDeviceOnlyCodes(decrypted)
+		if err == nil {
+			err = checkCodeLines(kept)
+		}
+		if err != nil {
+			return format, nil, nil, fmt.Errorf("cheat \"%v\": %w", cheats[i].name, err)
+		}
+		cheats[i].lines = kept
+		dropped = append(dropped, droppedLines...)
+	}
+	// a cheat can be left with nothing once the master codes are gone
+	var nonEmpty []convertedCheat
+	for _, cheat := range cheats {
+		if len(cheat.lines) > 0 {
+			nonEmpty = append(nonEmpty, cheat)
+		}
+	}
+	return format, nonEmpty, dropped, nil
+}
+
+// goes through the lines a whole code at a time, so a line after the first (e.g. the second line of a 4 code) is never
+// taken for a device only code, and keeps or drops every line of each code together
+func dropDeviceOnlyCodes(lines []codeLine) ([]codeLine, []droppedCodeLine, error) {
+	var kept []codeLine
+	var dropped []droppedCodeLine
+	for i := 0; i < len(lines); {
+		reason := deviceOnlyCodeReason(lines[i])
+		if reason == "" {
+			length, err := codeLength(lines, i)
+			if err != nil {
+				return nil, nil, err
+			}
+			kept = append(kept, lines[i:i+length]...)
+			i += length
+			continue
+		}
+		length := 1
+		if lines[i].address == 0xBEEFC0DF && i+1 < len(lines) {
+			// the CodeBreaker v7 key code has its seed on a second line
+			length = 2
+		}
+		for _, line := range lines[i : i+length] {
+			dropped = append(dropped, droppedCodeLine{line: line, reason: reason})
+		}
+		i += length
+	}
+	return kept, dropped, nil
+}
+
+// the cheat devices need master codes to hook into the game and key codes for their encryption, which don't mean
+// anything when writing over PINE
+func deviceOnlyCodeReason(line codeLine) string {
+	if line.span != nil {
+		return ""
+	}
+	switch line.address >> 28 {
+	case 0x9, 0xF:
+		return "master (hook) codes are only needed by the cheat device"
+	case 0xB:
+		if line.address == 0xBEEFC0DE || line.address == 0xBEEFC0DF {
+			return "CodeBreaker key codes are only needed by the cheat device"
+		}
+	}
+	return ""
+}
+
+// the raw lines of the cheat (e.g. "2035459C 0000270F")
+func (cheat convertedCheat) rawLines() []string {
+	var texts []string
+	for _, line := range cheat.lines {
+		if line.span != nil {
+			// pnach patches that aren't extended stay as they were
+			texts = append(texts, line.text)
+			continue
+		}
+		texts = append(texts, fmt.Sprintf("%08X %08X", line.address, line.value))
+	}
+	return texts
+}
+
+// the cheat as a pnach group, with every line applied continuously like PCSX2 does for most cheats
+func (cheat convertedCheat) pnach() string {
+	var builder strings.Builder
+	builder.WriteString("[" + cheat.name + "]\n")
+	for _, line := range cheat.lines {
+		if line.span != nil {
+			builder.WriteString(line.text + "\n")
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("patch=1,EE,%08X,extended,%08X\n", line.address, line.value))
+	}
+	return builder.String()
+}
+
+func formatPnach(cheats []convertedCheat) string {
+	var groups []string
+	for _, cheat := range cheats {
+		groups = append(groups, cheat.pnach())
+	}
+	return strings.Join(groups, "\n")
+}
+
+func (dropped droppedCodeLine) toResult() map[string]any {
+	return map[string]any{
+		"line":   dropped.line.lineNumber,
+		"code":   dropped.line.text,
+		"reason": dropped.reason,
+	}
+}
+
+func handleConvertHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling convert HTTP request")
+	// for converting codes:
+	// - only POST HTTP requests are supported and the body has the codes, with the name of each cheat on the line
+	//   before its codes
+	// - parameters have to be headers or URL parameters since the body is the codes
+	// - Woody-Format is the format of the codes (auto by default, which can't tell encrypted CodeBreaker and
+	//   GameShark 2 codes from raw codes unless they start with the code that sets up the encryption)
+	// - Woody-Name is the name for codes that come before the first name
+	// - encrypted codes (codebreaker, gameshark2 or armax, or found by auto) can't be decrypted and get a 501 (Not
+	//   Implemented) HTTP response code
+	// - nothing is sent to the emulator, the codes can be run with /codes or saved in the cheats directory
+	if httpRequest.Method != http.MethodPost {
+		errMessage := "converting codes must use POST"
+		logger.Error(errMessage, "method", httpRequest.Method)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+		return
+	}
+	params := parseHTTPParamsWithoutBody(httpRequest)
+	name := params["woodyname"]
+	if name == "" {
+		name = "Converted"
+	}
+	format, cheats, dropped, err := convertCodes(http.MaxBytesReader(httpResponseWriter, httpRequest.Body, maxConvertBodySize), params["woodyformat"], name)
+	if err == nil && len(cheats) == 0 {
+		err = errors.New("no codes found in the body")
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		statusCode := 400
+		if errors.Is(err, ErrDecryptionUnsupported) {
+			statusCode = 501
+		}
+		sendHTTPError(httpResponseWriter, statusCode, errMessage)
+		return
+	}
+
+	cheatResults := []map[string]any{}
+	for _, cheat := range cheats {
+		cheatResults = append(cheatResults, map[string]any{
+			"name":  cheat.name,
+			"lines": cheat.rawLines(),
+		})
+	}
+	droppedResults := []map[string]any{}
+	for _, droppedLine := range dropped {
+		droppedResults = append(droppedResults, droppedLine.toResult())
+	}
+	sendHTTPJSON(httpResponseWriter, 200, map[string]any{
+		"format":  format.name,
+		"cheats":  cheatResults,
+		"pnach":   formatPnach(cheats),
+		"dropped": droppedResults,
+	})
+}
+
+// woody convert [flags] [file] converts the codes in the file (or from stdin) and prints them
+func runConvertCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flagSet := flag.NewFlagSet("woody convert", flag.ContinueOnError)
+	flagSet.SetOutput(stderr)
+	formatFlag := flagSet.String("format", "auto", "the format of the codes")
+	outputFlag := flagSet.String("output", "pnach", "what to print, either pnach or raw")
+	nameFlag := flagSet.String("name", "Converted", "the name for codes that come before the first name")
+	flagSet.Usage = func() {
+		fmt.Fprintln(stderr, "usage: woody convert [flags] [file]")
+		fmt.Fprintln(stderr, "converts the codes in the file (or from stdin when there isn't one)")
+		flagSet.PrintDefaults()
+		fmt.Fprintln(stderr, "formats:")
+		for _, format := range codeFormats {
+			fmt.Fprintf(stderr, "  %v: %v\n", format.name, format.description)
+		}
+		fmt.Fprintln(stderr, "encrypted codes (CodeBreaker, GameShark 2, Action Replay 2 and Action Replay MAX) can't be decrypted,")
+		fmt.Fprintln(stderr, "so use their raw (decrypted) version")
+	}
+	err := flagSet.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if *outputFlag != "pnach" && *outputFlag != "raw" {
+		fmt.Fprintln(stderr, "unknown output \""+*outputFlag+"\". Supported values are pnach and raw")
+		return 2
+	}
+	input := stdin
+	if flagSet.NArg() > 0 {
+		file, err := os.Open(flagSet.Arg(0))
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	_, cheats, dropped, err := convertCodes(input, *formatFlag, *nameFlag)
+	if err == nil && len(cheats) == 0 {
+		err = errors.New("no codes found")
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for _, droppedLine := range dropped {
+		fmt.Fprintf(stderr, "dropped line %v (%v): %v\n", droppedLine.line.lineNumber, droppedLine.line.text, droppedLine.reason)
+	}
+	if *outputFlag == "pnach" {
+		fmt.Fprint(stdout, formatPnach(cheats))
+		return 0
+	}
+	for i, cheat := range cheats {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintln(stdout, cheat.name)
+		for _, text := range cheat.rawLines() {
+			fmt.Fprintln(stdout, text)
+		}
+	}
+	return 0
+}
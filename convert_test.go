@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConvertCodes(t *testing.T) {
+	tests := []struct {
+		name        string
+		codes       string
+		wantLines   map[string][]string
+		wantDropped []int
+	}{
+		{
+			name:        "master code",
+			codes:       "Infinite HP\n9029B4F8 0C0A7C3E\n2035459C 0000270F\n",
+			wantLines:   map[string][]string{"Infinite HP": {"2035459C 0000270F"}},
+			wantDropped: []int{2},
+		},
+		{
+			// the second line of the 4 code starts with an F like a master code but belongs to the 4 code
+			name:        "multi-line code",
+			codes:       "Items\n4035459C 00040001\nFFFFFFFF 00000000\n",
+			wantLines:   map[string][]string{"Items": {"4035459C 00040001", "FFFFFFFF 00000000"}},
+			wantDropped: nil,
+		},
+		{
+			name:        "cheat with only master codes",
+			codes:       "Enable\nF0100008 001FFFFF\nMoney\n2035459C 0000270F\n",
+			wantLines:   map[string][]string{"Money": {"2035459C 0000270F"}},
+			wantDropped: []int{2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			format, cheats, dropped, err := convertCodes(strings.NewReader(test.codes), "auto", "Converted")
+			if err != nil {
+				t.Fatalf("convertCodes() error = %v", err)
+			}
+			if format.name != "raw" {
+				t.Errorf("format = %v, want raw", format.name)
+			}
+			lines := map[string][]string{}
+			for _, cheat := range cheats {
+				lines[cheat.name] = cheat.rawLines()
+			}
+			if len(lines) != len(test.wantLines) {
+				t.Errorf("cheats = %v, want %v", lines, test.wantLines)
+			}
+			for name, wantLines := range test.wantLines {
+				if !slices.Equal(lines[name], wantLines) {
+					t.Errorf("lines of %v = %v, want %v", name, lines[name], wantLines)
+				}
+			}
+			var droppedLines []int
+			for _, droppedLine := range dropped {
+				droppedLines = append(droppedLines, droppedLine.line.lineNumber)
+			}
+			if !slices.Equal(droppedLines, test.wantDropped) {
+				t.Errorf("dropped lines = %v, want %v", droppedLines, test.wantDropped)
+			}
+		})
+	}
+}
+
+func TestConvertCodesEncrypted(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		codes  string
+	}{
+		{name: "codebreaker", format: "cb", codes: "2035459C 0000270F\n"},
+		{name: "gameshark2", format: "ar2", codes: "2035459C 0000270F\n"},
+		{name: "found by the key code", format: "auto", codes: "BEEFC0DE 00000000\n2035459C 0000270F\n"},
+		{name: "armax", format: "auto", codes: "ABCD-EFGH-IJKLM\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, _, err := convertCodes(strings.NewReader(test.codes), test.format, "Converted")
+			if !errors.Is(err, ErrDecryptionUnsupported) {
+				t.Errorf("convertCodes() error = %v, want ErrDecryptionUnsupported", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func handleDumpHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling dump HTTP request")
+	// for dumps:
+	// - Woody-Address and Woody-Length give the range of memory to dump (the length can be as big as all of memory)
+	// - Woody-Format is one of binary (the default, sent as application/octet-stream), hex or base64
+	// - memory is read and sent a chunk at a time so that the whole range never has to be held in memory
+	// - the Woody-Total-Length header has the number of bytes that will be dumped so clients can show progress
+	// - since the response has already started by the time a chunk can fail, the outcome is sent in HTTP trailers:
+	//   - Woody-Result-Code is the PINE result code (zero when everything was dumped)
+	//   - Woody-Bytes-Dumped is the number of bytes that were dumped
+	//   - Woody-Failed-Address is the first address that couldn't be read (only when a read failed)
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	address, err := parseAddressParam(params, "dump")
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	lengthUInt64, err := parseIntParam(params, "woodylength", "length", 64, "dump")
+	if err == nil && lengthUInt64 == 0 {
+		err = errors.New("length for dump has to be at least 1 byte")
+	}
+	if err == nil {
+		err = checkMemoryRange(address, int(lengthUInt64))
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	length := int(lengthUInt64)
+	format := strings.ToLower(params["woodyformat"])
+	if format == "" {
+		format = "binary"
+	}
+	if format != "binary" && format != "hex" && format != "base64" {
+		errMessage := "unknown format \"" + format + "\" for dump. Supported values are binary, hex and base64"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+
+	// read the first chunk before sending the headers so that a missing emulator or a bad address can still be a
+	// normal error response
+	firstChunk, resultCode, failedAddress, err := readMemoryForDump(pc, address, min(memoryChunkSize, length))
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while reading memory for dump", err)
+		return
+	}
+	if resultCode != 0 && len(firstChunk) == 0 {
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), map[string]any{
+			"resultCode":    resultCode,
+			"failedAddress": failedAddress,
+		})
+		return
+	}
+
+	httpResponseWriter.Header().Set("Trailer", "Woody-Result-Code, Woody-Bytes-Dumped, Woody-Failed-Address")
+	httpResponseWriter.Header().Set("Woody-Total-Length", fmt.Sprint(length))
+	var writer io.Writer = httpResponseWriter
+	var closer io.Closer = nil
+	switch format {
+	case "binary":
+		httpResponseWriter.Header().Set("Content-Type", "application/octet-stream")
+		httpResponseWriter.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"dump-%08x-%x.bin\"", address, length))
+	case "hex":
+		httpResponseWriter.Header().Set("Content-Type", "text/plain")
+		writer = hex.NewEncoder(httpResponseWriter)
+	case "base64":
+		httpResponseWriter.Header().Set("Content-Type", "text/plain")
+		base64Writer := base64.NewEncoder(base64.StdEncoding, httpResponseWriter)
+		writer = base64Writer
+		closer = base64Writer
+	}
+	httpResponseWriter.WriteHeader(200)
+	flusher, _ := httpResponseWriter.(http.Flusher)
+
+	logger.Info("dumping memory", "address", address, "length", length, "format", format)
+	bytesDumped := 0
+	chunk := firstChunk
+	for {
+		_, err = writer.Write(chunk)
+		if err != nil {
+			// the client went away so there's no one to send the rest to
+			logger.Error("error while writing dump", "err", err)
+			return
+		}
+		bytesDumped += len(chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		logger.Debug("dump progress", "bytesDumped", bytesDumped, "length", length)
+		if resultCode != 0 || err != nil || bytesDumped >= length {
+			break
+		}
+		chunk, resultCode, failedAddress, err = readMemoryForDump(pc, address+uint32(bytesDumped), min(memoryChunkSize, length-bytesDumped))
+	}
+	if closer != nil {
+		closer.Close()
+	}
+
+	if err != nil {
+		// the status code has already been sent so treat it like the read failed
+		supervisor.ConnectionFailed(pc)
+		logger.Error("error while reading memory for dump", "err", err)
+		resultCode = 255
+		failedAddress = address + uint32(bytesDumped)
+	}
+	httpResponseWriter.Header().Set("Woody-Result-Code", fmt.Sprint(resultCode))
+	httpResponseWriter.Header().Set("Woody-Bytes-Dumped", fmt.Sprint(bytesDumped))
+	if resultCode != 0 {
+		logger.Info("dump stopped early", "bytesDumped", bytesDumped, "failedAddress", failedAddress, "resultCode", resultCode)
+		httpResponseWriter.Header().Set("Woody-Failed-Address", fmt.Sprintf("0x%X", failedAddress))
+	} else {
+		logger.Info("dump finished", "bytesDumped", bytesDumped)
+	}
+}
+
+// reads a chunk and, if it fails, narrows it down to the bytes that can be read before the first failing address
+// so a dump can get as close to the failure as possible
+func readMemoryForDump(pc *PineConnection, address uint32, length int) ([]byte, uint8, uint32, error) {
+	bytes, resultCode, err := readMemoryChunk(pc, address, length)
+	if err != nil || resultCode == 0 {
+		return bytes, resultCode, 0, err
+	}
+	if length == 1 {
+		return nil, resultCode, address, nil
+	}
+	firstHalfLength := length / 2
+	firstHalf, firstResultCode, failedAddress, err := readMemoryForDump(pc, address, firstHalfLength)
+	if err != nil || firstResultCode != 0 {
+		return firstHalf, firstResultCode, failedAddress, err
+	}
+	secondHalf, secondResultCode, failedAddress, err := readMemoryForDump(pc, address+uint32(firstHalfLength), length-firstHalfLength)
+	return append(firstHalf, secondHalf...), secondResultCode, failedAddress, err
+}
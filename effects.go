@@ -0,0 +1,555 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often the effects are checked for having ended
+const effectTickInterval = 50 * time.Millisecond
+
+// how long to wait before trying a revert again when the emulator couldn't be reached
+const effectRetryInterval = time.Second
+
+// how long to keep trying to revert before giving up on it
+const effectRevertTimeout = 5 * time.Minute
+
+// the most effects that can be running at once
+const maxEffects = 1024
+
+// timed effects write a value to an address and put back the original value when they end (e.g. 1 HP for 30
+// seconds). Effects on the same address stack: the most recently started effect's value is the one in memory, when it
+// ends the value goes back to the most recent effect that is still running, and the original value is put back once
+// every effect on the address has ended. Reverts that fail because the emulator went away are tried again until it
+// comes back (or a different game starts).
+type EffectManager struct {
+	lock sync.Mutex
+	// by target and address
+	stacks map[string]*effectStack
+	nextID int
+	// the loop that ends the effects only runs while there are effects or reverts left
+	running bool
+}
+
+// the effects on a single address along with the value from before the first one started
+type effectStack struct {
+	key string
+	// the target and slot of the emulator (e.g. "pcsx2:28011") so the reverts never go to a different emulator
+	target     string
+	address    uint32
+	memoryType MemoryType
+	// the game the effects were started in
+	game     gameInfo
+	original uint64
+	// the running effects in the order they were started (the last one is the value in memory)
+	effects []*effect
+	// set when the value that should be in memory couldn't be written yet
+	pendingValue *uint64
+	pendingSince time.Time
+	nextRetry    time.Time
+	lastErr      string
+}
+
+type effect struct {
+	id      string
+	stack   *effectStack
+	value   uint64
+	started time.Time
+	expires time.Time
+}
+
+var ErrEffectNotFound = errors.New("effect not found")
+var ErrTooManyEffects = errors.New("too many effects")
+var ErrTypeConflict = errors.New("type conflict")
+
+func NewEffectManager() *EffectManager {
+	return &EffectManager{stacks: make(map[string]*effectStack)}
+}
+
+func effectStackKey(target string, address uint32) string {
+	return target + "@" + strconv.FormatUint(uint64(address), 16)
+}
+
+// reads the original value (unless there are already effects on the address), writes the value and starts the timer
+// the result code is non-zero when the emulator failed the read or the write, in which case nothing is started
+func (manager *EffectManager) Start(pc *PineConnection, game gameInfo, address uint32, memoryType MemoryType, value uint64, duration time.Duration) (*effect, uint8, error) {
+	target := pc.name()
+	key := effectStackKey(target, address)
+	span := memorySpan{address: address, width: max(memoryType.width/8, 1)}
+	var newEffect *effect
+	var resultCode uint8
+	// the connection's lock keeps the reverts on the emulator from writing in between the read and the write, while
+	// the manager's lock is only held to look at and change the stacks so listing the effects never waits on the
+	// emulator
+	err := pc.WithLock(func(sender PineSender) error {
+		if supervisor.WritesPaused(pc) {
+			return ErrWritesPaused
+		}
+		stack, err := manager.stackForStart(target, key, game, address, memoryType)
+		if err != nil {
+			return err
+		}
+		if stack == nil {
+			var original uint64
+			original, resultCode, err = readMemoryValue(sender, span)
+			if err != nil || resultCode != 0 {
+				return err
+			}
+			stack = &effectStack{key: key, target: target, address: address, memoryType: memoryType, game: game, original: original}
+		}
+		resultCode, err = writeMemoryValue(sender, span, value)
+		if err != nil || resultCode != 0 {
+			return err
+		}
+		newEffect = manager.addEffect(stack, value, duration)
+		return nil
+	})
+	if err != nil || resultCode != 0 {
+		return nil, resultCode, err
+	}
+	return newEffect, 0, nil
+}
+
+// checks that another effect can be started on the address and returns the stack that's already there, if any
+func (manager *EffectManager) stackForStart(target string, key string, game gameInfo, address uint32, memoryType MemoryType) (*effectStack, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	count := 0
+	for _, stack := range manager.stacks {
+		count += len(stack.effects)
+	}
+	if count >= maxEffects {
+		return nil, fmt.Errorf("%w: there can't be more than %v effects", ErrTooManyEffects, maxEffects)
+	}
+
+	stack, found := manager.stacks[key]
+	if found && stack.game != game {
+		// the effects were for a game that isn't running anymore so there's nothing to put back
+		logger.Info("dropping effects since the game changed", "address", address)
+		delete(manager.stacks, key)
+		return nil, nil
+	}
+	if found && stack.memoryType.width != memoryType.width {
+		return nil, fmt.Errorf("%w: there are already %v effects on 0x%X", ErrTypeConflict, stack.memoryType.name, address)
+	}
+	return stack, nil
+}
+
+// records an effect whose value was just written and starts the loop that ends it
+func (manager *EffectManager) addEffect(stack *effectStack, value uint64, duration time.Duration) *effect {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	// the new value replaces whatever revert was still waiting to be written
+	stack.pendingValue = nil
+	stack.lastErr = ""
+	manager.stacks[stack.key] = stack
+
+	manager.nextID++
+	now := time.Now()
+	newEffect := &effect{id: strconv.Itoa(manager.nextID), stack: stack, value: value, started: now, expires: now.Add(duration)}
+	stack.effects = append(stack.effects, newEffect)
+	logger.Info("started effect", "id", newEffect.id, "address", stack.address, "duration", duration, "stacked", len(stack.effects))
+	if !manager.running {
+		manager.running = true
+		go manager.run()
+	}
+	return newEffect
+}
+
+// ends the effect before its time is up
+func (manager *EffectManager) End(id string) (*effect, error) {
+	manager.lock.Lock()
+	current := manager.find(id)
+	if current == nil {
+		manager.lock.Unlock()
+		return nil, fmt.Errorf("%w: no running effect with the id \"%v\"", ErrEffectNotFound, id)
+	}
+	needsRevert := manager.end(current)
+	manager.lock.Unlock()
+
+	if needsRevert {
+		manager.revert(current.stack)
+	}
+	return current, nil
+}
+
+// finds a running effect (the lock must be held)
+func (manager *EffectManager) find(id string) *effect {
+	for _, stack := range manager.stacks {
+		for _, current := range stack.effects {
+			if current.id == id {
+				return current
+			}
+		}
+	}
+	return nil
+}
+
+// takes the effect off its stack and, if its value was the one in memory, sets the value from under it as the one to
+// put back, returning whether there's a revert to write (the lock must be held)
+func (manager *EffectManager) end(ended *effect) bool {
+	stack := ended.stack
+	top := stack.effects[len(stack.effects)-1] == ended
+	for i, current := range stack.effects {
+		if current == ended {
+			stack.effects = append(stack.effects[:i], stack.effects[i+1:]...)
+			break
+		}
+	}
+	logger.Info("ended effect", "id", ended.id, "address", stack.address, "stacked", len(stack.effects))
+	if top {
+		value := stack.original
+		if len(stack.effects) > 0 {
+			value = stack.effects[len(stack.effects)-1].value
+		}
+		stack.pendingValue = &value
+		stack.pendingSince = time.Now()
+		stack.nextRetry = time.Time{}
+	}
+	if len(stack.effects) == 0 && stack.pendingValue == nil {
+		delete(manager.stacks, stack.key)
+	}
+	return top
+}
+
+// tries to write the value the stack should have. A failure is left pending so it's tried again later
+// (the lock mustn't be held since it's only taken to look at the stack before and after the write)
+func (manager *EffectManager) revert(stack *effectStack) {
+	manager.lock.Lock()
+	pending := stack.pendingValue
+	manager.lock.Unlock()
+	if pending == nil {
+		return
+	}
+
+	var resultCode uint8
+	written := false
+	paused := false
+	gameChanged := false
+	pc, err := supervisor.Connection(stack.target)
+	if err == nil {
+		gameChanged = supervisor.Game(pc) != stack.game
+	}
+	if err == nil && !gameChanged {
+		err = pc.WithLock(func(sender PineSender) error {
+			// an effect started since the value was taken has already written a newer one
+			if !manager.isPending(stack, pending) {
+				return nil
+			}
+			if supervisor.WritesPaused(pc) {
+				paused = true
+				return nil
+			}
+			var err error
+			resultCode, err = writeMemoryValue(sender, memorySpan{address: stack.address, width: max(stack.memoryType.width/8, 1)}, *pending)
+			written = err == nil
+			return err
+		})
+		if err != nil {
+			supervisor.ConnectionFailed(pc)
+		}
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	defer func() {
+		if len(stack.effects) == 0 && stack.pendingValue == nil && manager.stacks[stack.key] == stack {
+			delete(manager.stacks, stack.key)
+		}
+	}()
+	if stack.pendingValue != pending {
+		// a newer value was written or set to be put back in the meantime
+		return
+	}
+	if gameChanged {
+		logger.Info("dropping the revert since the game changed", "address", stack.address)
+		stack.pendingValue = nil
+		return
+	}
+	if paused {
+		// put back once the analysis is done, without counting towards giving up
+		stack.lastErr = ErrWritesPaused.Error()
+		stack.pendingSince = time.Now()
+		stack.nextRetry = time.Now().Add(effectRetryInterval)
+		return
+	}
+	if written && resultCode == 0 {
+		logger.Debug("reverted effect value", "address", stack.address, "value", *pending)
+		stack.pendingValue = nil
+		stack.lastErr = ""
+		return
+	}
+	if err != nil {
+		stack.lastErr = err.Error()
+	} else {
+		stack.lastErr = fmt.Sprintf("the emulator failed the write with result code %v", resultCode)
+	}
+	if time.Since(stack.pendingSince) > effectRevertTimeout {
+		logger.Error("giving up on reverting an effect", "address", stack.address, "value", *pending, "err", stack.lastErr)
+		stack.pendingValue = nil
+		return
+	}
+	logger.Info("could not revert an effect, will try again", "address", stack.address, "err", stack.lastErr)
+	stack.nextRetry = time.Now().Add(effectRetryInterval)
+}
+
+// whether the value is still the one waiting to be put back
+func (manager *EffectManager) isPending(stack *effectStack, pending *uint64) bool {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	return stack.pendingValue == pending
+}
+
+func (manager *EffectManager) run() {
+	logger.Info("starting the effect loop")
+	ticker := time.NewTicker(effectTickInterval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		if !manager.tick() {
+			logger.Info("stopping the effect loop since there aren't any effects left")
+			return
+		}
+	}
+}
+
+// ends the effects whose time is up and retries the reverts that are due, returning false when there's nothing left
+func (manager *EffectManager) tick() bool {
+	manager.lock.Lock()
+	now := time.Now()
+	var reverts []*effectStack
+	for _, stack := range manager.stacks {
+		// ended in the order they started so the stack unwinds the same way however late the tick is
+		for _, current := range append([]*effect(nil), stack.effects...) {
+			if !now.Before(current.expires) {
+				manager.end(current)
+			}
+		}
+		if stack.pendingValue != nil && !now.Before(stack.nextRetry) {
+			reverts = append(reverts, stack)
+		}
+	}
+	if len(manager.stacks) == 0 {
+		manager.running = false
+		manager.lock.Unlock()
+		return false
+	}
+	manager.lock.Unlock()
+
+	// written without the lock so the effects can be listed, started and ended while the emulator is slow
+	for _, stack := range reverts {
+		manager.revert(stack)
+	}
+	return true
+}
+
+func (manager *EffectManager) List() ([]map[string]any, []map[string]any) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	effects := []map[string]any{}
+	reverts := []map[string]any{}
+	for _, stack := range manager.stacks {
+		for _, current := range stack.effects {
+			effects = append(effects, current.toResult())
+		}
+		if stack.pendingValue != nil {
+			reverts = append(reverts, map[string]any{
+				"target":  stack.target,
+				"address": fmt.Sprintf("0x%X", stack.address),
+				"type":    stack.memoryType.name,
+				"value":   stack.memoryType.decode(*stack.pendingValue),
+				"since":   stack.pendingSince.Format(time.RFC3339Nano),
+				"lastErr": stack.lastErr,
+			})
+		}
+	}
+	return effects, reverts
+}
+
+func (manager *EffectManager) result(id string) (map[string]any, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	current := manager.find(id)
+	if current == nil {
+		return nil, fmt.Errorf("%w: no running effect with the id \"%v\"", ErrEffectNotFound, id)
+	}
+	return current.toResult(), nil
+}
+
+// the effect as JSON (the lock must be held)
+func (current *effect) toResult() map[string]any {
+	stack := current.stack
+	position := 0
+	for i, stacked := range stack.effects {
+		if stacked == current {
+			position = i
+		}
+	}
+	result := map[string]any{
+		"id":       current.id,
+		"target":   stack.target,
+		"address":  fmt.Sprintf("0x%X", stack.address),
+		"type":     stack.memoryType.name,
+		"value":    stack.memoryType.decode(current.value),
+		"original": stack.memoryType.decode(stack.original),
+		"started":  current.started.Format(time.RFC3339Nano),
+		"expires":  current.expires.Format(time.RFC3339Nano),
+		// in milliseconds so overlays don't have to parse a duration
+		"remainingMs": max(time.Until(current.expires).Milliseconds(), 0),
+		// whether the effect's value is the one in memory (only the most recent effect on an address is)
+		"applied": position == len(stack.effects)-1,
+		"stacked": len(stack.effects),
+	}
+	return result
+}
+
+func handleEffectHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling effect HTTP request")
+	// for starting a timed effect:
+	// - Woody-Address is set to Woody-Data (with the Woody-Type, u32 by default) for Woody-Duration (e.g. 30s)
+	// - the value from before is read first and put back when the effect ends
+	// - effects on the same address stack, with the most recently started one in memory. When it ends, the value goes
+	//   back to the most recent effect that is still running, or the original value once none are
+	// - effects on the same address have to use the same width of type, otherwise a 409 (Conflict) is sent
+	// - effects can't be started while a savestate analysis runs on the emulator (409), and the reverts wait for it
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	address, memoryType, value, duration, err := parseEffectParams(params)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	newEffect, resultCode, err := effects.Start(pc, game, address, memoryType, value, duration)
+	if errors.Is(err, ErrTypeConflict) || errors.Is(err, ErrTooManyEffects) || errors.Is(err, ErrWritesPaused) {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		statusCode := 400
+		if errors.Is(err, ErrTypeConflict) || errors.Is(err, ErrWritesPaused) {
+			statusCode = 409
+		}
+		sendHTTPError(httpResponseWriter, statusCode, errMessage)
+		return
+	}
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while starting the effect", err)
+		return
+	}
+	if resultCode != 0 {
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), map[string]any{"resultCode": resultCode})
+		return
+	}
+	result, _ := effects.result(newEffect.id)
+	if result == nil {
+		// a really short effect can end before we get here
+		result = map[string]any{"id": newEffect.id}
+	}
+	result["resultCode"] = 0
+	sendHTTPJSON(httpResponseWriter, 200, result)
+}
+
+func parseEffectParams(params map[string]string) (uint32, MemoryType, uint64, time.Duration, error) {
+	addressString, found := params["woodyaddress"]
+	if !found {
+		return 0, MemoryType{}, 0, 0, errors.New("no address found in the parameters")
+	}
+	address, err := parsePlainAddress(addressString)
+	if err != nil {
+		return 0, MemoryType{}, 0, 0, err
+	}
+	typeName := params["woodytype"]
+	if typeName == "" {
+		typeName = "u32"
+	}
+	memoryType, err := parseMemoryType(typeName)
+	if err != nil {
+		return 0, MemoryType{}, 0, 0, err
+	}
+	dataString, found := params["woodydata"]
+	if !found {
+		return 0, MemoryType{}, 0, 0, errors.New("no data found in the parameters")
+	}
+	value, err := memoryType.encode(dataString)
+	if err != nil {
+		return 0, MemoryType{}, 0, 0, fmt.Errorf("unable to parse data %v as %v", dataString, memoryType.name)
+	}
+	durationString, found := params["woodyduration"]
+	if !found {
+		return 0, MemoryType{}, 0, 0, errors.New("no duration found in the parameters")
+	}
+	duration, err := time.ParseDuration(durationString)
+	if err != nil || duration <= 0 {
+		return 0, MemoryType{}, 0, 0, errors.New("unable to parse duration " + durationString + " (e.g. 30s or 1m30s)")
+	}
+	return address, memoryType, value, duration, nil
+}
+
+func handleEffectsHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling effects HTTP request")
+	// for effects:
+	// - /effects lists the running effects (with the remainingMs for overlays) and the reverts still being retried
+	// - /effects/<id> is a single effect
+	// - /effects/<id>/end ends the effect early, putting back the value from under it
+	path := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/effects"), "/")
+	id, action, _ := strings.Cut(path, "/")
+	if id == "" {
+		effectResults, revertResults := effects.List()
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"effects": effectResults, "reverts": revertResults})
+		return
+	}
+
+	var result map[string]any
+	var err error
+	switch action {
+	case "":
+		result, err = effects.result(id)
+	case "end":
+		var ended *effect
+		ended, err = effects.End(id)
+		if err == nil {
+			result = map[string]any{"id": ended.id, "ended": true}
+		}
+	default:
+		errMessage := "unknown effect action \"" + action + "\". The only supported value is end"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	sendHTTPJSON(httpResponseWriter, 200, result)
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the most freezes written with a single batch message. Each batch holds the connection's networkLock while it's
+// sent, so keeping them small lets API requests get a turn between batches
+const freezeBatchSize = 256
+
+// the most freezes that can be active at once
+const maxFreezes = 1024
+
+// holds addresses at a value, or inside a range, by writing them again every interval. Freezes are for a target
+// rather than a connection so they carry on when the emulator comes back, but they're dropped when a different game
+// starts since the address would mean something else.
+type FreezeManager struct {
+	lock    sync.Mutex
+	freezes []*freeze
+	nextID  int
+	// how often the values are written again
+	interval time.Duration
+	// the loop that writes the values only runs while something is frozen
+	running bool
+}
+
+type freeze struct {
+	id string
+	// the target and slot of the emulator the freeze was made for (e.g. "pcsx2:28011") so it never moves to a different
+	// emulator when the default target isn't connected
+	target     string
+	address    uint32
+	memoryType MemoryType
+	// the raw value to hold the address at, or nil when the value is clamped to min and max instead
+	value *uint64
+	// the raw bounds for clamping (either can be nil)
+	min *uint64
+	max *uint64
+	// the zero time means the freeze never expires
+	expires time.Time
+	// the game the freeze was made for
+	game gameInfo
+	// how the last write went (only changed with the manager lock held)
+	lastResultCode uint8
+	lastErrMessage string
+	lastApplied    time.Time
+}
+
+func NewFreezeManager(interval time.Duration) *FreezeManager {
+	return &FreezeManager{interval: interval}
+}
+
+// adds the freeze, replacing any freeze for the same address and target
+func (manager *FreezeManager) Add(newFreeze *freeze) (*freeze, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	var kept []*freeze
+	for _, existing := range manager.freezes {
+		if existing.address == newFreeze.address && existing.target == newFreeze.target {
+			logger.Info("replacing freeze", "id", existing.id, "address", existing.address)
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	if len(kept) >= maxFreezes {
+		return nil, fmt.Errorf("there can't be more than %v freezes", maxFreezes)
+	}
+	manager.nextID++
+	newFreeze.id = strconv.Itoa(manager.nextID)
+	manager.freezes = append(kept, newFreeze)
+	logger.Info("added freeze", "id", newFreeze.id, "target", newFreeze.target, "address", newFreeze.address, "freezes", len(manager.freezes))
+	if !manager.running {
+		manager.running = true
+		go manager.run()
+	}
+	return newFreeze, nil
+}
+
+// removes the freezes with the id or, when the id is empty, the freezes for the address and target (an empty target
+// matches every emulator)
+func (manager *FreezeManager) Remove(id string, address *uint32, target string) []*freeze {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	var kept []*freeze
+	var removed []*freeze
+	for _, existing := range manager.freezes {
+		matches := existing.id == id
+		if id == "" && address != nil {
+			matches = existing.address == *address && matchesTarget(existing.target, target)
+		}
+		if matches {
+			removed = append(removed, existing)
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	manager.freezes = kept
+	for _, removedFreeze := range removed {
+		logger.Info("removed freeze", "id", removedFreeze.id, "address", removedFreeze.address, "freezes", len(manager.freezes))
+	}
+	return removed
+}
+
+func (manager *FreezeManager) run() {
+	logger.Info("starting the freeze loop", "interval", manager.interval)
+	ticker := time.NewTicker(manager.interval)
+	defer ticker.Stop()
+	for {
+		freezes := manager.currentFreezes()
+		if len(freezes) == 0 {
+			logger.Info("stopping the freeze loop since nothing is frozen")
+			return
+		}
+		manager.apply(freezes)
+		<-ticker.C
+	}
+}
+
+// drops the freezes that have expired and marks the loop as stopped when there aren't any left
+func (manager *FreezeManager) currentFreezes() []*freeze {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	now := time.Now()
+	var kept []*freeze
+	for _, existing := range manager.freezes {
+		if !existing.expires.IsZero() && now.After(existing.expires) {
+			logger.Info("freeze expired", "id", existing.id, "address", existing.address)
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	manager.freezes = kept
+	if len(kept) == 0 {
+		manager.running = false
+	}
+	return append([]*freeze(nil), kept...)
+}
+
+func (manager *FreezeManager) apply(freezes []*freeze) {
+	freezesByConnection := make(map[*PineConnection][]*freeze)
+	for _, current := range freezes {
+		pc, err := supervisor.Connection(current.target)
+		if err != nil {
+			// the freeze carries on once the emulator is back
+			manager.setStatus([]*freeze{current}, 0, err.Error())
+			continue
+		}
+		if supervisor.Game(pc) != current.game {
+			logger.Info("dropping freeze since the game changed", "id", current.id, "address", current.address)
+			manager.Remove(current.id, nil, "")
+			continue
+		}
+		freezesByConnection[pc] = append(freezesByConnection[pc], current)
+	}
+
+	for pc, connectionFreezes := range freezesByConnection {
+		for start := 0; start < len(connectionFreezes); start += freezeBatchSize {
+			batch := connectionFreezes[start:min(start+freezeBatchSize, len(connectionFreezes))]
+			err := manager.applyBatch(pc, batch)
+			if errors.Is(err, ErrWritesPaused) {
+				// they carry on once the analysis is done
+				manager.setStatus(connectionFreezes, 0, err.Error())
+				break
+			}
+			if err != nil {
+				supervisor.ConnectionFailed(pc)
+				manager.setStatus(connectionFreezes[start:], 0, "error while writing frozen values: "+err.Error())
+				break
+			}
+			// the lock is free between batches, so let anything that's waiting on it have a turn
+			runtime.Gosched()
+		}
+	}
+}
+
+// writes the values for the freezes as a single turn on the connection, unless the freezes are paused
+func (manager *FreezeManager) applyBatch(pc *PineConnection, freezes []*freeze) error {
+	return pc.WithLock(func(sender PineSender) error {
+		if supervisor.WritesPaused(pc) {
+			return ErrWritesPaused
+		}
+		return manager.writeValues(sender, freezes)
+	})
+}
+
+// writes the values for the freezes, reading the clamped ones first to see if they're out of range
+func (manager *FreezeManager) writeValues(pc PineSender, freezes []*freeze) error {
+	var clamped []*freeze
+	var clampedSpans []memorySpan
+	for _, current := range freezes {
+		if current.value == nil {
+			clamped = append(clamped, current)
+			clampedSpans = append(clampedSpans, current.span())
+		}
+	}
+	currentValues := make(map[*freeze]uint64)
+	if len(clamped) > 0 {
+		values, resultCode, err := readMemoryValues(pc, clampedSpans)
+		if err != nil {
+			return err
+		}
+		if resultCode != 0 {
+			// the emulator only gives a single result code for the whole batch, so each one is read on its own to find
+			// the ones that can't be read
+			for _, current := range clamped {
+				value, resultCode, err := readMemoryValue(pc, current.span())
+				if err != nil {
+					return err
+				}
+				if resultCode != 0 {
+					manager.setStatus([]*freeze{current}, resultCode, "the emulator failed to read the frozen value")
+					continue
+				}
+				currentValues[current] = value
+			}
+		} else {
+			for i, current := range clamped {
+				currentValues[current] = values[i]
+			}
+		}
+	}
+
+	var writing []*freeze
+	var spans []memorySpan
+	var values []uint64
+	for _, current := range freezes {
+		value, write := current.valueToWrite(currentValues)
+		if !write {
+			if _, read := currentValues[current]; read {
+				manager.setStatus([]*freeze{current}, 0, "")
+			}
+			continue
+		}
+		writing = append(writing, current)
+		spans = append(spans, current.span())
+		values = append(values, value)
+	}
+	if len(writing) == 0 {
+		return nil
+	}
+	resultCode, err := writeMemoryValues(pc, spans, values)
+	if err != nil {
+		return err
+	}
+	if resultCode == 0 {
+		manager.setStatus(writing, 0, "")
+		return nil
+	}
+	for i, current := range writing {
+		resultCode, err := writeMemoryValue(pc, spans[i], values[i])
+		if err != nil {
+			return err
+		}
+		errMessage := ""
+		if resultCode != 0 {
+			errMessage = "the emulator failed to write the frozen value"
+		}
+		manager.setStatus([]*freeze{current}, resultCode, errMessage)
+	}
+	return nil
+}
+
+func (manager *FreezeManager) setStatus(freezes []*freeze, resultCode uint8, errMessage string) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	now := time.Now()
+	for _, current := range freezes {
+		if errMessage != "" && errMessage != current.lastErrMessage {
+			logger.Debug("freeze failed", "id", current.id, "resultCode", resultCode, "errMessage", errMessage)
+		}
+		current.lastResultCode = resultCode
+		current.lastErrMessage = errMessage
+		if errMessage == "" {
+			current.lastApplied = now
+		}
+	}
+}
+
+func (manager *FreezeManager) List() []map[string]any {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	results := []map[string]any{}
+	for _, current := range manager.freezes {
+		results = append(results, current.toResult())
+	}
+	return results
+}
+
+func (current *freeze) span() memorySpan {
+	return memorySpan{address: current.address, width: max(current.memoryType.width/8, 1)}
+}
+
+// the value to write and whether it needs writing. Clamped values are only written when they're out of range
+func (current *freeze) valueToWrite(currentValues map[*freeze]uint64) (uint64, bool) {
+	if current.value != nil {
+		return *current.value, true
+	}
+	raw, read := currentValues[current]
+	if !read {
+		return 0, false
+	}
+	value, isNumber := current.memoryType.number(raw)
+	if !isNumber {
+		// NaN is out of every range so it's put back to the min (or the max when there isn't a min)
+		if current.min != nil {
+			return *current.min, true
+		}
+		return *current.max, true
+	}
+	if current.min != nil {
+		minValue, _ := current.memoryType.number(*current.min)
+		if value < minValue {
+			return *current.min, true
+		}
+	}
+	if current.max != nil {
+		maxValue, _ := current.memoryType.number(*current.max)
+		if value > maxValue {
+			return *current.max, true
+		}
+	}
+	return 0, false
+}
+
+func (current *freeze) toResult() map[string]any {
+	result := map[string]any{
+		"id":      current.id,
+		"target":  current.target,
+		"address": fmt.Sprintf("0x%X", current.address),
+		"type":    current.memoryType.name,
+	}
+	if current.value != nil {
+		result["value"] = current.memoryType.decode(*current.value)
+	}
+	if current.min != nil {
+		result["min"] = current.memoryType.decode(*current.min)
+	}
+	if current.max != nil {
+		result["max"] = current.memoryType.decode(*current.max)
+	}
+	if !current.expires.IsZero() {
+		result["expires"] = current.expires.Format(time.RFC3339Nano)
+		result["remaining"] = max(time.Until(current.expires), 0).Round(time.Millisecond).String()
+	}
+	if !current.lastApplied.IsZero() {
+		result["lastApplied"] = current.lastApplied.Format(time.RFC3339Nano)
+	}
+	if current.lastErrMessage != "" {
+		result["lastErrMessage"] = current.lastErrMessage
+		if current.lastResultCode != 0 {
+			result["lastResultCode"] = current.lastResultCode
+		}
+	}
+	return result
+}
+
+// builds a freeze from the API parameters
+func parseFreeze(params map[string]string) (*freeze, error) {
+	addressString, found := params["woodyaddress"]
+	if !found {
+		return nil, errors.New("no address found in the parameters")
+	}
+	address, err := parsePlainAddress(addressString)
+	if err != nil {
+		return nil, err
+	}
+	typeName := params["woodytype"]
+	if typeName == "" {
+		typeName = "u32"
+	}
+	memoryType, err := parseMemoryType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	newFreeze := &freeze{address: address, memoryType: memoryType}
+
+	encode := func(key string, name string) (*uint64, error) {
+		literal, found := params[key]
+		if !found {
+			return nil, nil
+		}
+		raw, err := memoryType.encode(literal)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %v %v as %v", name, literal, memoryType.name)
+		}
+		return &raw, nil
+	}
+	newFreeze.value, err = encode("woodydata", "data")
+	if err != nil {
+		return nil, err
+	}
+	newFreeze.min, err = encode("woodymin", "min")
+	if err != nil {
+		return nil, err
+	}
+	newFreeze.max, err = encode("woodymax", "max")
+	if err != nil {
+		return nil, err
+	}
+	if newFreeze.value == nil && newFreeze.min == nil && newFreeze.max == nil {
+		return nil, errors.New("a freeze needs either data or a min and/or max")
+	}
+	if newFreeze.value != nil && (newFreeze.min != nil || newFreeze.max != nil) {
+		return nil, errors.New("a freeze can have data or a min and/or max but not both")
+	}
+	for _, bound := range []*uint64{newFreeze.min, newFreeze.max} {
+		if _, isNumber := memoryType.number(valueOrZero(bound)); !isNumber {
+			return nil, errors.New("the min and max have to be numbers")
+		}
+	}
+	if newFreeze.min != nil && newFreeze.max != nil {
+		minValue, _ := memoryType.number(*newFreeze.min)
+		maxValue, _ := memoryType.number(*newFreeze.max)
+		if minValue > maxValue {
+			return nil, errors.New("min is bigger than max")
+		}
+	}
+
+	if durationString, found := params["woodyduration"]; found {
+		duration, err := time.ParseDuration(durationString)
+		if err != nil || duration <= 0 {
+			return nil, errors.New("unable to parse duration " + durationString + " (e.g. 60s or 1m30s)")
+		}
+		newFreeze.expires = time.Now().Add(duration)
+	}
+	return newFreeze, nil
+}
+
+// whether the target and slot (e.g. "pcsx2:28011") is the requested target, which can leave out the slot or be empty
+// to match everything
+func matchesTarget(name string, requested string) bool {
+	requested = strings.ToLower(requested)
+	return requested == "" || name == requested || strings.HasPrefix(name, requested+":")
+}
+
+func valueOrZero(value *uint64) uint64 {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+func handleFreezeHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling freeze HTTP request")
+	// for freezing:
+	// - /freeze holds Woody-Address at Woody-Data (or inside Woody-Min and Woody-Max) by writing it again every
+	//   freezeInterval. Woody-Type is the type of the value (u32 by default)
+	// - Woody-Duration (e.g. 60s) makes the freeze expire on its own
+	// - a freeze for an address replaces any earlier freeze for the same address and target
+	// - the value is written once before responding so a bad address is reported right away
+	// - while a savestate analysis runs on the emulator, the freezes wait for it to finish and new ones get a 409
+	//   (Conflict)
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	newFreeze, err := parseFreeze(params)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	newFreeze.target = pc.name()
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+	newFreeze.game = game
+
+	err = freezes.applyBatch(pc, []*freeze{newFreeze})
+	if errors.Is(err, ErrWritesPaused) {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while writing the frozen value", err)
+		return
+	}
+	if newFreeze.lastErrMessage != "" {
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(newFreeze.lastResultCode), map[string]any{
+			"resultCode": newFreeze.lastResultCode,
+			"errMessage": newFreeze.lastErrMessage,
+		})
+		return
+	}
+
+	newFreeze, err = freezes.Add(newFreeze)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	result := freezes.result(newFreeze)
+	result["resultCode"] = 0
+	sendHTTPJSON(httpResponseWriter, 200, result)
+}
+
+func handleUnfreezeHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling unfreeze HTTP request")
+	// for unfreezing:
+	// - Woody-Freeze is the id of the freeze to remove, or Woody-Address (and Woody-Target) removes the freeze for
+	//   that address
+	// - the value is left as it is, nothing is put back
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	id := params["woodyfreeze"]
+	var address *uint32
+	if addressString, found := params["woodyaddress"]; found && id == "" {
+		parsed, err := parsePlainAddress(addressString)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		address = &parsed
+	}
+	if id == "" && address == nil {
+		errMessage := "no freeze id or address found in the parameters"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	removed := freezes.Remove(id, address, params["woodytarget"])
+	if len(removed) == 0 {
+		errMessage := "no matching freeze"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	var removedResults []map[string]any
+	for _, removedFreeze := range removed {
+		removedResults = append(removedResults, freezes.result(removedFreeze))
+	}
+	sendHTTPJSON(httpResponseWriter, 200, map[string]any{"removed": removedResults})
+}
+
+func handleFreezesHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling freezes HTTP request")
+	// lists every active freeze along with how long it has left and how the last write went
+	sendHTTPJSON(httpResponseWriter, 200, map[string]any{"freezes": freezes.List()})
+}
+
+// the freeze as JSON (the status is changed by the loop so the lock is needed)
+func (manager *FreezeManager) result(current *freeze) map[string]any {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	return current.toResult()
+}
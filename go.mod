@@ -2,4 +2,8 @@ module woody
 
 go 1.23.4
 
-require github.com/golang-cz/devslog v0.0.11
+require (
+	github.com/golang-cz/devslog v0.0.11
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/text v0.21.0
+)
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"io"
 	"log/slog"
 	"os"
 	"runtime/debug"
@@ -10,72 +13,107 @@ import (
 )
 
 var logger *slog.Logger = nil
-var pc *PineConnection = nil
+var logLevel = new(slog.LevelVar)
+var config *Config = nil
+var supervisor *PineSupervisor = nil
+var watcher *MemoryWatcher = nil
+var profiles []*Profile = nil
+var cheats *CheatLibrary = nil
+var freezes *FreezeManager = nil
+var effects *EffectManager = nil
+var scans *ScanManager = nil
+var signatures *SignatureCache = nil
+var pointerScans *PointerScanManager = nil
+var snapshots *SnapshotManager = nil
+var analyses *AnalysisManager = nil
 
 func main() {
-	logger = configureLogger()
+	// subcommands for one-off jobs that don't need an emulator
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		logLevel.Set(slog.LevelWarn)
+		logger = configureLogger(os.Stderr)
+		os.Exit(runConvertCommand(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	logger = configureLogger(os.Stdout)
 	logger.Info("begin")
 
+	var err error
+	config, err = loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		logger.Error("error while loading the config", "err", err)
+		os.Exit(1)
+	}
+	level, _ := parseLogLevel(config.LogLevel)
+	logLevel.Set(level)
+
 	// we have to do this super early in case GOMEMLIMIT is set really low
-	goMemLimitEnvVar := os.Getenv("GOMEMLIMIT")
-	goGCEnvVar := os.Getenv("GOGC")
-	if goMemLimitEnvVar == "" {
-		debug.SetMemoryLimit(1024 * 1024 * 64) // 64 megs
-	}
-	if goGCEnvVar == "" {
-		debug.SetGCPercent(10)
-	}
-
-	// testPineRequestsAndAnswers()
-
-	// try connecting to every supported emulator on their default slot/port until we get a connection
-	for {
-		var err error
-		logger.Info("trying to connect to known emulators on default slots/ports")
-		for target, defaultSlot := range defaultSlotForTargetMap {
-			logger.Info("trying connecting to " + target)
-			pc, err = NewPineConnection(target, defaultSlot)
-			if err != nil {
-				logger.Info("failed to connect to " + target + ". Continuing to next emulator target.")
-				continue
-			}
-			err = pc.TestConnection()
-			if err != nil {
-				logger.Info("test connection for target " + target + " failed. Continuing to next emulator target.")
-				pc = nil
-				continue
-			}
-			// looks like we have a working connection
-			logger.Info("test connection for target " + target + " succeeded.")
-			break
-		}
-		if pc == nil {
-			logger.Info("could not connect to any targets. Sleeping for 5 seconds before reattempting connection")
-			time.Sleep(5 * time.Second)
-			continue
-		}
-
-		serviceAPIRequests()
+	memoryLimit, _ := parseMemoryLimit(config.GoMemLimit)
+	debug.SetMemoryLimit(memoryLimit)
+	debug.SetGCPercent(config.GoGC)
+	logger.Debug("loaded config", "config", config)
+
+	// the profiles directory only has to exist when it was asked for
+	profilesDirectory := config.ProfilesDirectory
+	if profilesDirectory == "" {
+		profilesDirectory = "profiles"
+	}
+	profiles, err = loadProfiles(profilesDirectory, config.ProfilesDirectory != "")
+	if err != nil {
+		logger.Error("error while loading the profiles", "err", err)
+		os.Exit(1)
 	}
-}
 
-func configureLogger() *slog.Logger {
-	var logLevel = new(slog.LevelVar)
-	logLevelEnvVar := os.Getenv("WOODY_LOG_LEVEL")
-	if logLevelEnvVar != "" {
-		switch logLevelEnvVar {
-		case "INFO", "info":
-			logLevel.Set(slog.LevelInfo)
-		case "DEBUG", "debug":
-			logLevel.Set(slog.LevelDebug)
-		case "WARN", "warn":
-			logLevel.Set(slog.LevelWarn)
-		case "ERROR", "error":
-			logLevel.Set(slog.LevelError)
-		default:
-			logLevel.Set(slog.LevelInfo)
-		}
+	cheatsDirectory := config.CheatsDirectory
+	if cheatsDirectory == "" {
+		cheatsDirectory = "cheats"
 	}
+	cheats, err = loadCheatLibrary(cheatsDirectory, config.CheatsDirectory != "", time.Duration(config.CheatInterval))
+	if err != nil {
+		logger.Error("error while loading the cheats", "err", err)
+		os.Exit(1)
+	}
+
+	scansDirectory := config.ScansDirectory
+	if scansDirectory == "" {
+		scansDirectory = "scans"
+	}
+	scans, err = NewScanManager(scansDirectory)
+	if err != nil {
+		logger.Error("error while loading the scans", "err", err)
+		os.Exit(1)
+	}
+
+	snapshotsDirectory := config.SnapshotsDirectory
+	if snapshotsDirectory == "" {
+		snapshotsDirectory = "snapshots"
+	}
+	snapshots, err = NewSnapshotManager(snapshotsDirectory)
+	if err != nil {
+		logger.Error("error while loading the snapshots", "err", err)
+		os.Exit(1)
+	}
+
+	supervisor, err = NewPineSupervisor(config)
+	if err != nil {
+		logger.Error("error while creating the connection supervisor", "err", err)
+		os.Exit(1)
+	}
+	go supervisor.Run()
+	watcher = NewMemoryWatcher(time.Duration(config.WatchInterval))
+	freezes = NewFreezeManager(time.Duration(config.FreezeInterval))
+	effects = NewEffectManager()
+	signatures = NewSignatureCache()
+	pointerScans = NewPointerScanManager()
+	analyses = NewAnalysisManager()
+
+	serviceAPIRequests()
+}
+
+func configureLogger(writer io.Writer) *slog.Logger {
 	var handlerOptions = slog.HandlerOptions{
 		AddSource: true,
 		Level:     logLevel,
@@ -85,12 +123,27 @@ func configureLogger() *slog.Logger {
 		MaxSlicePrintSize: 100,
 		SortKeys:          true,
 	}
-	var logger *slog.Logger = slog.New(devslog.NewHandler(os.Stdout, devSlogOpts))
+	var logger *slog.Logger = slog.New(devslog.NewHandler(writer, devSlogOpts))
 	slog.SetDefault(logger)
 
 	return logger
 }
 
+func parseLogLevel(logLevelString string) (slog.Level, error) {
+	switch logLevelString {
+	case "INFO", "info":
+		return slog.LevelInfo, nil
+	case "DEBUG", "debug":
+		return slog.LevelDebug, nil
+	case "WARN", "warn":
+		return slog.LevelWarn, nil
+	case "ERROR", "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, errors.New("unknown log level \"" + logLevelString + "\". Supported values are debug, info, warn and error")
+	}
+}
+
 // some code I keep around for testing with PCSX2
 func testPineRequestsAndAnswers() {
 	logger.Info("creating PineConnection")
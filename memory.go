@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// helpers for reading and writing ranges of memory that are bigger than a single PINE Request
+
+// the most bytes read or written with a single batch message (this keeps both the Request and the Answer well under
+// the maximum sizes that PCSX2 allows)
+const memoryChunkSize = 32 * 1024
+
+type memorySpan struct {
+	address uint32
+	// in bytes (1, 2, 4 or 8)
+	width int
+}
+
+// splits a range of memory into the widest aligned reads/writes possible (up to maxWidth bytes)
+// e.g. 0x1003 to 0x1010 is a 1 byte span at 0x1003, a 4 byte span at 0x1004 and an 8 byte span at 0x1008
+func memorySpans(address uint32, length int, maxWidth int) []memorySpan {
+	var spans []memorySpan
+	end := uint64(address) + uint64(length)
+	for current := uint64(address); current < end; {
+		width := maxWidth
+		for width > 1 && (current%uint64(width) != 0 || current+uint64(width) > end) {
+			width /= 2
+		}
+		spans = append(spans, memorySpan{address: uint32(current), width: width})
+		current += uint64(width)
+	}
+	return spans
+}
+
+func checkMemoryRange(address uint32, length int) error {
+	if length < 0 {
+		return errors.New("length can't be negative")
+	}
+	if uint64(address)+uint64(length) > 1<<32 {
+		return fmt.Errorf("0x%X bytes starting at 0x%X goes past the end of the address space", length, address)
+	}
+	return nil
+}
+
+// PCSX2 gives memory values in little endian (like the PS2) and RPCS3 gives them after converting them from the big
+// endian memory of the PS3, so we need to know which it is to get back to the bytes that are actually in memory
+func memoryByteOrder(pc PineSender) binary.ByteOrder {
+	var connection *PineConnection
+	switch sender := pc.(type) {
+	case *PineConnection:
+		connection = sender
+	case lockedPineConnection:
+		connection = sender.connection
+	}
+	if connection != nil && connection.target == "rpcs3" {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func readRequestForSpan(span memorySpan) (PineRequest, PineAnswer) {
+	switch span.width {
+	case 8:
+		return PineRead64Request{address: span.address}, &PineRead64Answer{}
+	case 4:
+		return PineRead32Request{address: span.address}, &PineRead32Answer{}
+	case 2:
+		return PineRead16Request{address: span.address}, &PineRead16Answer{}
+	default:
+		return PineRead8Request{address: span.address}, &PineRead8Answer{}
+	}
+}
+
+func writeRequestForSpan(span memorySpan, bytes []byte, byteOrder binary.ByteOrder) (PineRequest, PineAnswer) {
+	switch span.width {
+	case 8:
+		return writeValueRequestForSpan(span, byteOrder.Uint64(bytes))
+	case 4:
+		return writeValueRequestForSpan(span, uint64(byteOrder.Uint32(bytes)))
+	case 2:
+		return writeValueRequestForSpan(span, uint64(byteOrder.Uint16(bytes)))
+	default:
+		return writeValueRequestForSpan(span, uint64(bytes[0]))
+	}
+}
+
+// for writing a value (rather than bytes) so the emulator takes care of the byte order
+func writeValueRequestForSpan(span memorySpan, value uint64) (PineRequest, PineAnswer) {
+	switch span.width {
+	case 8:
+		return PineWrite64Request{address: span.address, data: value}, &PineWrite64Answer{}
+	case 4:
+		return PineWrite32Request{address: span.address, data: uint32(value)}, &PineWrite32Answer{}
+	case 2:
+		return PineWrite16Request{address: span.address, data: uint16(value)}, &PineWrite16Answer{}
+	default:
+		return PineWrite8Request{address: span.address, data: uint8(value)}, &PineWrite8Answer{}
+	}
+}
+
+// the raw memory value for read Answers
+func pineAnswerMemoryValue(answer PineAnswer) (uint64, bool) {
+	switch answer := answer.(type) {
+	case *PineRead8Answer:
+		return uint64(answer.memoryValue), true
+	case *PineRead16Answer:
+		return uint64(answer.memoryValue), true
+	case *PineRead32Answer:
+		return uint64(answer.memoryValue), true
+	case *PineRead64Answer:
+		return answer.memoryValue, true
+	default:
+		return 0, false
+	}
+}
+
+// reads a single value (for when the value matters rather than the bytes)
+func readMemoryValue(pc PineSender, span memorySpan) (uint64, uint8, error) {
+	request, answer := readRequestForSpan(span)
+	err := pc.SendRequest(request, answer)
+	if err != nil {
+		return 0, 0, err
+	}
+	resultCode, _ := pineAnswerToResult(answer)
+	memoryValue, _ := pineAnswerMemoryValue(answer)
+	return memoryValue, resultCode, nil
+}
+
+func writeMemoryValue(pc PineSender, span memorySpan, value uint64) (uint8, error) {
+	request, answer := writeValueRequestForSpan(span, value)
+	err := pc.SendRequest(request, answer)
+	if err != nil {
+		return 0, err
+	}
+	resultCode, _ := pineAnswerToResult(answer)
+	return resultCode, nil
+}
+
+// reads a value for every span with a single batch message
+// a non-zero result code means that nothing was read
+func readMemoryValues(pc PineSender, spans []memorySpan) ([]uint64, uint8, error) {
+	batchRequest := PineBatchRequest{}
+	batchAnswer := &PineBatchAnswer{}
+	for _, span := range spans {
+		request, answer := readRequestForSpan(span)
+		batchRequest.requests = append(batchRequest.requests, request)
+		batchAnswer.answers = append(batchAnswer.answers, answer)
+	}
+	err := pc.SendRequest(batchRequest, batchAnswer)
+	if err != nil || batchAnswer.resultCode != 0 {
+		return nil, batchAnswer.resultCode, err
+	}
+	values := make([]uint64, len(spans))
+	for i, answer := range batchAnswer.answers {
+		values[i], _ = pineAnswerMemoryValue(answer)
+	}
+	return values, 0, nil
+}
+
+// writes a value to every span with a single batch message
+func writeMemoryValues(pc PineSender, spans []memorySpan, values []uint64) (uint8, error) {
+	batchRequest := PineBatchRequest{}
+	batchAnswer := &PineBatchAnswer{}
+	for i, span := range spans {
+		request, answer := writeValueRequestForSpan(span, values[i])
+		batchRequest.requests = append(batchRequest.requests, request)
+		batchAnswer.answers = append(batchAnswer.answers, answer)
+	}
+	err := pc.SendRequest(batchRequest, batchAnswer)
+	if err != nil {
+		return 0, err
+	}
+	return batchAnswer.resultCode, nil
+}
+
+// reads up to memoryChunkSize bytes with a single batch message
+// a non-zero result code means that nothing was read
+func readMemoryChunk(pc PineSender, address uint32, length int) ([]byte, uint8, error) {
+	if length > memoryChunkSize {
+		return nil, 0, fmt.Errorf("can't read more than %v bytes in one chunk", memoryChunkSize)
+	}
+	spans := memorySpans(address, length, 8)
+	batchRequest := PineBatchRequest{}
+	batchAnswer := &PineBatchAnswer{}
+	for _, span := range spans {
+		request, answer := readRequestForSpan(span)
+		batchRequest.requests = append(batchRequest.requests, request)
+		batchAnswer.answers = append(batchAnswer.answers, answer)
+	}
+	err := pc.SendRequest(batchRequest, batchAnswer)
+	if err != nil {
+		return nil, 0, err
+	}
+	if batchAnswer.resultCode != 0 {
+		return nil, batchAnswer.resultCode, nil
+	}
+
+	byteOrder := memoryByteOrder(pc)
+	bytes := make([]byte, length)
+	offset := 0
+	for i, span := range spans {
+		memoryValue, _ := pineAnswerMemoryValue(batchAnswer.answers[i])
+		switch span.width {
+		case 8:
+			byteOrder.PutUint64(bytes[offset:], memoryValue)
+		case 4:
+			byteOrder.PutUint32(bytes[offset:], uint32(memoryValue))
+		case 2:
+			byteOrder.PutUint16(bytes[offset:], uint16(memoryValue))
+		default:
+			bytes[offset] = uint8(memoryValue)
+		}
+		offset += span.width
+	}
+	return bytes, 0, nil
+}
+
+// reads a range of memory one chunk at a time
+// when a chunk fails, the bytes read before that chunk are returned along with the result code
+func readMemory(pc PineSender, address uint32, length int) ([]byte, uint8, error) {
+	err := checkMemoryRange(address, length)
+	if err != nil {
+		return nil, 0, err
+	}
+	bytes := make([]byte, 0, length)
+	for offset := 0; offset < length; offset += memoryChunkSize {
+		chunkLength := min(memoryChunkSize, length-offset)
+		chunk, resultCode, err := readMemoryChunk(pc, address+uint32(offset), chunkLength)
+		if err != nil || resultCode != 0 {
+			return bytes, resultCode, err
+		}
+		bytes = append(bytes, chunk...)
+	}
+	return bytes, 0, nil
+}
+
+// writes up to memoryChunkSize bytes with a single batch message using the widest aligned writes possible
+func writeMemoryChunk(pc PineSender, address uint32, bytes []byte) (uint8, error) {
+	if len(bytes) > memoryChunkSize {
+		return 0, fmt.Errorf("can't write more than %v bytes in one chunk", memoryChunkSize)
+	}
+	byteOrder := memoryByteOrder(pc)
+	batchRequest := PineBatchRequest{}
+	batchAnswer := &PineBatchAnswer{}
+	offset := 0
+	for _, span := range memorySpans(address, len(bytes), 8) {
+		request, answer := writeRequestForSpan(span, bytes[offset:offset+span.width], byteOrder)
+		batchRequest.requests = append(batchRequest.requests, request)
+		batchAnswer.answers = append(batchAnswer.answers, answer)
+		offset += span.width
+	}
+	err := pc.SendRequest(batchRequest, batchAnswer)
+	if err != nil {
+		return 0, err
+	}
+	return batchAnswer.resultCode, nil
+}
+
+// writes the bytes one chunk at a time, stopping at the first chunk that fails
+func writeMemory(pc PineSender, address uint32, bytes []byte) (uint8, error) {
+	err := checkMemoryRange(address, len(bytes))
+	if err != nil {
+		return 0, err
+	}
+	for offset := 0; offset < len(bytes); offset += memoryChunkSize {
+		chunkLength := min(memoryChunkSize, len(bytes)-offset)
+		resultCode, err := writeMemoryChunk(pc, address+uint32(offset), bytes[offset:offset+chunkLength])
+		if err != nil || resultCode != 0 {
+			return resultCode, err
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// read-modify-write operations that are done by Woody while holding the networkLock, so nothing else can change the
+// value between the read and the write (e.g. two redemptions adding a life at the same time). Results that don't fit
+// in the type saturate at its bounds instead of wrapping around.
+
+var modifyOperationNames = []string{"add", "subtract", "multiply", "clamp", "set-bits", "clear-bits", "toggle-bits", "min", "max"}
+
+type modifyOperation struct {
+	name       string
+	memoryType MemoryType
+	// the number for add, subtract, multiply, min and max (exact for integer types)
+	operand      *big.Rat
+	floatOperand float64
+	// the bits for set-bits, clear-bits and toggle-bits
+	mask uint64
+	// the range for clamp, and any extra limits on the result (e.g. the min and max of a variable)
+	min *float64
+	max *float64
+}
+
+// the outcome of an operation
+type modifyResult struct {
+	oldRaw    uint64
+	newRaw    uint64
+	saturated bool
+}
+
+// builds the operation from Woody-Operation, Woody-Data, Woody-Min and Woody-Max
+func parseModifyOperation(params map[string]string, memoryType MemoryType) (*modifyOperation, error) {
+	name := ""
+	for _, operationName := range modifyOperationNames {
+		if normalizeParamKey(params["woodyoperation"]) == normalizeParamKey(operationName) {
+			name = operationName
+		}
+	}
+	if name == "" {
+		return nil, fmt.Errorf("unknown operation \"%v\". Supported values are %v", params["woodyoperation"], strings.Join(modifyOperationNames, ", "))
+	}
+	operation := &modifyOperation{name: name, memoryType: memoryType}
+
+	for _, bound := range []struct {
+		key    string
+		target **float64
+	}{{"woodymin", &operation.min}, {"woodymax", &operation.max}} {
+		literal, found := params[bound.key]
+		if !found {
+			continue
+		}
+		raw, err := memoryType.encode(literal)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %v as %v", literal, memoryType.name)
+		}
+		value, isNumber := memoryType.number(raw)
+		if !isNumber {
+			return nil, errors.New("the min and max have to be numbers")
+		}
+		*bound.target = &value
+	}
+	if operation.min != nil && operation.max != nil && *operation.min > *operation.max {
+		return nil, errors.New("min is bigger than max")
+	}
+
+	dataString, found := params["woodydata"]
+	switch name {
+	case "clamp":
+		if operation.min == nil && operation.max == nil {
+			return nil, errors.New("clamp needs a min and/or max")
+		}
+		return operation, nil
+	case "set-bits", "clear-bits", "toggle-bits":
+		if memoryType.kind != unsignedKind && memoryType.kind != signedKind {
+			return nil, fmt.Errorf("%v only works with integer types, not %v", name, memoryType.name)
+		}
+		if !found {
+			return nil, errors.New("no bits found in the data")
+		}
+		mask, err := parseInt(dataString, memoryType.width)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse bits %v for %v", dataString, memoryType.name)
+		}
+		operation.mask = mask
+		return operation, nil
+	}
+	if !found {
+		return nil, fmt.Errorf("no data found for %v", name)
+	}
+	if memoryType.kind == floatKind {
+		value, err := parseFloatOperand(dataString)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse data %v", dataString)
+		}
+		operation.floatOperand = value
+		return operation, nil
+	}
+	operand, err := parseRat(dataString)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse data %v", dataString)
+	}
+	// multiplying by a fraction (e.g. 0.5 to halve a value) makes sense but adding one to an integer doesn't
+	if name != "multiply" && !operand.IsInt() {
+		return nil, fmt.Errorf("%v needs a whole number for %v", name, memoryType.name)
+	}
+	operation.operand = operand
+	return operation, nil
+}
+
+// narrows the min and max (e.g. to the ones of a variable)
+func (operation *modifyOperation) limit(minimum *float64, maximum *float64) {
+	if minimum != nil && (operation.min == nil || *minimum > *operation.min) {
+		operation.min = minimum
+	}
+	if maximum != nil && (operation.max == nil || *maximum < *operation.max) {
+		operation.max = maximum
+	}
+}
+
+// follows any pointers in the address, reads the value, applies the operation and writes the result back without
+// letting anything else be sent to the emulator in between. Nothing is written when the value doesn't change
+func modifyMemoryValue(pc *PineConnection, address *addressExpression, operation *modifyOperation) (modifyResult, *addressResolution, uint8, error) {
+	var result modifyResult
+	var resolution *addressResolution
+	var resultCode uint8
+	err := pc.WithLock(func(sender PineSender) error {
+		var err error
+		resolution, err = address.resolve(sender)
+		if err != nil || resolution.resultCode != 0 {
+			return err
+		}
+		span := memorySpan{address: resolution.address, width: max(operation.memoryType.width/8, 1)}
+		result.oldRaw, resultCode, err = readMemoryValue(sender, span)
+		if err != nil || resultCode != 0 {
+			return err
+		}
+		result.newRaw, result.saturated = operation.apply(result.oldRaw)
+		if result.newRaw == result.oldRaw {
+			return nil
+		}
+		resultCode, err = writeMemoryValue(sender, span, result.newRaw)
+		return err
+	})
+	return result, resolution, resultCode, err
+}
+
+// the new raw value and whether it had to be saturated
+func (operation *modifyOperation) apply(raw uint64) (uint64, bool) {
+	memoryType := operation.memoryType
+	switch operation.name {
+	case "set-bits":
+		return raw | operation.mask, false
+	case "clear-bits":
+		return raw &^ operation.mask, false
+	case "toggle-bits":
+		return (raw ^ operation.mask) & memoryType.mask(), false
+	}
+
+	if memoryType.kind == floatKind {
+		old, isNumber := memoryType.number(raw)
+		if !isNumber {
+			// NaN and the infinities are left alone since there's no sensible answer
+			return raw, false
+		}
+		var result float64
+		switch operation.name {
+		case "add":
+			result = old + operation.floatOperand
+		case "subtract":
+			result = old - operation.floatOperand
+		case "multiply":
+			result = old * operation.floatOperand
+		case "min":
+			result = math.Min(old, operation.floatOperand)
+		case "max":
+			result = math.Max(old, operation.floatOperand)
+		case "clamp":
+			result = old
+		}
+		return operation.floatResult(result)
+	}
+
+	old := memoryType.rat(raw)
+	result := new(big.Rat)
+	switch operation.name {
+	case "add":
+		result.Add(old, operation.operand)
+	case "subtract":
+		result.Sub(old, operation.operand)
+	case "multiply":
+		result.Mul(old, operation.operand)
+	case "min":
+		result.Set(old)
+		if operation.operand.Cmp(old) < 0 {
+			result.Set(operation.operand)
+		}
+	case "max":
+		result.Set(old)
+		if operation.operand.Cmp(old) > 0 {
+			result.Set(operation.operand)
+		}
+	case "clamp":
+		result.Set(old)
+	}
+	return operation.integerResult(result)
+}
+
+// saturates at the biggest float for the type (rather than infinity) and at the min and max. Clamping to the min and
+// max isn't saturating when it's the operation
+func (operation *modifyOperation) floatResult(result float64) (uint64, bool) {
+	highest := math.MaxFloat64
+	if operation.memoryType.width == 32 {
+		highest = math.MaxFloat32
+	}
+	lowest := -highest
+	if operation.min != nil {
+		lowest = max(lowest, *operation.min)
+	}
+	if operation.max != nil {
+		highest = min(highest, *operation.max)
+	}
+	saturated := result < lowest || result > highest
+	result = min(max(result, lowest), highest)
+	if operation.memoryType.width == 32 {
+		return uint64(math.Float32bits(float32(result))), saturated && operation.name != "clamp"
+	}
+	return math.Float64bits(result), saturated && operation.name != "clamp"
+}
+
+// rounds to the nearest whole number (halves away from zero) and saturates at the bounds of the type and the min and
+// max. Clamping to the min and max isn't saturating when it's the operation
+func (operation *modifyOperation) integerResult(result *big.Rat) (uint64, bool) {
+	memoryType := operation.memoryType
+	rounded, remainder := new(big.Int).QuoRem(result.Num(), result.Denom(), new(big.Int))
+	if new(big.Int).Lsh(new(big.Int).Abs(remainder), 1).Cmp(result.Denom()) >= 0 {
+		rounded.Add(rounded, big.NewInt(int64(result.Num().Sign())))
+	}
+
+	lowest, highest := memoryType.bounds()
+	if operation.min != nil {
+		if minimum := floatToInt(*operation.min, math.Ceil); minimum.Cmp(lowest) > 0 {
+			lowest = minimum
+		}
+	}
+	if operation.max != nil {
+		if maximum := floatToInt(*operation.max, math.Floor); maximum.Cmp(highest) < 0 {
+			highest = maximum
+		}
+	}
+	saturated := false
+	if rounded.Cmp(lowest) < 0 {
+		rounded, saturated = lowest, true
+	}
+	if rounded.Cmp(highest) > 0 {
+		rounded, saturated = highest, true
+	}
+	if memoryType.kind == signedKind {
+		return uint64(rounded.Int64()) & memoryType.mask(), saturated && operation.name != "clamp"
+	}
+	return rounded.Uint64(), saturated && operation.name != "clamp"
+}
+
+func floatToInt(value float64, round func(float64) float64) *big.Int {
+	integer, _ := big.NewFloat(round(value)).Int(nil)
+	return integer
+}
+
+// the smallest and biggest values for an integer type (0 and 1 for bool)
+func (memoryType MemoryType) bounds() (*big.Int, *big.Int) {
+	switch memoryType.kind {
+	case signedKind:
+		highest := new(big.Int).Lsh(big.NewInt(1), uint(memoryType.width-1))
+		lowest := new(big.Int).Neg(highest)
+		return lowest, highest.Sub(highest, big.NewInt(1))
+	case boolKind:
+		return big.NewInt(0), big.NewInt(1)
+	default:
+		return big.NewInt(0), new(big.Int).SetUint64(memoryType.mask())
+	}
+}
+
+// the exact value of an integer type
+func (memoryType MemoryType) rat(raw uint64) *big.Rat {
+	switch decoded := memoryType.decode(raw).(type) {
+	case int64:
+		return new(big.Rat).SetInt64(decoded)
+	case bool:
+		if decoded {
+			return big.NewRat(1, 1)
+		}
+		return new(big.Rat)
+	default:
+		return new(big.Rat).SetUint64(raw & memoryType.mask())
+	}
+}
+
+// parses a whole number, a decimal or a fraction (e.g. "-5", "0.5" or "1/3"), with hex and binary for whole numbers
+func parseRat(literal string) (*big.Rat, error) {
+	literal = strings.TrimSpace(literal)
+	if hasRadixPrefix(literal) {
+		value, err := parseInt(literal, 64)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Rat).SetUint64(value), nil
+	}
+	value, ok := new(big.Rat).SetString(literal)
+	if !ok {
+		return nil, errors.New("unable to parse " + literal)
+	}
+	return value, nil
+}
+
+func parseFloatOperand(literal string) (float64, error) {
+	value, err := parseRat(literal)
+	if err != nil {
+		return 0, err
+	}
+	result, _ := value.Float64()
+	return result, nil
+}
+
+func (result modifyResult) toResult(memoryType MemoryType) map[string]any {
+	return map[string]any{
+		"oldValue":  memoryType.decode(result.oldRaw),
+		"newValue":  memoryType.decode(result.newRaw),
+		"saturated": result.saturated,
+		"type":      memoryType.name,
+	}
+}
+
+func handleModifyHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling modify HTTP request")
+	// for read-modify-write operations:
+	// - Woody-Operation is one of add, subtract, multiply, clamp, set-bits, clear-bits, toggle-bits, min or max
+	// - Woody-Address is the value to change (which can follow pointers) and Woody-Type is its type (u32 by default)
+	// - Woody-Data is the number for the operation (or the bits for set-bits, clear-bits and toggle-bits)
+	// - clamp keeps the value between Woody-Min and Woody-Max, which also limit the result of every other operation
+	// - the read and the write happen without anything else being sent to the emulator in between
+	// - results that don't fit in the type saturate at its bounds and the response has both the old and new values
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	addressString, found := params["woodyaddress"]
+	if !found {
+		errMessage := "no address found in the parameters"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	address, err := parseAddressExpression(addressString)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	typeName := params["woodytype"]
+	if typeName == "" {
+		typeName = "u32"
+	}
+	memoryType, err := parseMemoryType(typeName)
+	if err == nil {
+		var operation *modifyOperation
+		operation, err = parseModifyOperation(params, memoryType)
+		if err == nil {
+			pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+			if !found {
+				return
+			}
+			sendModifyResult(httpResponseWriter, pc, address, operation, nil)
+			return
+		}
+	}
+	errMessage := err.Error()
+	logger.Error(errMessage)
+	sendHTTPError(httpResponseWriter, 400, errMessage)
+}
+
+// runs the operation and sends the result along with any extra elements
+func sendModifyResult(httpResponseWriter http.ResponseWriter, pc *PineConnection, address *addressExpression, operation *modifyOperation, extra map[string]any) {
+	result, resolution, resultCode, err := modifyMemoryValue(pc, address, operation)
+	if address.isPointer() && sendPointerError(httpResponseWriter, pc, resolution, err) {
+		return
+	}
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while sending the PINE requests for "+operation.name, err)
+		return
+	}
+	if resultCode != 0 {
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), map[string]any{"resultCode": resultCode})
+		return
+	}
+	response := result.toResult(operation.memoryType)
+	response["resultCode"] = resultCode
+	response["operation"] = operation.name
+	if address.isPointer() {
+		resolution.addToResult(response)
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	sendHTTPJSON(httpResponseWriter, 200, response)
+}
@@ -7,25 +7,54 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"maps"
 	"net"
 	"os"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
-var networkLock sync.Mutex
 var defaultSlotForTargetMap = map[string]uint16{
 	"pcsx2": 28011, // based on https://github.com/PCSX2/pcsx2/blob/4dafea65f256f2fa342f5bd33c624bbc14e6e0f0/pcsx2/PINE.h#L13
 	"rpcs3": 28012, // based on https://github.com/RPCS3/rpcs3/blob/92d07072915b99917892dd7833c06eb44a09e234/rpcs3/Emu/IPC_config.h#L8
 }
 
+func sortedTargetNames() []string {
+	return slices.Sorted(maps.Keys(defaultSlotForTargetMap))
+}
+
 type PineConnection struct {
+	target  string
+	slot    uint16
 	network string
 	address string
-}
+	// set when the address was given to us instead of being found from the target and slot
+	addressGiven bool
+	// how long to wait for the emulator to answer
+	timeout time.Duration
+	// makes sure that only one thing is sent to the emulator at a time (each emulator gets its own lock)
+	networkLock sync.Mutex
+	// the connection is kept open between Requests and is nil until the first Request (or after an error)
+	conn net.Conn
+	// the number of Answers read on the current connection (used to detect emulators that close after every Answer)
+	answersOnConn int
+	// the number of connections in a row that were closed by the emulator right after the first Answer
+	closedAfterFirstAnswer int
+	// some emulators close the connection after every Answer so we fall back to connecting for every Request
+	oneShot bool
+}
+
+// the number of connections in a row that have to be closed after the first Answer before switching to one-shot mode
+const pineOneShotThreshold = 3
+
+// 15 seconds seems like a long time but we need some safe timeout
+const defaultPineTimeout = 15 * time.Second
 
 // setting slot to zero results in the default slot for the given target being used
 // note that the connection isn't
@@ -46,10 +75,25 @@ func NewPineConnection(target string, slot uint16) (*PineConnection, error) {
 	switch runtime.GOOS {
 	case "windows":
 		address := fmt.Sprintf(":%v", slot)
-		return &PineConnection{network: "tcp", address: address}, nil
+		return &PineConnection{target: target, slot: slot, network: "tcp", address: address, timeout: defaultPineTimeout}, nil
 	case "darwin", "linux":
 		address := findSocketPath(target, slot)
-		return &PineConnection{network: "unix", address: address}, nil
+		return &PineConnection{target: target, slot: slot, network: "unix", address: address, timeout: defaultPineTimeout}, nil
+	default:
+		return nil, errors.New("unknown operating system when creating PineConnection")
+	}
+}
+
+// like NewPineConnection except that the socket path (or host:port on Windows) is used as is
+func NewPineConnectionForSocketPath(target string, slot uint16, socketPath string) (*PineConnection, error) {
+	if target == "" {
+		return nil, errors.New("empty string provided for target name when creating PINE connection")
+	}
+	switch runtime.GOOS {
+	case "windows":
+		return &PineConnection{target: target, slot: slot, network: "tcp", address: socketPath, addressGiven: true, timeout: defaultPineTimeout}, nil
+	case "darwin", "linux":
+		return &PineConnection{target: target, slot: slot, network: "unix", address: socketPath, addressGiven: true, timeout: defaultPineTimeout}, nil
 	default:
 		return nil, errors.New("unknown operating system when creating PineConnection")
 	}
@@ -75,7 +119,7 @@ func findSocketPath(target string, slot uint16) string {
 	return dir + "/" + file
 }
 
-func (connection PineConnection) TestConnection() error {
+func (connection *PineConnection) TestConnection() error {
 	conn, err := connection.connect()
 	if err != nil {
 		return err
@@ -83,13 +127,13 @@ func (connection PineConnection) TestConnection() error {
 	return conn.Close()
 }
 
-func (connection PineConnection) connect() (net.Conn, error) {
+func (connection *PineConnection) connect() (net.Conn, error) {
 	conn, err := net.Dial(connection.network, connection.address)
 	if err == nil {
 		return conn, nil
 	}
 
-	if connection.network == "unix" {
+	if connection.network == "unix" && !connection.addressGiven {
 		// we need to check for a file with the slot number appended and one without (since we can't count on emulators always using one)
 		addressWithoutSlot := connection.address[:strings.LastIndex(connection.address, ".")]
 		conn, err := net.Dial(connection.network, addressWithoutSlot)
@@ -101,26 +145,201 @@ func (connection PineConnection) connect() (net.Conn, error) {
 	return nil, errors.New(fmt.Sprintf("could not connect to PINE at \"%v\"", connection.address))
 }
 
-func (connection PineConnection) Send(bytes []byte) ([]byte, error) {
+// the target and slot, e.g. "pcsx2:28011"
+func (connection *PineConnection) name() string {
+	return connection.target + ":" + strconv.Itoa(int(connection.slot))
+}
+
+func (connection *PineConnection) Send(bytes []byte) ([]byte, error) {
 	// let's make sure that only one thing is sent at a time
-	networkLock.Lock()
-	defer networkLock.Unlock()
+	// Requests that are waiting on the lock are sent one after another on the same connection as soon as the previous Answer
+	// has been read. We can't have more than one Request in flight since PCSX2 treats everything it reads as a single message.
+	connection.networkLock.Lock()
+	defer connection.networkLock.Unlock()
 
-	logger.Info("bytes for the Request", "bytes", hex.Dump(bytes))
+	return connection.sendLocked(bytes)
+}
+
+// sends the bytes when the networkLock is already held
+func (connection *PineConnection) sendLocked(bytes []byte) ([]byte, error) {
+	logger.Debug("bytes for the Request", "bytes", logHexDump(bytes))
+
+	if connection.oneShot {
+		return connection.sendOneShot(bytes)
+	}
+
+	reused := connection.conn != nil
+	readBytes, written, err := connection.sendPersistent(bytes)
+	if err != nil && reused && isConnectionClosedError(err) {
+		// the emulator may have been restarted (or closed the connection after the last Answer) since the connection was
+		// opened so we try again on a new connection. Writes and savestates are only sent again when they couldn't be
+		// written the first time since the emulator may have already done them
+		if written && !isReadOnlyPineMessage(bytes) {
+			logger.Info("persistent PINE connection was closed after a Request that changes the emulator was sent, not sending it again", "err", err)
+			return nil, err
+		}
+		logger.Info("persistent PINE connection was closed, reconnecting", "err", err)
+		readBytes, _, err = connection.sendPersistent(bytes)
+	}
+	return readBytes, err
+}
+
+// whether every Request in the message (which can be a batch) only reads, so it's safe to send again
+func isReadOnlyPineMessage(bytes []byte) bool {
+	for i := 4; i < len(bytes); {
+		switch bytes[i] {
+		case 0, 1, 2, 3:
+			// the opcode and the address
+			i += 5
+		case 8, 11, 12, 13, 14, 15:
+			i++
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// creates the bytes for the Request, sends them and then fills in the Answer with the bytes that come back
+func (connection *PineConnection) SendRequest(request PineRequest, answer PineAnswer) error {
+	return sendRequestWith(connection.Send, request, answer)
+}
 
+func sendRequestWith(send func([]byte) ([]byte, error), request PineRequest, answer PineAnswer) error {
+	requestBytes, err := request.toBytes()
+	if err != nil {
+		return err
+	}
+	answerBytes, err := send(requestBytes)
+	if err != nil {
+		return err
+	}
+	return answer.fromBytes(answerBytes)
+}
+
+// anything that Requests can be sent with, either a PineConnection or a PineConnection whose networkLock is held
+type PineSender interface {
+	SendRequest(request PineRequest, answer PineAnswer) error
+}
+
+// sends Requests on a connection whose networkLock is already held (see WithLock)
+type lockedPineConnection struct {
+	connection *PineConnection
+}
+
+func (locked lockedPineConnection) SendRequest(request PineRequest, answer PineAnswer) error {
+	return sendRequestWith(locked.connection.sendLocked, request, answer)
+}
+
+// holds the networkLock while fn runs so that nothing else is sent to the emulator in between its Requests (e.g. for
+// a read and then a write that mustn't race with other requests). fn has to send everything with the sender it's
+// given since the connection itself would wait for the lock forever
+func (connection *PineConnection) WithLock(fn func(sender PineSender) error) error {
+	connection.networkLock.Lock()
+	defer connection.networkLock.Unlock()
+
+	return fn(lockedPineConnection{connection: connection})
+}
+
+// closes the persistent connection (if there is one) so nothing is left open when we stop using this PineConnection
+func (connection *PineConnection) Close() {
+	connection.networkLock.Lock()
+	defer connection.networkLock.Unlock()
+
+	if connection.conn != nil {
+		connection.conn.Close()
+		connection.conn = nil
+	}
+}
+
+// also returns whether the bytes were written, after which the emulator may have acted on them even if there's an error
+func (connection *PineConnection) sendPersistent(bytes []byte) ([]byte, bool, error) {
+	if connection.conn == nil {
+		conn, err := connection.connect()
+		if err != nil {
+			return nil, false, err
+		}
+		connection.conn = conn
+		connection.answersOnConn = 0
+	}
+
+	err := connection.conn.SetDeadline(time.Now().Add(connection.timeout))
+	if err != nil {
+		connection.closePersistent()
+		return nil, false, err
+	}
+
+	logger.Debug("writing the Request bytes")
+	_, err = connection.conn.Write(bytes)
+	if err != nil {
+		connection.closePersistent()
+		return nil, false, err
+	}
+	logger.Debug("Request bytes written")
+
+	// the first 4 bytes of every Answer are the length of the whole Answer (including those 4 bytes)
+	readBytes := make([]byte, 4)
+	_, err = io.ReadFull(connection.conn, readBytes)
+	if err != nil {
+		connection.closePersistent()
+		return nil, true, err
+	}
+	length := binary.LittleEndian.Uint32(readBytes[0:])
+	if length < 5 || length > pineMaxAnswerSize {
+		connection.closePersistent()
+		return nil, true, fmt.Errorf("unexpected length %v for the Answer", length)
+	}
+	readBytes = append(readBytes, make([]byte, length-4)...)
+	_, err = io.ReadFull(connection.conn, readBytes[4:])
+	if err != nil {
+		connection.closePersistent()
+		return nil, true, err
+	}
+	logger.Debug("bytes for the Answer", "bytes", logHexDump(readBytes))
+
+	connection.answersOnConn++
+	if connection.answersOnConn > 1 {
+		connection.closedAfterFirstAnswer = 0
+	}
+	return readBytes, true, nil
+}
+
+func (connection *PineConnection) closePersistent() {
+	if connection.conn == nil {
+		return
+	}
+	connection.conn.Close()
+	connection.conn = nil
+
+	if connection.answersOnConn == 1 {
+		connection.closedAfterFirstAnswer++
+		if connection.closedAfterFirstAnswer >= pineOneShotThreshold {
+			logger.Info("emulator keeps closing the connection after each Answer, switching to one-shot connections", "address", connection.address)
+			connection.oneShot = true
+		}
+	} else {
+		connection.closedAfterFirstAnswer = 0
+	}
+}
+
+func isConnectionClosedError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, net.ErrClosed)
+}
+
+// this is for emulators that close the connection after each Answer
+func (connection *PineConnection) sendOneShot(bytes []byte) ([]byte, error) {
 	conn, err := connection.connect()
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
-	// 15 seconds seems like a long time but we need some safe timeout
-	err = conn.SetDeadline(time.Now().Add(15 * time.Second))
+	err = conn.SetDeadline(time.Now().Add(connection.timeout))
 	if err != nil {
 		return nil, err
 	}
 
-	logger.Info("writing the Request bytes")
+	logger.Debug("writing the Request bytes")
 	writer := bufio.NewWriter(conn)
 	_, err = writer.Write(bytes)
 	if err != nil {
@@ -135,15 +354,28 @@ func (connection PineConnection) Send(bytes []byte) ([]byte, error) {
 	} else {
 		conn.(*net.TCPConn).CloseWrite()
 	}
-	logger.Info("Request bytes written")
+	logger.Debug("Request bytes written")
 
 	// read the entire response
 	readBytes, err := io.ReadAll(conn)
-	logger.Info("bytes for the Answer", "bytes", hex.Dump(readBytes))
+	logger.Debug("bytes for the Answer", "bytes", logHexDump(readBytes))
 
 	return readBytes, err
 }
 
+// the most bytes to hex dump when logging (batch messages for bulk reads and writes can be tens of kilobytes)
+const maxLogHexDumpSize = 256
+
+// bytes that are only hex dumped if the log message is actually logged
+type logHexDump []byte
+
+func (bytes logHexDump) LogValue() slog.Value {
+	if len(bytes) > maxLogHexDumpSize {
+		return slog.StringValue(hex.Dump(bytes[:maxLogHexDumpSize]) + fmt.Sprintf("... %v more bytes", len(bytes)-maxLogHexDumpSize))
+	}
+	return slog.StringValue(hex.Dump(bytes))
+}
+
 type PineRequest interface {
 	toBytes() ([]byte, error)
 }
@@ -153,7 +385,134 @@ type PineAnswer interface {
 }
 
 // events are unimplemented in the standard right now
-// and batch messages are unimplemented because we don't need them
+
+// based on MAX_IPC_SIZE and MAX_IPC_RETURN_SIZE at https://github.com/PCSX2/pcsx2/blob/4dafea65f256f2fa342f5bd33c624bbc14e6e0f0/pcsx2/PINE.h#L17
+const pineMaxRequestSize = 650000
+const pineMaxAnswerSize = 450000
+
+// a batch message is every Request (minus their length) concatenated together after a single length
+// the Answer has a single result code followed by the data for each Answer, in the same order as the Requests
+// if any Request in the batch fails then the emulator only sends back the failing result code
+type PineBatchRequest struct {
+	requests []PineRequest
+}
+
+func (request PineBatchRequest) toBytes() ([]byte, error) {
+	// 4 bytes for the length
+	// remaining bytes are the opcode and arguments for each Request
+	bytes := make([]byte, 4)
+	for _, subRequest := range request.requests {
+		subRequestBytes, err := subRequest.toBytes()
+		if err != nil {
+			return nil, err
+		}
+		bytes = append(bytes, subRequestBytes[4:]...)
+	}
+	if len(bytes) > pineMaxRequestSize {
+		return nil, fmt.Errorf("length of bytes for PineBatchRequest (%v) > %v", len(bytes), pineMaxRequestSize)
+	}
+	binary.LittleEndian.PutUint32(bytes[0:], uint32(len(bytes)))
+	return bytes, nil
+}
+
+type PineBatchAnswer struct {
+	resultCode uint8
+	// this needs to be filled in with an empty Answer for each Request (in the same order) before calling fromBytes
+	answers []PineAnswer
+}
+
+func (answer *PineBatchAnswer) fromBytes(bytes []byte) error {
+	// 4 bytes for the length
+	// 1 byte for the result code
+	// remaining bytes are the data for each Answer
+	if len(bytes) < 5 {
+		return errors.New("bytes for PineBatchAnswer < 5")
+	}
+	length := binary.LittleEndian.Uint32(bytes[0:])
+	if int(length) != len(bytes) {
+		logger.Error("unexpected length (length != len(bytes))", "length", length, "len(bytes)", len(bytes))
+		return errors.New("length of bytes for PineBatchAnswer doesn't match the number of bytes received")
+	}
+	logger.Debug("batch answer bytes", "length", length)
+	answer.resultCode = bytes[4]
+	if answer.resultCode != 0 {
+		// there's no data for the individual Answers when the batch fails
+		return nil
+	}
+
+	offset := 5
+	for i, subAnswer := range answer.answers {
+		dataLength, err := pineAnswerDataLength(subAnswer, bytes[offset:])
+		if err != nil {
+			return fmt.Errorf("answer %v in PineBatchAnswer: %w", i, err)
+		}
+		// build the bytes that the Answer would have if it was sent by itself so we can reuse fromBytes
+		subAnswerBytes := make([]byte, 5+dataLength)
+		binary.LittleEndian.PutUint32(subAnswerBytes[0:], uint32(len(subAnswerBytes)))
+		subAnswerBytes[4] = answer.resultCode
+		copy(subAnswerBytes[5:], bytes[offset:offset+dataLength])
+		err = subAnswer.fromBytes(subAnswerBytes)
+		if err != nil {
+			return fmt.Errorf("answer %v in PineBatchAnswer: %w", i, err)
+		}
+		offset += dataLength
+	}
+	if offset != len(bytes) {
+		return fmt.Errorf("%v unexpected bytes left over after PineBatchAnswer", len(bytes)-offset)
+	}
+	return nil
+}
+
+// the number of bytes the emulator will send back for the batch. Strings are counted as empty since their length isn't
+// known until the emulator answers
+func (answer *PineBatchAnswer) expectedSize() int {
+	size := 5
+	for _, subAnswer := range answer.answers {
+		switch subAnswer.(type) {
+		case *PineRead8Answer:
+			size += 1
+		case *PineRead16Answer:
+			size += 2
+		case *PineRead32Answer, *PineStatusAnswer:
+			size += 4
+		case *PineRead64Answer:
+			size += 8
+		case *PineVersionAnswer, *PineTitleAnswer, *PineIDAnswer, *PineUUIDAnswer, *PineGameVersionAnswer:
+			// the length of the string
+			size += 4
+		}
+	}
+	return size
+}
+
+// the number of bytes that an Answer uses in a batch (not including the length and result code)
+func pineAnswerDataLength(answer PineAnswer, bytes []byte) (int, error) {
+	var dataLength int
+	switch answer.(type) {
+	case *PineRead8Answer:
+		dataLength = 1
+	case *PineRead16Answer:
+		dataLength = 2
+	case *PineRead32Answer, *PineStatusAnswer:
+		dataLength = 4
+	case *PineRead64Answer:
+		dataLength = 8
+	case *PineWrite8Answer, *PineWrite16Answer, *PineWrite32Answer, *PineWrite64Answer, *PineSaveStateAnswer, *PineLoadStateAnswer:
+		dataLength = 0
+	case *PineVersionAnswer, *PineTitleAnswer, *PineIDAnswer, *PineUUIDAnswer, *PineGameVersionAnswer:
+		// 4 bytes for the length of the string then the string itself
+		if len(bytes) < 4 {
+			return 0, errors.New("not enough bytes for the length of the string")
+		}
+		dataLength = 4 + int(binary.LittleEndian.Uint32(bytes[0:]))
+	default:
+		return 0, fmt.Errorf("unsupported Answer type %T in batch", answer)
+	}
+	if dataLength > len(bytes) {
+		return 0, fmt.Errorf("expected %v bytes of data but only %v remain", dataLength, len(bytes))
+	}
+	return dataLength, nil
+}
 
 type PineRead8Request struct {
 	address uint32
@@ -181,12 +540,15 @@ func (answer *PineRead8Answer) fromBytes(bytes []byte) error {
 	// 1 byte for the value
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 && length != 6 {
-		logger.Error("unexpected length (length != 5 && length != 6)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5 && length != 6)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5 or 6")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
-	answer.memoryValue = bytes[5]
+	// a failed read only has the result code
+	if length == 6 {
+		answer.memoryValue = bytes[5]
+	}
 	return nil
 }
 
@@ -216,12 +578,15 @@ func (answer *PineRead16Answer) fromBytes(bytes []byte) error {
 	// 2 bytes for the value
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 && length != 7 {
-		logger.Error("unexpected length (length != 5 && length != 7)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5 && length != 7)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5 or 7")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
-	answer.memoryValue = binary.LittleEndian.Uint16(bytes[5:])
+	// a failed read only has the result code
+	if length == 7 {
+		answer.memoryValue = binary.LittleEndian.Uint16(bytes[5:])
+	}
 	return nil
 }
 
@@ -251,12 +616,15 @@ func (answer *PineRead32Answer) fromBytes(bytes []byte) error {
 	// 4 bytes for the value
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 && length != 9 {
-		logger.Error("unexpected length (length != 5 && length != 9)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5 && length != 9)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5 or 9")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
-	answer.memoryValue = binary.LittleEndian.Uint32(bytes[5:])
+	// a failed read only has the result code
+	if length == 9 {
+		answer.memoryValue = binary.LittleEndian.Uint32(bytes[5:])
+	}
 	return nil
 }
 
@@ -286,12 +654,15 @@ func (answer *PineRead64Answer) fromBytes(bytes []byte) error {
 	// 8 bytes for the value
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 && length != 13 {
-		logger.Error("unexpected length (length != 5 && length != 13)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5 && length != 13)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5 or 13")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
-	answer.memoryValue = binary.LittleEndian.Uint64(bytes[5:])
+	// a failed read only has the result code
+	if length == 13 {
+		answer.memoryValue = binary.LittleEndian.Uint64(bytes[5:])
+	}
 	return nil
 }
 
@@ -322,10 +693,10 @@ func (answer *PineWrite8Answer) fromBytes(bytes []byte) error {
 	// 1 byte for the result code
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 {
-		logger.Error("unexpected length (length != 5)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	return nil
 }
@@ -357,10 +728,10 @@ func (answer *PineWrite16Answer) fromBytes(bytes []byte) error {
 	// 1 byte for the result code
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 {
-		logger.Error("unexpected length (length != 5)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	return nil
 }
@@ -392,10 +763,10 @@ func (answer *PineWrite32Answer) fromBytes(bytes []byte) error {
 	// 1 byte for the result code
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 {
-		logger.Error("unexpected length (length != 5)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	return nil
 }
@@ -427,10 +798,10 @@ func (answer *PineWrite64Answer) fromBytes(bytes []byte) error {
 	// 1 byte for the result code
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 {
-		logger.Error("unexpected length (length != 5)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	return nil
 }
@@ -460,7 +831,7 @@ func (answer *PineVersionAnswer) fromBytes(bytes []byte) error {
 	if length < 10 {
 		return errors.New("bytes for PineVersionAnswer < 10")
 	}
-	logger.Debug("version string", "bytes", hex.Dump(bytes))
+	logger.Debug("version string", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	versionStringLength := binary.LittleEndian.Uint32(bytes[5:])
 	answer.version = ""
@@ -495,10 +866,10 @@ func (answer *PineSaveStateAnswer) fromBytes(bytes []byte) error {
 	// 1 byte for the result code
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 {
-		logger.Error("unexpected length (length != 5)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineSaveStateAnswer != 5")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	return nil
 }
@@ -527,10 +898,10 @@ func (answer *PineLoadStateAnswer) fromBytes(bytes []byte) error {
 	// 1 byte for the result code
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 {
-		logger.Error("unexpected length (length != 5)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineLoadStateAnswer != 5")
 	}
-	logger.Debug("answer bytes", "bytes", hex.Dump(bytes))
+	logger.Debug("answer bytes", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	return nil
 }
@@ -560,7 +931,7 @@ func (answer *PineTitleAnswer) fromBytes(bytes []byte) error {
 	if length < 10 {
 		return errors.New("bytes for PineVersionAnswer < 10")
 	}
-	logger.Debug("version string", "bytes", hex.Dump(bytes))
+	logger.Debug("version string", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	versionStringLength := binary.LittleEndian.Uint32(bytes[5:])
 	answer.title = ""
@@ -596,7 +967,7 @@ func (answer *PineIDAnswer) fromBytes(bytes []byte) error {
 	if length < 10 {
 		return errors.New("bytes for PineVersionAnswer < 10")
 	}
-	logger.Debug("version string", "bytes", hex.Dump(bytes))
+	logger.Debug("version string", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	versionStringLength := binary.LittleEndian.Uint32(bytes[5:])
 	answer.id = ""
@@ -632,7 +1003,7 @@ func (answer *PineUUIDAnswer) fromBytes(bytes []byte) error {
 	if length < 10 {
 		return errors.New("bytes for PineVersionAnswer < 10")
 	}
-	logger.Debug("version string", "bytes", hex.Dump(bytes))
+	logger.Debug("version string", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	versionStringLength := binary.LittleEndian.Uint32(bytes[5:])
 	answer.uuid = ""
@@ -668,7 +1039,7 @@ func (answer *PineGameVersionAnswer) fromBytes(bytes []byte) error {
 	if length < 10 {
 		return errors.New("bytes for PineVersionAnswer < 10")
 	}
-	logger.Debug("version string", "bytes", hex.Dump(bytes))
+	logger.Debug("version string", "bytes", logHexDump(bytes))
 	answer.resultCode = bytes[4]
 	versionStringLength := binary.LittleEndian.Uint32(bytes[5:])
 	answer.gameVersion = ""
@@ -702,10 +1073,10 @@ func (answer *PineStatusAnswer) fromBytes(bytes []byte) error {
 	// remaining bytes for the version string
 	length := binary.LittleEndian.Uint32(bytes[0:])
 	if length != 5 && length != 9 {
-		logger.Error("unexpected length (length != 5 && length != 9)", "length", length, "bytes", hex.Dump(bytes))
+		logger.Error("unexpected length (length != 5 && length != 9)", "length", length, "bytes", logHexDump(bytes))
 		return errors.New("length of bytes for PineStatusAnswer != 5 or 9")
 	}
-	logger.Debug("status answer bytes", "length", length, "bytes", hex.Dump(bytes))
+	logger.Debug("status answer bytes", "length", length, "bytes", logHexDump(bytes))
 	// var answer *PineStatusAnswer = &PineStatusAnswer{}
 	answer.resultCode = bytes[4]
 	if length == 9 {
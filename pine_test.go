@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPineBatchRoundTrip(t *testing.T) {
+	batchRequest := PineBatchRequest{requests: []PineRequest{
+		PineRead8Request{address: 0x10},
+		PineRead16Request{address: 0x20},
+		PineWrite32Request{address: 0x30, data: 0x12345678},
+		PineRead64Request{address: 0x40},
+		PineTitleRequest{},
+		PineRead32Request{address: 0x50},
+	}}
+	requestBytes, err := batchRequest.toBytes()
+	if err != nil {
+		t.Fatalf("toBytes() error = %v", err)
+	}
+	if int(binary.LittleEndian.Uint32(requestBytes)) != len(requestBytes) {
+		t.Errorf("length = %v, want %v", binary.LittleEndian.Uint32(requestBytes), len(requestBytes))
+	}
+	// every Request without its own length
+	var wantBody []byte
+	for _, request := range batchRequest.requests {
+		subRequestBytes, err := request.toBytes()
+		if err != nil {
+			t.Fatalf("toBytes() error = %v", err)
+		}
+		wantBody = append(wantBody, subRequestBytes[4:]...)
+	}
+	if !bytes.Equal(requestBytes[4:], wantBody) {
+		t.Errorf("toBytes() = % X, want % X", requestBytes[4:], wantBody)
+	}
+
+	// the data for each Answer in order, with nothing for the write
+	answerBytes := []byte{0, 0, 0, 0, 0}
+	answerBytes = append(answerBytes, 0xAB)
+	answerBytes = binary.LittleEndian.AppendUint16(answerBytes, 0xCDEF)
+	answerBytes = binary.LittleEndian.AppendUint64(answerBytes, 0x1122334455667788)
+	answerBytes = binary.LittleEndian.AppendUint32(answerBytes, 5)
+	answerBytes = append(answerBytes, "Game\x00"...)
+	answerBytes = binary.LittleEndian.AppendUint32(answerBytes, 0x9ABCDEF0)
+	binary.LittleEndian.PutUint32(answerBytes, uint32(len(answerBytes)))
+
+	read8 := &PineRead8Answer{}
+	read16 := &PineRead16Answer{}
+	read64 := &PineRead64Answer{}
+	title := &PineTitleAnswer{}
+	read32 := &PineRead32Answer{}
+	batchAnswer := &PineBatchAnswer{answers: []PineAnswer{read8, read16, &PineWrite32Answer{}, read64, title, read32}}
+	if batchAnswer.expectedSize() != len(answerBytes)-5 {
+		t.Errorf("expectedSize() = %v, want %v (the title is counted as empty)", batchAnswer.expectedSize(), len(answerBytes)-5)
+	}
+	err = batchAnswer.fromBytes(answerBytes)
+	if err != nil {
+		t.Fatalf("fromBytes() error = %v", err)
+	}
+	if batchAnswer.resultCode != 0 {
+		t.Errorf("resultCode = %v, want 0", batchAnswer.resultCode)
+	}
+	if read8.memoryValue != 0xAB || read16.memoryValue != 0xCDEF || read64.memoryValue != 0x1122334455667788 || read32.memoryValue != 0x9ABCDEF0 {
+		t.Errorf("memory values = %X, %X, %X, %X", read8.memoryValue, read16.memoryValue, read64.memoryValue, read32.memoryValue)
+	}
+	if title.title != "Game" {
+		t.Errorf("title = %q, want \"Game\"", title.title)
+	}
+
+	// a batch with bytes left over or missing is an error
+	extraBytes := append(append([]byte(nil), answerBytes...), 0)
+	missingBytes := append([]byte(nil), answerBytes[:len(answerBytes)-1]...)
+	for _, badBytes := range [][]byte{extraBytes, missingBytes} {
+		binary.LittleEndian.PutUint32(badBytes, uint32(len(badBytes)))
+		batchAnswer := &PineBatchAnswer{answers: []PineAnswer{&PineRead8Answer{}, &PineRead16Answer{}, &PineWrite32Answer{}, &PineRead64Answer{}, &PineTitleAnswer{}, &PineRead32Answer{}}}
+		if batchAnswer.fromBytes(badBytes) == nil {
+			t.Errorf("fromBytes() with %v bytes didn't return an error", len(badBytes))
+		}
+	}
+}
+
+func TestPineBatchAnswerFailed(t *testing.T) {
+	// a failed batch only has the result code
+	read32 := &PineRead32Answer{}
+	batchAnswer := &PineBatchAnswer{answers: []PineAnswer{read32, &PineRead8Answer{}}}
+	err := batchAnswer.fromBytes([]byte{5, 0, 0, 0, 0xFF})
+	if err != nil {
+		t.Fatalf("fromBytes() error = %v", err)
+	}
+	if batchAnswer.resultCode != 0xFF || read32.memoryValue != 0 {
+		t.Errorf("resultCode = %v and memoryValue = %v, want 255 and 0", batchAnswer.resultCode, read32.memoryValue)
+	}
+}
+
+func TestPineBatchExpectedSize(t *testing.T) {
+	batchAnswer := &PineBatchAnswer{}
+	for len(batchAnswer.answers) < (pineMaxAnswerSize-5)/8 {
+		batchAnswer.answers = append(batchAnswer.answers, &PineRead64Answer{})
+	}
+	if batchAnswer.expectedSize() > pineMaxAnswerSize {
+		t.Errorf("expectedSize() = %v, want at most %v", batchAnswer.expectedSize(), pineMaxAnswerSize)
+	}
+	batchAnswer.answers = append(batchAnswer.answers, &PineRead64Answer{})
+	if batchAnswer.expectedSize() <= pineMaxAnswerSize {
+		t.Errorf("expectedSize() = %v, want more than %v", batchAnswer.expectedSize(), pineMaxAnswerSize)
+	}
+}
+
+func TestIsReadOnlyPineMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  PineRequest
+		readOnly bool
+	}{
+		{name: "read", request: PineRead32Request{address: 0x100}, readOnly: true},
+		{name: "title", request: PineTitleRequest{}, readOnly: true},
+		{name: "write", request: PineWrite8Request{address: 0x100, data: 1}, readOnly: false},
+		{name: "save state", request: PineSaveStateRequest{slot: 1}, readOnly: false},
+		{name: "load state", request: PineLoadStateRequest{slot: 1}, readOnly: false},
+		{name: "batch of reads", request: PineBatchRequest{requests: []PineRequest{PineRead8Request{}, PineIDRequest{}, PineRead64Request{}}}, readOnly: true},
+		{name: "batch with a write", request: PineBatchRequest{requests: []PineRequest{PineRead8Request{}, PineWrite64Request{address: 0x100}, PineRead8Request{}}}, readOnly: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requestBytes, err := test.request.toBytes()
+			if err != nil {
+				t.Fatalf("toBytes() error = %v", err)
+			}
+			if got := isReadOnlyPineMessage(requestBytes); got != test.readOnly {
+				t.Errorf("isReadOnlyPineMessage() = %v, want %v", got, test.readOnly)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// parsing of PCSX2 .pnach patch files (e.g. the cheats from gamehacking.org)
+// see https://forums.pcsx2.net/Thread-How-PNACH-files-work-2-0 for the format
+
+// a .pnach file along with the game it's for
+type pnachFile struct {
+	path      string
+	gameTitle string
+	// the serial (e.g. "SLUS-20312") from the file name or the gametitle, empty when there isn't one
+	serial string
+	// the CRC of the game's ELF (e.g. "8A1A5E10") from the file name, which is what PCSX2 gives as the UUID
+	crc    string
+	cheats []*Cheat
+}
+
+// a group of patch lines that are turned on and off together
+type Cheat struct {
+	// made from the name so it can be used in a URL (e.g. "infinite-health")
+	id          string
+	name        string
+	description string
+	author      string
+	patches     []pnachPatch
+	// the patches as code lines (and only the ones that are applied continuously)
+	lines           []codeLine
+	continuousLines []codeLine
+	// why the cheat can't be used with Woody (empty when it can)
+	unsupported string
+}
+
+// a single patch=place,cpu,address,type,data line
+type pnachPatch struct {
+	// 0 is applied once, 1 is applied continuously and 2 is both
+	place     int
+	cpu       string
+	address   uint32
+	patchType string
+	data      uint64
+	// the line as it was in the file
+	line string
+}
+
+var serialPattern = regexp.MustCompile(`(?i)\b([A-Z]{4})[-_ ]?(\d{3})\.?(\d{2})\b`)
+var crcPattern = regexp.MustCompile(`(?i)^[0-9A-F]{8}$`)
+
+func loadPnachFile(path string) (*pnachFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	pnach, err := parsePnach(file)
+	if err != nil {
+		return nil, err
+	}
+	pnach.path = path
+
+	// file names are usually CRC.pnach or SERIAL_CRC.pnach
+	baseName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, part := range strings.Split(baseName, "_") {
+		if crcPattern.MatchString(part) {
+			pnach.crc = strings.ToUpper(part)
+		} else if serial := parseSerial(part); serial != "" {
+			pnach.serial = serial
+		}
+	}
+	if pnach.serial == "" {
+		pnach.serial = parseSerial(pnach.gameTitle)
+	}
+	return pnach, nil
+}
+
+// finds a serial in the text and normalizes it to the way the emulators give it (e.g. "slus_203.12" is "SLUS-20312")
+func parseSerial(text string) string {
+	match := serialPattern.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	return strings.ToUpper(match[1]) + "-" + match[2] + match[3]
+}
+
+func parsePnach(reader io.Reader) (*pnachFile, error) {
+	pnach := &pnachFile{}
+	var cheat *Cheat
+	newCheat := func(name string) {
+		cheat = &Cheat{name: name}
+		pnach.cheats = append(pnach.cheats, cheat)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			newCheat(strings.TrimSpace(line[1 : len(line)-1]))
+		case strings.HasPrefix(line, "//") || strings.HasPrefix(line, ";"):
+			comment := strings.TrimSpace(strings.TrimLeft(line, "/;"))
+			if comment == "" {
+				continue
+			}
+			// in older files a comment names the patches after it, otherwise it describes the cheat
+			if cheat == nil || len(cheat.patches) > 0 {
+				newCheat(comment)
+			} else if comment != cheat.name {
+				cheat.description = strings.TrimSpace(cheat.description + "\n" + comment)
+			}
+		default:
+			key, value, found := strings.Cut(line, "=")
+			if !found {
+				return nil, fmt.Errorf("line %v: expected key=value but found \"%v\"", lineNumber, line)
+			}
+			key = strings.ToLower(strings.TrimSpace(key))
+			value = strings.TrimSpace(value)
+			switch key {
+			case "gametitle":
+				pnach.gameTitle = value
+			case "comment", "description":
+				if cheat != nil {
+					cheat.description = strings.TrimSpace(cheat.description + "\n" + value)
+				}
+			case "author":
+				if cheat != nil {
+					cheat.author = value
+				}
+			case "patch":
+				patch, err := parsePnachPatch(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %v: %w", lineNumber, err)
+				}
+				if cheat == nil {
+					newCheat("")
+				}
+				cheat.patches = append(cheat.patches, patch)
+			default:
+				// other settings (e.g. gsaspectratio) aren't cheats
+				logger.Debug("ignoring pnach line", "line", line)
+			}
+		}
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// cheats without any patches are only comments
+	var cheats []*Cheat
+	usedIDs := make(map[string]bool)
+	for _, cheat := range pnach.cheats {
+		if len(cheat.patches) == 0 {
+			continue
+		}
+		if cheat.name == "" {
+			cheat.name = fmt.Sprintf("Cheat %v", len(cheats)+1)
+		}
+		cheat.id = cheatID(cheat.name, usedIDs)
+		cheat.unsupported = cheat.checkSupported()
+		cheats = append(cheats, cheat)
+	}
+	pnach.cheats = cheats
+	return pnach, nil
+}
+
+// parses the place,cpu,address,type,data after patch=
+func parsePnachPatch(value string) (pnachPatch, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) < 5 {
+		return pnachPatch{}, fmt.Errorf("expected place,cpu,address,type,data for patch but found \"%v\"", value)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	place, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return pnachPatch{}, fmt.Errorf("unable to parse place \"%v\" for patch", fields[0])
+	}
+	address, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(fields[2]), "0x"), 16, 32)
+	if err != nil {
+		return pnachPatch{}, fmt.Errorf("unable to parse address \"%v\" for patch", fields[2])
+	}
+	// the data can have a comment after it
+	dataString, _, _ := strings.Cut(fields[4], " ")
+	data, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(dataString), "0x"), 16, 64)
+	if err != nil {
+		return pnachPatch{}, fmt.Errorf("unable to parse data \"%v\" for patch", fields[4])
+	}
+	return pnachPatch{
+		place:     place,
+		cpu:       strings.ToUpper(fields[1]),
+		address:   uint32(address),
+		patchType: strings.ToLower(fields[3]),
+		data:      data,
+		line:      "patch=" + value,
+	}, nil
+}
+
+// lowercase with dashes between the words, and a number on the end when the name has already been used
+func cheatID(name string, usedIDs map[string]bool) string {
+	var builder strings.Builder
+	for _, character := range strings.ToLower(name) {
+		if ('a' <= character && character <= 'z') || ('0' <= character && character <= '9') {
+			builder.WriteRune(character)
+		} else if builder.Len() > 0 && !strings.HasSuffix(builder.String(), "-") {
+			builder.WriteRune('-')
+		}
+	}
+	id := strings.TrimSuffix(builder.String(), "-")
+	if id == "" {
+		id = "cheat"
+	}
+	uniqueID := id
+	for i := 2; usedIDs[uniqueID]; i++ {
+		uniqueID = id + "-" + strconv.Itoa(i)
+	}
+	usedIDs[uniqueID] = true
+	return uniqueID
+}
+
+// PINE can only get to EE memory, and every line has to be a code that the code interpreter knows
+// this also turns the patches into the code lines that are run
+func (cheat *Cheat) checkSupported() string {
+	cheat.lines = nil
+	cheat.continuousLines = nil
+	for _, patch := range cheat.patches {
+		if patch.cpu != "EE" {
+			return "only EE patches are supported but found " + patch.cpu + " in \"" + patch.line + "\""
+		}
+		line, err := patch.codeLine()
+		if err != nil {
+			return err.Error()
+		}
+		cheat.lines = append(cheat.lines, line)
+		if patch.continuous() {
+			cheat.continuousLines = append(cheat.continuousLines, line)
+		}
+	}
+	err := checkCodeLines(cheat.lines)
+	if err == nil {
+		err = checkCodeLines(cheat.continuousLines)
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// whether the patch is applied over and over rather than once
+func (patch pnachPatch) continuous() bool {
+	return patch.place != 0
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// pointer expressions let an address follow pointers to values that move around (e.g. in objects on the heap), so
+// "[[0x3A1000]+0x20]+0x4" reads the pointer at 0x3A1000, adds 0x20, reads the pointer there and adds 0x4. Every
+// pointer is read with a Read32 and the whole chain is resolved under one hold of the networkLock along with the read
+// or write at the end, so the game can't be asked for anything else part way through.
+
+const maxPointerDepth = 16
+
+var ErrNullPointer = errors.New("null pointer")
+var ErrPointerOutOfRange = errors.New("pointer expression goes outside of the address space")
+var ErrPointerNotSupported = errors.New("pointer expressions aren't supported here")
+
+type addressExpression struct {
+	// the expression in brackets whose value is the address of the pointer (nil for a plain address)
+	pointer *addressExpression
+	// the plain address when there isn't a pointer
+	base   uint32
+	offset int64
+}
+
+// a pointer that was followed while resolving
+type pointerStep struct {
+	address uint32
+	value   uint32
+}
+
+type addressResolution struct {
+	address  uint32
+	pointers []pointerStep
+	// non-zero when reading a pointer failed
+	resultCode    uint8
+	failedAddress uint32
+}
+
+// whether an address is more than a plain number, so that it has to be parsed with parseAddressExpression
+func isAddressExpression(addressString string) bool {
+	return strings.ContainsAny(addressString, "[]+-")
+}
+
+// parses the address for the requests that don't follow pointers (e.g. freezes, which write the same address over and
+// over). Constant offsets like "0x3A1000+0x20" are fine since there's nothing to read
+func parsePlainAddress(addressString string) (uint32, error) {
+	if !isAddressExpression(addressString) {
+		address, err := parseInt(addressString, 32)
+		if err != nil {
+			return 0, errors.New("unable to parse address " + addressString)
+		}
+		return uint32(address), nil
+	}
+	expression, err := parseAddressExpression(addressString)
+	if err != nil {
+		return 0, err
+	}
+	address, constant := expression.constant()
+	if !constant {
+		return 0, fmt.Errorf("%w (the address %v follows pointers, which only Read/Write requests, strings, /modify and profile variables can do)", ErrPointerNotSupported, addressString)
+	}
+	return address, nil
+}
+
+// parses an address like "0x3A1000", "[0x3A1000]+0x20" or "[[0x3A1000]+0x20]-4". Numbers are hex, binary or decimal
+// like parseInt
+func parseAddressExpression(addressString string) (*addressExpression, error) {
+	parser := &addressParser{text: addressString}
+	expression, err := parser.parseExpression(0)
+	if err == nil && parser.skipSpaces() < len(parser.text) {
+		err = fmt.Errorf("unexpected \"%v\"", parser.text[parser.position:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse address %v: %w", addressString, err)
+	}
+	return expression, nil
+}
+
+type addressParser struct {
+	text     string
+	position int
+}
+
+func (parser *addressParser) skipSpaces() int {
+	for parser.position < len(parser.text) && parser.text[parser.position] == ' ' {
+		parser.position++
+	}
+	return parser.position
+}
+
+func (parser *addressParser) parseExpression(depth int) (*addressExpression, error) {
+	if depth > maxPointerDepth {
+		return nil, fmt.Errorf("more than %v pointers", maxPointerDepth)
+	}
+	expression := &addressExpression{}
+	if parser.skipSpaces() < len(parser.text) && parser.text[parser.position] == '[' {
+		parser.position++
+		pointer, err := parser.parseExpression(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if parser.skipSpaces() >= len(parser.text) || parser.text[parser.position] != ']' {
+			return nil, errors.New("missing ]")
+		}
+		parser.position++
+		expression.pointer = pointer
+	} else {
+		number, err := parser.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		expression.base = uint32(number)
+	}
+	for parser.skipSpaces() < len(parser.text) {
+		sign := parser.text[parser.position]
+		if sign != '+' && sign != '-' {
+			break
+		}
+		parser.position++
+		parser.skipSpaces()
+		number, err := parser.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		if sign == '-' {
+			expression.offset -= int64(number)
+		} else {
+			expression.offset += int64(number)
+		}
+	}
+	if _, valid := expression.constant(); expression.pointer == nil && !valid {
+		return nil, errors.New("the address goes outside of the address space")
+	}
+	return expression, nil
+}
+
+func (parser *addressParser) parseNumber() (uint64, error) {
+	start := parser.position
+	for parser.position < len(parser.text) && strings.IndexByte("[]+- ", parser.text[parser.position]) == -1 {
+		parser.position++
+	}
+	if start == parser.position {
+		return 0, fmt.Errorf("expected a number at position %v", start)
+	}
+	return parseInt(parser.text[start:parser.position], 32)
+}
+
+// the address when the expression doesn't have any pointers
+func (expression *addressExpression) constant() (uint32, bool) {
+	address := int64(expression.base) + expression.offset
+	if expression.pointer != nil || address < 0 || address > math.MaxUint32 {
+		return 0, false
+	}
+	return uint32(address), true
+}
+
+func (expression *addressExpression) isPointer() bool {
+	return expression.pointer != nil
+}
+
+// follows the pointers with the sender, which should be from WithLock so the chain can't change part way through.
+// A failed read is reported in the resultCode of the resolution, while a pointer of zero is an ErrNullPointer
+func (expression *addressExpression) resolve(sender PineSender) (*addressResolution, error) {
+	resolution := &addressResolution{}
+	err := expression.resolveInto(sender, resolution)
+	return resolution, err
+}
+
+func (expression *addressExpression) resolveInto(sender PineSender, resolution *addressResolution) error {
+	base := int64(expression.base)
+	if expression.pointer != nil {
+		err := expression.pointer.resolveInto(sender, resolution)
+		if err != nil || resolution.resultCode != 0 {
+			return err
+		}
+		pointerAddress := resolution.address
+		value, resultCode, err := readMemoryValue(sender, memorySpan{address: pointerAddress, width: 4})
+		if err != nil {
+			return err
+		}
+		if resultCode != 0 {
+			resolution.resultCode = resultCode
+			resolution.failedAddress = pointerAddress
+			return nil
+		}
+		resolution.pointers = append(resolution.pointers, pointerStep{address: pointerAddress, value: uint32(value)})
+		if value == 0 {
+			return fmt.Errorf("%w at 0x%X", ErrNullPointer, pointerAddress)
+		}
+		base = int64(value)
+	}
+	address := base + expression.offset
+	if address < 0 || address > math.MaxUint32 {
+		return ErrPointerOutOfRange
+	}
+	resolution.address = uint32(address)
+	return nil
+}
+
+// adds the resolved address and every pointer that was followed to the elements of a response
+func (resolution *addressResolution) addToResult(result map[string]any) {
+	pointers := make([]map[string]any, len(resolution.pointers))
+	for i, step := range resolution.pointers {
+		pointers[i] = map[string]any{
+			"address": fmt.Sprintf("0x%X", step.address),
+			"value":   fmt.Sprintf("0x%X", step.value),
+		}
+	}
+	result["pointers"] = pointers
+	if resolution.resultCode == 0 {
+		result["resolvedAddress"] = fmt.Sprintf("0x%X", resolution.address)
+	} else {
+		result["failedAddress"] = fmt.Sprintf("0x%X", resolution.failedAddress)
+	}
+}
+
+// sends a resolution that didn't get to the end of the chain. It returns false when there's nothing to send
+func sendPointerError(httpResponseWriter http.ResponseWriter, pc *PineConnection, resolution *addressResolution, err error) bool {
+	switch {
+	case errors.Is(err, ErrNullPointer) || errors.Is(err, ErrPointerOutOfRange):
+		// e.g. the object hasn't been made yet, which is up to the game rather than an error in the request
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		result := map[string]any{"errMessage": errMessage}
+		resolution.addToResult(result)
+		delete(result, "resolvedAddress")
+		sendHTTPJSON(httpResponseWriter, 409, result)
+	case err != nil:
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while following the pointers in the address", err)
+	case resolution.resultCode != 0:
+		result := map[string]any{"resultCode": resolution.resultCode}
+		resolution.addToResult(result)
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resolution.resultCode), result)
+	default:
+		return false
+	}
+	return true
+}
+
+// sends a read or write whose Woody-Address is a pointer expression. The pointers are followed and the read or write
+// is sent without letting anything else be sent to the emulator in between. pc can be nil to use the Woody-Target
+func handlePointerRequest(httpResponseWriter http.ResponseWriter, pc *PineConnection, pineRequestType string, pineRequestParams map[string]string, extra map[string]any) {
+	expression, err := parseAddressExpression(pineRequestParams["woodyaddress"])
+	if err == nil {
+		// checks the rest of the parameters before anything is sent
+		params := maps.Clone(pineRequestParams)
+		params["woodyaddress"] = "0"
+		_, err = newPineOperation(pineRequestType, params)
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if pc == nil {
+		var found bool
+		pc, found = connectionForTarget(httpResponseWriter, pineRequestParams["woodytarget"])
+		if !found {
+			return
+		}
+	}
+
+	var resolution *addressResolution
+	var operation *pineOperation
+	err = pc.WithLock(func(sender PineSender) error {
+		var err error
+		resolution, err = expression.resolve(sender)
+		if err != nil || resolution.resultCode != 0 {
+			return err
+		}
+		params := maps.Clone(pineRequestParams)
+		params["woodyaddress"] = fmt.Sprint(resolution.address)
+		operation, err = newPineOperation(pineRequestType, params)
+		if err != nil {
+			return err
+		}
+		return sender.SendRequest(operation.request, operation.answer)
+	})
+	if sendPointerError(httpResponseWriter, pc, resolution, err) {
+		return
+	}
+	resultCode, result := operation.result()
+	resolution.addToResult(result)
+	for key, value := range extra {
+		result[key] = value
+	}
+	sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), result)
+}
@@ -0,0 +1,709 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pointer scans find pointer chains (see pointer.go) that lead to an address the way Cheat Engine's pointer scanner
+// does. A job reads the region one chunk at a time and keeps every value that looks like a pointer into the region in
+// a reverse pointer map, sorted by where the pointers point. It then works backwards from the address: any pointer up
+// to maxOffset bytes before an address can reach it, so following them back level by level finds every chain of up to
+// maxDepth pointers. A chain starts from a pointer in the static region, which is memory that doesn't move (e.g. the
+// data of the game's executable). After a level reload, a rescan follows every chain again and only keeps the ones
+// that lead to the new address of the value. Jobs run in the background and are kept until they're deleted or Woody
+// stops.
+
+const defaultPointerScanDepth = 3
+const maxPointerScanDepth = 6
+const defaultPointerScanOffset = 0x1000
+const maxPointerScanOffset = 0x10000
+
+// the kernel has the first 1MB of PS2 EE RAM, so pointer scans for PCSX2 start after it by default
+const defaultPointerScanAddress = 0x100000
+
+// the reverse pointer map takes 8 bytes for every pointer
+const maxPointerScanPointers = 4 * 1024 * 1024
+const maxPointerScanNodes = 256 * 1024
+const maxPointerChains = 10000
+const maxPointerScans = 4
+const pointerScanBatchSize = 1024
+
+var ErrPointerScanNotFound = errors.New("pointer scan not found")
+var ErrTooManyPointerScans = fmt.Errorf("there can't be more than %v pointer scans. Delete one first", maxPointerScans)
+var ErrPointerScanRunning = errors.New("the pointer scan is still running")
+var ErrTooManyPointers = fmt.Errorf("more than %v pointers found. Scan a smaller region", maxPointerScanPointers)
+
+var errPointerScanCancelled = errors.New("pointer scan cancelled")
+
+type PointerScanManager struct {
+	lock   sync.Mutex
+	jobs   map[int]*pointerScan
+	nextID int
+}
+
+type pointerScan struct {
+	// held while reading or changing the job since it runs in the background
+	lock sync.Mutex
+
+	id int
+	// the concrete connection name (e.g. "pcsx2:28011") and game that the scan was started with
+	target      string
+	gameID      string
+	gameVersion string
+	// where the chains have to lead (the new address after a rescan)
+	targetAddress uint32
+	// the region that is read for pointers
+	address uint32
+	length  int
+	// chains start from a pointer in here. Any pointer can start a chain when there's no static region
+	staticAddress uint32
+	staticLength  int
+	maxDepth      int
+	maxOffset     int
+
+	// running, done, failed or cancelled
+	status string
+	// reading, searching or rescanning while running
+	phase         string
+	progress      int
+	progressTotal int
+	cancelled     bool
+
+	pointers        int
+	unreadableBytes int
+	chains          []pointerChain
+	truncated       bool
+	rescans         int
+	errMessage      string
+	created         time.Time
+	updated         time.Time
+}
+
+type pointerChain struct {
+	// the address of the first pointer
+	base uint32
+	// what gets added after following each pointer, from the base towards the target
+	offsets []uint32
+}
+
+// an address that leads to the target, along with how it gets there
+type pointerNode struct {
+	address uint32
+	// the index of the node that the pointer at this address leads to (-1 for the target)
+	parent int
+	// the distance from where the pointer points to the address of the parent
+	offset uint32
+	depth  int
+}
+
+func NewPointerScanManager() *PointerScanManager {
+	return &PointerScanManager{jobs: make(map[int]*pointerScan), nextID: 1}
+}
+
+func (manager *PointerScanManager) job(id string) (*pointerScan, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	number, err := strconv.Atoi(id)
+	job, found := manager.jobs[number]
+	if err != nil || !found {
+		return nil, fmt.Errorf("%w with id %v", ErrPointerScanNotFound, id)
+	}
+	return job, nil
+}
+
+func (manager *PointerScanManager) List() []map[string]any {
+	manager.lock.Lock()
+	jobs := make([]*pointerScan, 0, len(manager.jobs))
+	for _, job := range manager.jobs {
+		jobs = append(jobs, job)
+	}
+	manager.lock.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].id < jobs[j].id })
+	results := make([]map[string]any, len(jobs))
+	for i, job := range jobs {
+		results[i] = job.toResult()
+	}
+	return results
+}
+
+// starts reading the region and searching for chains in the background
+func (manager *PointerScanManager) Start(pc *PineConnection, game gameInfo, job *pointerScan) error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if len(manager.jobs) >= maxPointerScans {
+		return ErrTooManyPointerScans
+	}
+	job.id = manager.nextID
+	manager.nextID++
+	job.target = pc.name()
+	job.gameID = game.id
+	job.gameVersion = game.gameVersion
+	job.status = "running"
+	job.phase = "reading"
+	job.progressTotal = job.length
+	job.created = time.Now()
+	job.updated = job.created
+	manager.jobs[job.id] = job
+
+	go job.scan(pc)
+	return nil
+}
+
+// starts following the chains again in the background, keeping the ones that lead to the new address
+func (manager *PointerScanManager) Rescan(pc *PineConnection, job *pointerScan, targetAddress uint32) error {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	if job.status == "running" {
+		return ErrPointerScanRunning
+	}
+	job.status = "running"
+	job.phase = "rescanning"
+	job.progress = 0
+	job.progressTotal = job.maxDepth
+	job.errMessage = ""
+	job.updated = time.Now()
+	chains := job.chains
+
+	go job.rescan(pc, chains, targetAddress)
+	return nil
+}
+
+// forgets the job, stopping it first when it's still running
+func (manager *PointerScanManager) Delete(id string) error {
+	job, err := manager.job(id)
+	if err != nil {
+		return err
+	}
+	manager.lock.Lock()
+	delete(manager.jobs, job.id)
+	manager.lock.Unlock()
+
+	job.lock.Lock()
+	job.cancelled = true
+	job.lock.Unlock()
+	return nil
+}
+
+// sets the progress and returns errPointerScanCancelled once the job has been deleted
+func (job *pointerScan) report(phase string, progress int, progressTotal int) error {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.phase = phase
+	job.progress = progress
+	job.progressTotal = progressTotal
+	if job.cancelled {
+		return errPointerScanCancelled
+	}
+	return nil
+}
+
+func (job *pointerScan) finish(pc *PineConnection, err error, update func()) {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	job.phase = ""
+	job.updated = time.Now()
+	switch {
+	case job.cancelled || errors.Is(err, errPointerScanCancelled):
+		job.status = "cancelled"
+		logger.Info("pointer scan cancelled", "id", job.id)
+	case err != nil:
+		if !errors.Is(err, ErrTooManyPointers) {
+			supervisor.ConnectionFailed(pc)
+		}
+		job.status = "failed"
+		job.errMessage = err.Error()
+		logger.Error("pointer scan failed", "id", job.id, "err", err)
+	default:
+		update()
+		job.status = "done"
+		logger.Info("pointer scan done", "id", job.id, "pointers", job.pointers, "chains", len(job.chains), "truncated", job.truncated)
+	}
+}
+
+func (job *pointerScan) scan(pc *PineConnection) {
+	pointers, unreadableBytes, err := job.readPointers(pc)
+	var chains []pointerChain
+	var truncated bool
+	if err == nil {
+		chains, truncated, err = job.search(pointers)
+	}
+	job.finish(pc, err, func() {
+		job.pointers = len(pointers)
+		job.unreadableBytes = unreadableBytes
+		job.chains = chains
+		job.truncated = truncated
+	})
+}
+
+// reads the region one chunk at a time (so other requests still get their turn) and returns the reverse pointer map:
+// every aligned value that points into the region, packed as value<<32|address and sorted so that the pointers to an
+// address are next to each other
+func (job *pointerScan) readPointers(pc *PineConnection) ([]uint64, int, error) {
+	byteOrder := memoryByteOrder(pc)
+	pointers := []uint64{}
+	unreadableBytes := 0
+	for offset := 0; offset < job.length; offset += memoryChunkSize {
+		err := job.report("reading", offset, job.length)
+		if err != nil {
+			return nil, 0, err
+		}
+		chunkLength := min(memoryChunkSize, job.length-offset)
+		chunk, resultCode, err := readMemoryChunk(pc, job.address+uint32(offset), chunkLength)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resultCode != 0 {
+			unreadableBytes += chunkLength
+			continue
+		}
+		for i := 0; i+4 <= len(chunk); i += 4 {
+			value := byteOrder.Uint32(chunk[i:])
+			if value == 0 || value%4 != 0 || !job.inRegion(value) {
+				continue
+			}
+			if len(pointers) == maxPointerScanPointers {
+				return nil, 0, ErrTooManyPointers
+			}
+			pointers = append(pointers, uint64(value)<<32|uint64(job.address+uint32(offset+i)))
+		}
+	}
+	slices.Sort(pointers)
+	return pointers, unreadableBytes, nil
+}
+
+// works backwards from the target one level at a time, so shorter chains are found first
+func (job *pointerScan) search(pointers []uint64) ([]pointerChain, bool, error) {
+	nodes := []pointerNode{{address: job.targetAddress, parent: -1}}
+	chains := []pointerChain{}
+	for i := 0; i < len(nodes); i++ {
+		node := nodes[i]
+		if i%1024 == 0 {
+			err := job.report("searching", node.depth, job.maxDepth)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		lowest := uint64(node.address-min(node.address, uint32(job.maxOffset))) << 32
+		first := sort.Search(len(pointers), func(j int) bool { return pointers[j] >= lowest })
+		for _, pointer := range pointers[first:] {
+			value := uint32(pointer >> 32)
+			if value > node.address {
+				break
+			}
+			child := pointerNode{address: uint32(pointer), parent: i, offset: node.address - value, depth: node.depth + 1}
+			static := job.isStatic(child.address)
+			if static {
+				if len(chains) == maxPointerChains {
+					return chains, true, nil
+				}
+				chains = append(chains, chainForNode(nodes, child))
+			}
+			if child.depth < job.maxDepth && (!static || job.staticLength == 0) {
+				if len(nodes) == maxPointerScanNodes {
+					return chains, true, nil
+				}
+				nodes = append(nodes, child)
+			}
+		}
+	}
+	return chains, false, nil
+}
+
+func chainForNode(nodes []pointerNode, node pointerNode) pointerChain {
+	chain := pointerChain{base: node.address}
+	for ; node.parent != -1; node = nodes[node.parent] {
+		chain.offsets = append(chain.offsets, node.offset)
+	}
+	return chain
+}
+
+// follows every chain one level at a time, reading the pointers of many chains with each batch message. A chain is
+// dropped when one of its pointers can't be read or is zero
+func (job *pointerScan) rescan(pc *PineConnection, chains []pointerChain, targetAddress uint32) {
+	addresses := make([]uint32, len(chains))
+	alive := make([]bool, len(chains))
+	for i, chain := range chains {
+		addresses[i] = chain.base
+		alive[i] = true
+	}
+	var err error
+	for level := 0; level < job.maxDepth && err == nil; level++ {
+		var indexes []int
+		for i, chain := range chains {
+			if alive[i] && level < len(chain.offsets) {
+				indexes = append(indexes, i)
+			}
+		}
+		for start := 0; start < len(indexes) && err == nil; start += pointerScanBatchSize {
+			err = job.report("rescanning", level, job.maxDepth)
+			if err == nil {
+				batch := indexes[start:min(start+pointerScanBatchSize, len(indexes))]
+				err = followPointers(pc, chains, batch, level, addresses, alive)
+			}
+		}
+	}
+	job.finish(pc, err, func() {
+		kept := []pointerChain{}
+		for i, chain := range chains {
+			if alive[i] && addresses[i] == targetAddress {
+				kept = append(kept, chain)
+			}
+		}
+		job.targetAddress = targetAddress
+		job.chains = kept
+		job.rescans++
+	})
+}
+
+// reads the pointers at the addresses of the chains and moves them along to the next address
+func followPointers(pc *PineConnection, chains []pointerChain, batch []int, level int, addresses []uint32, alive []bool) error {
+	spans := make([]memorySpan, len(batch))
+	for i, index := range batch {
+		spans[i] = memorySpan{address: addresses[index], width: 4}
+	}
+	values, resultCode, err := readMemoryValues(pc, spans)
+	if err != nil {
+		return err
+	}
+	if resultCode != 0 {
+		// one unreadable pointer fails the whole batch, so find out which ones can be read
+		values = make([]uint64, len(batch))
+		for i, span := range spans {
+			var valueResultCode uint8
+			values[i], valueResultCode, err = readMemoryValue(pc, span)
+			if err != nil {
+				return err
+			}
+			if valueResultCode != 0 {
+				values[i] = 0
+			}
+		}
+	}
+	for i, index := range batch {
+		next := values[i] + uint64(chains[index].offsets[level])
+		if values[i] == 0 || next > math.MaxUint32 {
+			alive[index] = false
+			continue
+		}
+		addresses[index] = uint32(next)
+	}
+	return nil
+}
+
+func (job *pointerScan) inRegion(address uint32) bool {
+	return address >= job.address && int64(address-job.address) < int64(job.length)
+}
+
+func (job *pointerScan) isStatic(address uint32) bool {
+	return job.staticLength == 0 || (address >= job.staticAddress && int64(address-job.staticAddress) < int64(job.staticLength))
+}
+
+// the chain as a pointer expression (e.g. "[[0x3A1000]+0x20]+0x4")
+func (chain pointerChain) String() string {
+	expression := fmt.Sprintf("0x%X", chain.base)
+	for _, offset := range chain.offsets {
+		expression = "[" + expression + "]"
+		if offset != 0 {
+			expression += fmt.Sprintf("+0x%X", offset)
+		}
+	}
+	return expression
+}
+
+func (chain pointerChain) toResult() map[string]any {
+	offsets := make([]string, len(chain.offsets))
+	for i, offset := range chain.offsets {
+		offsets[i] = fmt.Sprintf("0x%X", offset)
+	}
+	return map[string]any{
+		"expression": chain.String(),
+		"base":       fmt.Sprintf("0x%X", chain.base),
+		"offsets":    offsets,
+	}
+}
+
+func (job *pointerScan) toResult() map[string]any {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	result := map[string]any{
+		"id":              job.id,
+		"target":          job.target,
+		"gameId":          job.gameID,
+		"gameVersion":     job.gameVersion,
+		"address":         fmt.Sprintf("0x%X", job.targetAddress),
+		"regionAddress":   fmt.Sprintf("0x%X", job.address),
+		"regionLength":    job.length,
+		"maxDepth":        job.maxDepth,
+		"maxOffset":       job.maxOffset,
+		"status":          job.status,
+		"pointers":        job.pointers,
+		"unreadableBytes": job.unreadableBytes,
+		"chains":          len(job.chains),
+		"truncated":       job.truncated,
+		"rescans":         job.rescans,
+		"created":         job.created.Format(time.RFC3339Nano),
+		"updated":         job.updated.Format(time.RFC3339Nano),
+	}
+	if job.staticLength != 0 {
+		result["staticAddress"] = fmt.Sprintf("0x%X", job.staticAddress)
+		result["staticLength"] = job.staticLength
+	}
+	if job.status == "running" {
+		result["phase"] = job.phase
+		result["progress"] = job.progress
+		result["progressTotal"] = job.progressTotal
+	}
+	if job.errMessage != "" {
+		result["errMessage"] = job.errMessage
+	}
+	return result
+}
+
+// the chains from offset on (up to limit of them)
+func (job *pointerScan) page(offset int, limit int) []map[string]any {
+	job.lock.Lock()
+	defer job.lock.Unlock()
+	results := []map[string]any{}
+	for i := offset; i < len(job.chains) && len(results) < limit; i++ {
+		results = append(results, job.chains[i].toResult())
+	}
+	return results
+}
+
+func parsePointerScan(params map[string]string, pc *PineConnection) (*pointerScan, error) {
+	job := &pointerScan{maxDepth: defaultPointerScanDepth, maxOffset: defaultPointerScanOffset}
+	addressString, found := params["woodyaddress"]
+	if !found {
+		return nil, errors.New("no address found in the parameters")
+	}
+	targetAddress, err := parseInt(addressString, 32)
+	if err != nil {
+		return nil, errors.New("unable to parse address " + addressString)
+	}
+	job.targetAddress = uint32(targetAddress)
+
+	var regionAddress uint64
+	if regionAddressString, found := params["woodyregionaddress"]; found {
+		regionAddress, err = parseInt(regionAddressString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse region address " + regionAddressString)
+		}
+	} else if pc.target == "pcsx2" {
+		regionAddress = defaultPointerScanAddress
+	}
+	job.address = uint32(regionAddress)
+	lengthString, found := params["woodyregionlength"]
+	switch {
+	case found:
+		length, err := parseInt(lengthString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse region length " + lengthString)
+		}
+		job.length = int(length)
+	case pc.target == "pcsx2":
+		job.length = defaultScanLength - int(min(regionAddress, defaultScanLength))
+	default:
+		return nil, errors.New("no region length found in the parameters (it's only optional for PCSX2)")
+	}
+	err = checkMemoryRange(job.address, job.length)
+	if err != nil {
+		return nil, err
+	}
+	if job.address%4 != 0 || job.length < 4 {
+		return nil, errors.New("the region has to start at a multiple of 4 and be at least 4 bytes")
+	}
+	if !job.inRegion(job.targetAddress) {
+		return nil, errors.New("the address has to be inside the region")
+	}
+
+	if staticAddressString, found := params["woodystaticaddress"]; found {
+		staticAddress, err := parseInt(staticAddressString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse static address " + staticAddressString)
+		}
+		job.staticAddress = uint32(staticAddress)
+		staticLengthString, found := params["woodystaticlength"]
+		if !found {
+			return nil, errors.New("a static address needs a static length")
+		}
+		staticLength, err := parseInt(staticLengthString, 32)
+		if err != nil || staticLength == 0 {
+			return nil, errors.New("unable to parse static length " + staticLengthString)
+		}
+		job.staticLength = int(staticLength)
+		err = checkMemoryRange(job.staticAddress, job.staticLength)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, param := range []struct {
+		key    string
+		target *int
+		limit  int
+	}{{"woodydepth", &job.maxDepth, maxPointerScanDepth}, {"woodymaxoffset", &job.maxOffset, maxPointerScanOffset}} {
+		literal, found := params[param.key]
+		if !found {
+			continue
+		}
+		value, err := parseInt(literal, 32)
+		if err != nil || value < 1 || value > uint64(param.limit) {
+			return nil, fmt.Errorf("%v has to be a number between 1 and %v", param.key, param.limit)
+		}
+		*param.target = int(value)
+	}
+	return job, nil
+}
+
+func handlePointerScansHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling pointer scans HTTP request")
+	// for pointer scans:
+	// - a POST to /pointerscans starts a job that finds pointer chains to Woody-Address, reading pointers from
+	//   Woody-Region-Length bytes from Woody-Region-Address (PS2 EE RAM after the kernel for PCSX2 by default)
+	// - chains start from a pointer between Woody-Static-Address and Woody-Static-Address + Woody-Static-Length
+	//   (anywhere in the region when not given), have up to Woody-Depth pointers (3 by default) and add up to
+	//   Woody-Max-Offset (0x1000 by default) after each pointer
+	// - a POST to /pointerscans/<id>/rescan keeps the chains that lead to the new Woody-Address of the value
+	// - a GET to /pointerscans lists the jobs and /pointerscans/<id> has the progress and a page of chains from
+	//   Woody-Offset (up to Woody-Limit of them)
+	// - a DELETE to /pointerscans/<id> stops the job and forgets it
+	// - jobs run in the background so the POST responses only say that they started
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/pointerscans"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case id == "" && httpRequest.Method == http.MethodPost:
+		startPointerScan(httpResponseWriter, params)
+		return
+	case id == "":
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"pointerScans": pointerScans.List()})
+		return
+	}
+	job, err := pointerScans.job(id)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	switch {
+	case action == "rescan" && httpRequest.Method == http.MethodPost:
+		rescanPointers(httpResponseWriter, params, job)
+	case action == "" && httpRequest.Method == http.MethodDelete:
+		err = pointerScans.Delete(id)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 404, errMessage)
+			return
+		}
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"id": job.id, "deleted": true})
+	case action == "" && httpRequest.Method == http.MethodGet:
+		offset, limit, err := parseScanPage(params)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		result := job.toResult()
+		result["offset"] = offset
+		result["results"] = job.page(offset, limit)
+		sendHTTPJSON(httpResponseWriter, 200, result)
+	default:
+		errMessage := fmt.Sprintf("unsupported pointer scan request %v %v. Use POST /pointerscans/<id>/rescan, GET or DELETE /pointerscans/<id>", httpRequest.Method, httpRequest.URL.Path)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+	}
+}
+
+func startPointerScan(httpResponseWriter http.ResponseWriter, params map[string]string) {
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	job, err := parsePointerScan(params, pc)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	err = pointerScans.Start(pc, game, job)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+	sendHTTPJSON(httpResponseWriter, 202, job.toResult())
+}
+
+func rescanPointers(httpResponseWriter http.ResponseWriter, params map[string]string, job *pointerScan) {
+	addressString, found := params["woodyaddress"]
+	if !found {
+		errMessage := "no address found in the parameters"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	targetAddress, err := parseInt(addressString, 32)
+	if err != nil {
+		errMessage := "unable to parse address " + addressString
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, job.target)
+	if !found {
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+	if game.id != job.gameID || game.gameVersion != job.gameVersion {
+		errMessage := fmt.Sprintf("pointer scan %v was started with game \"%v\" but \"%v\" is running", job.id, job.gameID, game.id)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+
+	err = pointerScans.Rescan(pc, job, uint32(targetAddress))
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+	sendHTTPJSON(httpResponseWriter, 202, job.toResult())
+}
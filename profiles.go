@@ -0,0 +1,467 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a profile gives friendly names to the addresses of a game (e.g. "lives" instead of 0x35459C) so that clients don't
+// need to know any addresses. Profiles are JSON files in the profiles directory and the one for the running game is
+// picked automatically.
+type Profile struct {
+	// defaults to the file name without .json
+	Name string `json:"name"`
+	// the games the profile is for. A game without a gameVersion matches every version of that game ID
+	Games     []ProfileGame              `json:"games"`
+	Variables map[string]ProfileVariable `json:"variables"`
+}
+
+type ProfileGame struct {
+	// the ID from PineIDRequest (e.g. "SLUS-20312" or "BCUS98137")
+	ID string `json:"id"`
+	// the version from PineGameVersionRequest (e.g. "1.00")
+	GameVersion string `json:"gameVersion"`
+}
+
+type ProfileVariable struct {
+	// hex (e.g. "0x35459C"), binary or decimal, or a pointer expression (e.g. "[[0x3A1000]+0x20]+0x4")
+	Address string `json:"address"`
+	// finds the address with an array-of-bytes signature (e.g. "3C 02 ?? ?? 8C 42") instead, which works for every
+	// region and revision of a game that has the same bytes. The address is where the signature is plus the offset
+	Signature string `json:"signature"`
+	// hex, binary or decimal and can be negative (e.g. "-0x10")
+	Offset string `json:"offset"`
+	// the region to search for the signature in (all of PS2 EE RAM by default, which only works for PCSX2)
+	SearchAddress string `json:"searchAddress"`
+	SearchLength  string `json:"searchLength"`
+	// one of the memoryTypes (defaults to u32)
+	Type string `json:"type"`
+	// writes outside of min and max are rejected
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+
+	// filled in when the profile is loaded
+	address    *addressExpression
+	offset     int64
+	memoryType MemoryType
+}
+
+// what is running in an emulator (everything is empty when no game is running)
+type gameInfo struct {
+	id          string
+	gameVersion string
+	// PCSX2 gives the CRC of the game's ELF (e.g. "8a1a5e10")
+	uuid string
+}
+
+// loads every .json file in the directory. A missing directory means there aren't any profiles unless required is set
+func loadProfiles(directory string, required bool) ([]*Profile, error) {
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the profiles directory \"%v\": %w", directory, err)
+	}
+	var profiles []*Profile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".json") {
+			continue
+		}
+		path := filepath.Join(directory, entry.Name())
+		profile, err := loadProfile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not load profile \"%v\": %w", path, err)
+		}
+		for _, other := range profiles {
+			if other.Name == profile.Name {
+				return nil, fmt.Errorf("profile name \"%v\" is used by more than one profile", profile.Name)
+			}
+		}
+		logger.Info("loaded profile", "name", profile.Name, "games", len(profile.Games), "variables", len(profile.Variables))
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+func loadProfile(path string) (*Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	profile := &Profile{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(profile)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Name == "" {
+		profile.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(profile.Games) == 0 {
+		return nil, errors.New("no games listed")
+	}
+	for _, game := range profile.Games {
+		if game.ID == "" {
+			return nil, errors.New("empty game id in games")
+		}
+	}
+	for name, variable := range profile.Variables {
+		err = variable.parseLocation()
+		if err != nil {
+			return nil, fmt.Errorf("%w for variable %v", err, name)
+		}
+		if variable.Type == "" {
+			variable.Type = "u32"
+		}
+		variable.memoryType, err = parseMemoryType(variable.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%w for variable %v", err, name)
+		}
+		if variable.Min != nil && variable.Max != nil && *variable.Min > *variable.Max {
+			return nil, fmt.Errorf("min is bigger than max for variable %v", name)
+		}
+		profile.Variables[name] = variable
+	}
+	return profile, nil
+}
+
+// checks that the variable has either an address or a signature
+func (variable *ProfileVariable) parseLocation() error {
+	if (variable.Address == "") == (variable.Signature == "") {
+		return errors.New("needs either an address or a signature")
+	}
+	if variable.Signature == "" {
+		address, err := parseAddressExpression(variable.Address)
+		if err != nil {
+			return err
+		}
+		variable.address = address
+		return nil
+	}
+	_, err := parseBytePattern(variable.Signature)
+	if err != nil {
+		return err
+	}
+	if variable.Offset != "" {
+		variable.offset, err = parseOffset(variable.Offset)
+		if err != nil {
+			return err
+		}
+	}
+	if variable.SearchAddress != "" {
+		_, err = parseInt(variable.SearchAddress, 32)
+		if err != nil {
+			return fmt.Errorf("unable to parse searchAddress \"%v\"", variable.SearchAddress)
+		}
+	}
+	if variable.SearchLength != "" {
+		_, err = parseInt(variable.SearchLength, 32)
+		if err != nil {
+			return fmt.Errorf("unable to parse searchLength \"%v\"", variable.SearchLength)
+		}
+	}
+	return nil
+}
+
+// picks the profile for the game, preferring one made for the exact version of the game
+func profileForGame(profiles []*Profile, game gameInfo) *Profile {
+	if game.id == "" {
+		return nil
+	}
+	var anyVersionProfile *Profile
+	for _, profile := range profiles {
+		for _, profileGame := range profile.Games {
+			if !strings.EqualFold(profileGame.ID, game.id) {
+				continue
+			}
+			if profileGame.GameVersion == game.gameVersion {
+				return profile
+			}
+			if profileGame.GameVersion == "" && anyVersionProfile == nil {
+				anyVersionProfile = profile
+			}
+		}
+	}
+	return anyVersionProfile
+}
+
+// asks the emulator what game is running with a single batch message
+func detectGame(pc *PineConnection) (gameInfo, error) {
+	idAnswer := &PineIDAnswer{}
+	gameVersionAnswer := &PineGameVersionAnswer{}
+	batchRequest := PineBatchRequest{requests: []PineRequest{PineIDRequest{}, PineGameVersionRequest{}}}
+	batchAnswer := &PineBatchAnswer{answers: []PineAnswer{idAnswer, gameVersionAnswer}}
+	requestBytes, err := batchRequest.toBytes()
+	if err != nil {
+		return gameInfo{}, err
+	}
+	answerBytes, err := pc.Send(requestBytes)
+	if err != nil {
+		return gameInfo{}, err
+	}
+	err = batchAnswer.fromBytes(answerBytes)
+	if err != nil {
+		return gameInfo{}, err
+	}
+	if batchAnswer.resultCode != 0 {
+		// the emulator fails these when no game is running
+		return gameInfo{}, nil
+	}
+	game := gameInfo{id: idAnswer.id, gameVersion: gameVersionAnswer.gameVersion}
+
+	// sent on its own since not every emulator has a UUID for the game
+	uuidAnswer := &PineUUIDAnswer{}
+	err = pc.SendRequest(PineUUIDRequest{}, uuidAnswer)
+	if err != nil {
+		return gameInfo{}, err
+	}
+	if uuidAnswer.resultCode == 0 {
+		game.uuid = uuidAnswer.uuid
+	}
+	return game, nil
+}
+
+func handleVarsHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling vars HTTP request")
+	// for variables:
+	// - /vars lists the variables in the profile for the running game
+	// - /vars/<name> reads the variable or, when Woody-Data is given, writes it
+	// - the game is checked before every request so a variable is never used with a different game than its profile
+	// - Woody-Profile can be set to the name of the profile the client expects, in which case requests are rejected
+	//   with a 409 (Conflict) HTTP response code when a different game is running
+	// - writes outside of the min and max of the variable are rejected
+	// - Woody-Operation changes the variable like /modify does, with results saturating at the min and max
+	// - a variable with a signature is found by searching for it the first time it's used in a game
+	// - a variable with a pointer expression follows the pointers every time it's used
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/vars"), "/")
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	profile := supervisor.GameDetected(pc, game)
+	expectedProfile := params["woodyprofile"]
+	if profile == nil {
+		errMessage := fmt.Sprintf("no profile for the running game (id \"%v\", version \"%v\")", game.id, game.gameVersion)
+		statusCode := 404
+		if expectedProfile != "" {
+			statusCode = 409
+		}
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, statusCode, errMessage)
+		return
+	}
+	if expectedProfile != "" && expectedProfile != profile.Name {
+		errMessage := fmt.Sprintf("the request is for profile %v but the running game (id \"%v\") uses profile %v", expectedProfile, game.id, profile.Name)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+
+	if name == "" {
+		variables := make(map[string]any)
+		for variableName, variable := range profile.Variables {
+			variables[variableName] = variable.toResult()
+		}
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{
+			"profile":     profile.Name,
+			"id":          game.id,
+			"gameVersion": game.gameVersion,
+			"variables":   variables,
+		})
+		return
+	}
+	variable, found := profile.Variables[name]
+	if !found {
+		errMessage := fmt.Sprintf("no variable %v in profile %v. Known variables are %v", name, profile.Name, profile.variableNames())
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+
+	address, found := resolveVariableAddress(httpResponseWriter, pc, game, name, variable)
+	if !found {
+		return
+	}
+	if _, found := params["woodyoperation"]; found {
+		modifyOperation, err := parseModifyOperation(params, variable.memoryType)
+		if err != nil {
+			errMessage := err.Error() + " for variable " + name
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		modifyOperation.limit(variable.Min, variable.Max)
+		extra := map[string]any{"variable": name, "profile": profile.Name}
+		if variable.Signature != "" {
+			extra["address"] = fmt.Sprintf("0x%X", address.base)
+		}
+		sendModifyResult(httpResponseWriter, pc, address, modifyOperation, extra)
+		return
+	}
+
+	pineRequestType := "read"
+	pineRequestParams := map[string]string{
+		"woodyaddress": variable.Address,
+		"woodytype":    variable.memoryType.name,
+	}
+	if dataString, found := params["woodydata"]; found {
+		pineRequestType = "write"
+		pineRequestParams["woodydata"] = dataString
+		err = variable.checkBounds(dataString)
+		if err != nil {
+			errMessage := err.Error() + " for variable " + name
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+	}
+	extra := map[string]any{"variable": name, "profile": profile.Name}
+	if address.isPointer() {
+		handlePointerRequest(httpResponseWriter, pc, pineRequestType, pineRequestParams, extra)
+		return
+	}
+	constantAddress, _ := address.constant()
+	pineRequestParams["woodyaddress"] = fmt.Sprint(constantAddress)
+	operation, err := newPineOperation(pineRequestType, pineRequestParams)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	err = pc.SendRequest(operation.request, operation.answer)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while sending the PINE request for variable "+name, err)
+		return
+	}
+	resultCode, result := operation.result()
+	for key, value := range extra {
+		result[key] = value
+	}
+	if variable.Signature != "" {
+		result["address"] = fmt.Sprintf("0x%X", constantAddress)
+	}
+	sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), result)
+}
+
+// the address of the variable in the running game, searching for its signature (or using the cached matches) when
+// it has one. The signature has to match exactly one place
+func resolveVariableAddress(httpResponseWriter http.ResponseWriter, pc *PineConnection, game gameInfo, name string, variable ProfileVariable) (*addressExpression, bool) {
+	if variable.Signature == "" {
+		return variable.address, true
+	}
+	search, err := parseSignatureSearch(variable.Signature, variable.SearchAddress, variable.SearchLength, "", pc)
+	if err != nil {
+		errMessage := err.Error() + " for variable " + name
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return nil, false
+	}
+	matches, _, err := signatures.Find(pc, game, search, true)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while searching for the signature of variable "+name, err)
+		return nil, false
+	}
+	if len(matches.addresses) != 1 {
+		err = ErrSignatureNotFound
+		statusCode := 404
+		if len(matches.addresses) > 1 {
+			err = fmt.Errorf("%w (%v matches)", ErrSignatureAmbiguous, len(matches.addresses))
+			statusCode = 409
+		}
+		errMessage := err.Error() + " for variable " + name
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, statusCode, errMessage)
+		return nil, false
+	}
+	address := int64(matches.addresses[0]) + variable.offset
+	if address < 0 || address > math.MaxUint32 {
+		errMessage := fmt.Sprintf("the offset of variable %v goes outside of the address space", name)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return nil, false
+	}
+	return &addressExpression{base: uint32(address)}, true
+}
+
+func (profile *Profile) variableNames() []string {
+	var names []string
+	for name := range profile.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (variable ProfileVariable) toResult() map[string]any {
+	result := map[string]any{
+		"type": variable.memoryType.name,
+	}
+	if variable.Signature == "" {
+		result["address"] = variable.Address
+		if address, isConstant := variable.address.constant(); isConstant {
+			result["address"] = fmt.Sprintf("0x%X", address)
+		}
+	} else {
+		result["signature"] = variable.Signature
+		result["offset"] = variable.offset
+	}
+	if variable.Min != nil {
+		result["min"] = *variable.Min
+	}
+	if variable.Max != nil {
+		result["max"] = *variable.Max
+	}
+	return result
+}
+
+// makes sure the value that would be written is between min and max
+func (variable ProfileVariable) checkBounds(dataString string) error {
+	raw, err := variable.memoryType.encode(dataString)
+	if err != nil {
+		return errors.New("unable to parse data " + dataString)
+	}
+	value, isNumber := variable.memoryType.number(raw)
+	if !isNumber {
+		// NaN and the infinities
+		if variable.Min != nil || variable.Max != nil {
+			return errors.New(dataString + " can't be compared with the min and max")
+		}
+		return nil
+	}
+	if variable.Min != nil && value < *variable.Min {
+		return fmt.Errorf("%v is less than the min of %v", dataString, *variable.Min)
+	}
+	if variable.Max != nil && value > *variable.Max {
+		return fmt.Errorf("%v is more than the max of %v", dataString, *variable.Max)
+	}
+	return nil
+}
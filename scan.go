@@ -0,0 +1,911 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/bits"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scan sessions find the address of a value (e.g. the number of lives) the way Cheat Engine does: a first scan over a
+// region of memory finds every candidate and each next scan narrows the candidates down (e.g. to the ones that
+// decreased after losing a life). A session is saved in the scans directory as three files:
+// - <id>.json with the settings and counts
+// - <id>.memory.gz with the bytes of the region from the last scan (for comparing with the next scan)
+// - <id>.candidates.gz with a bit for every value in the region that is still a candidate
+// Scans stream through the region one window at a time so a session never has to fit in memory.
+
+// the bytes of the region handled at a time. A multiple of 64 so the candidates of every window fill whole bytes of
+// the bitmap for every alignment, with room left in a memory chunk for a value that goes past the end of the window
+const scanWindowSize = memoryChunkSize - 64
+
+// PS2 EE RAM, which is what gets scanned by default for PCSX2
+const defaultScanLength = 32 * 1024 * 1024
+
+const maxScanSessions = 16
+const defaultScanPageSize = 100
+const maxScanPageSize = 1000
+
+var firstScanNames = []string{"exact", "range", "unknown"}
+var nextScanNames = []string{"exact", "range", "changed", "unchanged", "increased", "decreased"}
+
+var ErrScanNotFound = errors.New("scan not found")
+var ErrTooManyScans = fmt.Errorf("there can't be more than %v scans. Delete one first", maxScanSessions)
+var ErrScanRead = errors.New("error while reading memory for the scan")
+
+type ScanManager struct {
+	lock      sync.Mutex
+	directory string
+	sessions  map[int]*scanSession
+	nextID    int
+}
+
+type scanSession struct {
+	// held while the files are being read or replaced
+	busy sync.Mutex
+
+	ID int `json:"id"`
+	// the concrete connection name (e.g. "pcsx2:28011") and game that the scan was started with
+	Target      string `json:"target"`
+	GameID      string `json:"gameId"`
+	GameVersion string `json:"gameVersion"`
+	Type        string `json:"type"`
+	Address     uint32 `json:"address"`
+	Length      int    `json:"length"`
+	// the distance in bytes between the values that are scanned
+	Alignment       int       `json:"alignment"`
+	Scans           int       `json:"scans"`
+	LastScan        string    `json:"lastScan"`
+	Candidates      int       `json:"candidates"`
+	UnreadableBytes int       `json:"unreadableBytes"`
+	Created         time.Time `json:"created"`
+	Updated         time.Time `json:"updated"`
+
+	memoryType MemoryType
+}
+
+// what a scan keeps
+type scanComparison struct {
+	name       string
+	memoryType MemoryType
+	// for exact
+	value uint64
+	// for range
+	min uint64
+	max uint64
+}
+
+// loads the sessions saved in the directory. A missing directory just means there aren't any yet
+func NewScanManager(directory string) (*ScanManager, error) {
+	manager := &ScanManager{directory: directory, sessions: make(map[int]*scanSession), nextID: 1}
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manager, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the scans directory \"%v\": %w", directory, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		session, err := manager.load(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			// a broken scan shouldn't stop Woody from starting since it can always be scanned again
+			logger.Warn("skipping scan", "file", entry.Name(), "err", err)
+			continue
+		}
+		manager.sessions[session.ID] = session
+		manager.nextID = max(manager.nextID, session.ID+1)
+	}
+	if len(manager.sessions) > 0 {
+		logger.Info("loaded scans", "scans", len(manager.sessions))
+	}
+	return manager, nil
+}
+
+func (manager *ScanManager) load(path string) (*scanSession, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	session := &scanSession{}
+	err = json.Unmarshal(bytes, session)
+	if err != nil {
+		return nil, err
+	}
+	session.memoryType, err = parseMemoryType(session.Type)
+	if err != nil {
+		return nil, err
+	}
+	if session.Alignment <= 0 || session.Length < session.width() {
+		return nil, errors.New("invalid alignment or length")
+	}
+	for _, dataPath := range []string{manager.memoryPath(session.ID), manager.candidatesPath(session.ID)} {
+		_, err = os.Stat(dataPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+func (manager *ScanManager) memoryPath(id int) string {
+	return filepath.Join(manager.directory, strconv.Itoa(id)+".memory.gz")
+}
+
+func (manager *ScanManager) candidatesPath(id int) string {
+	return filepath.Join(manager.directory, strconv.Itoa(id)+".candidates.gz")
+}
+
+func (manager *ScanManager) session(id string) (*scanSession, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	number, err := strconv.Atoi(id)
+	session, found := manager.sessions[number]
+	if err != nil || !found {
+		return nil, fmt.Errorf("%w with id %v", ErrScanNotFound, id)
+	}
+	return session, nil
+}
+
+func (manager *ScanManager) List() []map[string]any {
+	manager.lock.Lock()
+	sessions := make([]*scanSession, 0, len(manager.sessions))
+	for _, session := range manager.sessions {
+		sessions = append(sessions, session)
+	}
+	manager.lock.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	results := make([]map[string]any, len(sessions))
+	for i, session := range sessions {
+		session.busy.Lock()
+		results[i] = session.toResult()
+		session.busy.Unlock()
+	}
+	return results
+}
+
+// runs the first scan and saves the session
+func (manager *ScanManager) Start(pc *PineConnection, game gameInfo, session *scanSession, comparison *scanComparison) error {
+	manager.lock.Lock()
+	if len(manager.sessions) >= maxScanSessions {
+		manager.lock.Unlock()
+		return ErrTooManyScans
+	}
+	session.ID = manager.nextID
+	manager.nextID++
+	manager.lock.Unlock()
+
+	session.Target = pc.name()
+	session.GameID = game.id
+	session.GameVersion = game.gameVersion
+	session.Created = time.Now()
+	err := manager.scan(pc, session, comparison, true)
+	if err != nil {
+		return err
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.sessions[session.ID] = session
+	return nil
+}
+
+// runs a scan that narrows down the candidates of the session
+func (manager *ScanManager) Next(pc *PineConnection, session *scanSession, comparison *scanComparison) error {
+	session.busy.Lock()
+	defer session.busy.Unlock()
+
+	return manager.scan(pc, session, comparison, false)
+}
+
+func (manager *ScanManager) Delete(id string) error {
+	session, err := manager.session(id)
+	if err != nil {
+		return err
+	}
+	session.busy.Lock()
+	defer session.busy.Unlock()
+
+	manager.lock.Lock()
+	delete(manager.sessions, session.ID)
+	manager.lock.Unlock()
+	for _, path := range []string{manager.memoryPath(session.ID), manager.candidatesPath(session.ID), manager.sessionPath(session.ID)} {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("unable to remove scan file", "path", path, "err", err)
+		}
+	}
+	return nil
+}
+
+func (manager *ScanManager) sessionPath(id int) string {
+	return filepath.Join(manager.directory, strconv.Itoa(id)+".json")
+}
+
+// streams through the region one window at a time, reading the windows that still have candidates from the emulator
+// and writing the new memory and candidates files next to the old ones. The old files are only replaced once the
+// whole region has been scanned, so a scan that fails part way leaves the session as it was
+func (manager *ScanManager) scan(pc *PineConnection, session *scanSession, comparison *scanComparison, first bool) error {
+	err := os.MkdirAll(manager.directory, 0o755)
+	if err != nil {
+		return fmt.Errorf("could not create the scans directory \"%v\": %w", manager.directory, err)
+	}
+	memoryPath := manager.memoryPath(session.ID)
+	candidatesPath := manager.candidatesPath(session.ID)
+
+	var oldMemory, oldCandidates *scanFileReader
+	if !first {
+		oldMemory, err = openScanFile(memoryPath)
+		if err != nil {
+			return err
+		}
+		defer oldMemory.Close()
+		oldCandidates, err = openScanFile(candidatesPath)
+		if err != nil {
+			return err
+		}
+		defer oldCandidates.Close()
+	}
+
+	newMemory, err := newScanFileWriter(memoryPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer newMemory.discard()
+	newCandidates, err := newScanFileWriter(candidatesPath + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer newCandidates.discard()
+
+	byteOrder := memoryByteOrder(pc)
+	width := session.width()
+	alignment := session.Alignment
+	count := session.valueCount()
+	candidates := 0
+	unreadableBytes := 0
+	for windowStart := 0; windowStart < session.Length; windowStart += scanWindowSize {
+		// the window's own bytes and the bytes after them that the last values in the window go into
+		ownLength := min(scanWindowSize, session.Length-windowStart)
+		windowLength := min(scanWindowSize+width-1, session.Length-windowStart)
+		firstIndex := windowStart / alignment
+		windowCount := max(min(firstIndex+scanWindowSize/alignment, count)-firstIndex, 0)
+		bitmap := make([]byte, (windowCount+7)/8)
+
+		var previous []byte
+		oldBitmap := make([]byte, len(bitmap))
+		anyCandidates := first
+		if first {
+			for i := range oldBitmap {
+				oldBitmap[i] = 0xFF
+			}
+		} else {
+			previous, err = oldMemory.reader.Peek(windowLength)
+			if err != nil {
+				return fmt.Errorf("could not read the memory from the last scan: %w", err)
+			}
+			// the bytes are only valid until the next read
+			previous = append([]byte(nil), previous...)
+			_, err = oldMemory.reader.Discard(ownLength)
+			if err == nil {
+				_, err = io.ReadFull(oldCandidates.reader, oldBitmap)
+			}
+			if err != nil {
+				return fmt.Errorf("could not read the candidates from the last scan: %w", err)
+			}
+			for _, candidateBits := range oldBitmap {
+				anyCandidates = anyCandidates || candidateBits != 0
+			}
+		}
+
+		current := previous
+		if anyCandidates {
+			bytes, resultCode, err := readMemoryChunk(pc, session.Address+uint32(windowStart), windowLength)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrScanRead, err)
+			}
+			if resultCode == 0 {
+				current = bytes
+				for i := 0; i < windowCount; i++ {
+					if oldBitmap[i/8]&(1<<(i%8)) == 0 {
+						continue
+					}
+					offset := i * alignment
+					value := rawScanValue(current[offset:offset+width], byteOrder)
+					var previousValue uint64
+					if previous != nil {
+						previousValue = rawScanValue(previous[offset:offset+width], byteOrder)
+					}
+					if comparison.matches(value, previousValue) {
+						bitmap[i/8] |= 1 << (i % 8)
+						candidates++
+					}
+				}
+			} else {
+				// the candidates in a window that can't be read are dropped since there's no value to compare
+				unreadableBytes += ownLength
+			}
+		}
+		if current == nil {
+			current = make([]byte, windowLength)
+		}
+		_, err = newMemory.Write(current[:ownLength])
+		if err == nil {
+			_, err = newCandidates.Write(bitmap)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, writer := range []*scanFileWriter{newMemory, newCandidates} {
+		err = writer.finish()
+		if err != nil {
+			return err
+		}
+	}
+	if !first {
+		// on Windows the old files can't be replaced while they're open
+		oldMemory.Close()
+		oldCandidates.Close()
+	}
+	err = os.Rename(memoryPath+".tmp", memoryPath)
+	if err == nil {
+		err = os.Rename(candidatesPath+".tmp", candidatesPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	session.Scans++
+	session.LastScan = comparison.name
+	session.Candidates = candidates
+	session.UnreadableBytes = unreadableBytes
+	session.Updated = time.Now()
+	return manager.save(session)
+}
+
+func (manager *ScanManager) save(session *scanSession) error {
+	bytes, err := json.MarshalIndent(session, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := manager.sessionPath(session.ID)
+	err = os.WriteFile(path+".tmp", bytes, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// the candidates from offset on (up to limit of them) with their values from the last scan
+func (manager *ScanManager) Page(session *scanSession, offset int, limit int) ([]map[string]any, error) {
+	session.busy.Lock()
+	defer session.busy.Unlock()
+
+	memory, err := openScanFile(manager.memoryPath(session.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer memory.Close()
+	candidates, err := openScanFile(manager.candidatesPath(session.ID))
+	if err != nil {
+		return nil, err
+	}
+	defer candidates.Close()
+
+	results := []map[string]any{}
+	width := session.width()
+	count := session.valueCount()
+	skipped := 0
+	for windowStart := 0; windowStart < session.Length && len(results) < limit; windowStart += scanWindowSize {
+		ownLength := min(scanWindowSize, session.Length-windowStart)
+		windowLength := min(scanWindowSize+width-1, session.Length-windowStart)
+		firstIndex := windowStart / session.Alignment
+		windowCount := max(min(firstIndex+scanWindowSize/session.Alignment, count)-firstIndex, 0)
+		bitmap := make([]byte, (windowCount+7)/8)
+		_, err = io.ReadFull(candidates.reader, bitmap)
+		if err != nil {
+			return nil, err
+		}
+		windowCandidates := 0
+		for _, candidateBits := range bitmap {
+			windowCandidates += bits.OnesCount8(candidateBits)
+		}
+		if skipped+windowCandidates <= offset {
+			// nothing to show from this window so skip the memory without looking at it
+			skipped += windowCandidates
+			_, err = memory.reader.Discard(ownLength)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		window, err := memory.reader.Peek(windowLength)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < windowCount && len(results) < limit; i++ {
+			if bitmap[i/8]&(1<<(i%8)) == 0 {
+				continue
+			}
+			if skipped < offset {
+				skipped++
+				continue
+			}
+			valueOffset := i * session.Alignment
+			value := rawScanValue(window[valueOffset:valueOffset+width], session.byteOrder())
+			results = append(results, map[string]any{
+				"address": fmt.Sprintf("0x%X", session.Address+uint32(windowStart+valueOffset)),
+				"value":   session.memoryType.decode(value),
+			})
+		}
+		_, err = memory.reader.Discard(ownLength)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// in bytes
+func (session *scanSession) width() int {
+	return max(session.memoryType.width/8, 1)
+}
+
+// the number of values in the region (the last one has to fit inside it)
+func (session *scanSession) valueCount() int {
+	return (session.Length-session.width())/session.Alignment + 1
+}
+
+// the byte order that the values were put into the memory file with (see memoryByteOrder)
+func (session *scanSession) byteOrder() binary.ByteOrder {
+	return targetByteOrder(session.Target)
+}
+
+func (session *scanSession) toResult() map[string]any {
+	return map[string]any{
+		"id":              session.ID,
+		"target":          session.Target,
+		"gameId":          session.GameID,
+		"gameVersion":     session.GameVersion,
+		"type":            session.Type,
+		"address":         fmt.Sprintf("0x%X", session.Address),
+		"length":          session.Length,
+		"alignment":       session.Alignment,
+		"scans":           session.Scans,
+		"lastScan":        session.LastScan,
+		"candidates":      session.Candidates,
+		"unreadableBytes": session.UnreadableBytes,
+		"created":         session.Created.Format(time.RFC3339Nano),
+		"updated":         session.Updated.Format(time.RFC3339Nano),
+	}
+}
+
+func rawScanValue(bytes []byte, byteOrder binary.ByteOrder) uint64 {
+	switch len(bytes) {
+	case 8:
+		return byteOrder.Uint64(bytes)
+	case 4:
+		return uint64(byteOrder.Uint32(bytes))
+	case 2:
+		return uint64(byteOrder.Uint16(bytes))
+	default:
+		return uint64(bytes[0])
+	}
+}
+
+// whether the value is still a candidate. previous is the value from the last scan (zero for the first scan)
+func (comparison *scanComparison) matches(value uint64, previous uint64) bool {
+	switch comparison.name {
+	case "exact":
+		order, comparable := comparison.memoryType.compare(value, comparison.value)
+		return comparable && order == 0
+	case "range":
+		low, lowComparable := comparison.memoryType.compare(value, comparison.min)
+		high, highComparable := comparison.memoryType.compare(value, comparison.max)
+		return lowComparable && highComparable && low >= 0 && high <= 0
+	case "changed":
+		return !comparison.memoryType.same(value, previous)
+	case "unchanged":
+		return comparison.memoryType.same(value, previous)
+	case "increased":
+		order, comparable := comparison.memoryType.compare(value, previous)
+		return comparable && order > 0
+	case "decreased":
+		order, comparable := comparison.memoryType.compare(value, previous)
+		return comparable && order < 0
+	default:
+		// unknown
+		return true
+	}
+}
+
+// -1, 0 or 1 like cmp.Compare for the decoded values. NaN can't be compared with anything
+func (memoryType MemoryType) compare(a uint64, b uint64) (int, bool) {
+	switch memoryType.kind {
+	case signedKind:
+		return cmp.Compare(memoryType.decode(a).(int64), memoryType.decode(b).(int64)), true
+	case floatKind:
+		aFloat, bFloat := memoryType.float(a), memoryType.float(b)
+		if math.IsNaN(aFloat) || math.IsNaN(bFloat) {
+			return 0, false
+		}
+		return cmp.Compare(aFloat, bFloat), true
+	case boolKind:
+		return cmp.Compare(min(a&memoryType.mask(), 1), min(b&memoryType.mask(), 1)), true
+	default:
+		return cmp.Compare(a&memoryType.mask(), b&memoryType.mask()), true
+	}
+}
+
+// whether the values are the same bits (so a NaN that stays the same is unchanged)
+func (memoryType MemoryType) same(a uint64, b uint64) bool {
+	if memoryType.kind == boolKind {
+		return (a&memoryType.mask() != 0) == (b&memoryType.mask() != 0)
+	}
+	return a&memoryType.mask() == b&memoryType.mask()
+}
+
+func (memoryType MemoryType) float(raw uint64) float64 {
+	if memoryType.width == 32 {
+		return float64(math.Float32frombits(uint32(raw)))
+	}
+	return math.Float64frombits(raw)
+}
+
+// a gzip file that's written to a temporary path and removed unless it's renamed into place
+type scanFileWriter struct {
+	path   string
+	file   *os.File
+	gzip   *gzip.Writer
+	closed bool
+}
+
+func newScanFileWriter(path string) (*scanFileWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	// the memory of a game compresses well even at the fastest level
+	gzipWriter, _ := gzip.NewWriterLevel(file, gzip.BestSpeed)
+	return &scanFileWriter{path: path, file: file, gzip: gzipWriter}, nil
+}
+
+func (writer *scanFileWriter) Write(bytes []byte) (int, error) {
+	return writer.gzip.Write(bytes)
+}
+
+func (writer *scanFileWriter) finish() error {
+	writer.closed = true
+	err := writer.gzip.Close()
+	if err != nil {
+		writer.file.Close()
+		return err
+	}
+	return writer.file.Close()
+}
+
+// cleans up when the scan failed before finish
+func (writer *scanFileWriter) discard() {
+	if !writer.closed {
+		writer.file.Close()
+	}
+	os.Remove(writer.path)
+}
+
+type scanFileReader struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+func openScanFile(path string) (*scanFileReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &scanFileReader{file: file, reader: bufio.NewReaderSize(gzipReader, memoryChunkSize)}, nil
+}
+
+func (reader *scanFileReader) Close() error {
+	return reader.file.Close()
+}
+
+func parseScanComparison(params map[string]string, memoryType MemoryType, first bool) (*scanComparison, error) {
+	names := nextScanNames
+	if first {
+		names = firstScanNames
+	}
+	comparison := &scanComparison{name: strings.ToLower(params["woodyscan"]), memoryType: memoryType}
+	known := false
+	for _, name := range names {
+		known = known || comparison.name == name
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown scan \"%v\". Supported values are %v", params["woodyscan"], strings.Join(names, ", "))
+	}
+	var err error
+	switch comparison.name {
+	case "exact":
+		comparison.value, err = parseScanValue(params, "woodydata", memoryType)
+	case "range":
+		comparison.min, err = parseScanValue(params, "woodymin", memoryType)
+		if err == nil {
+			comparison.max, err = parseScanValue(params, "woodymax", memoryType)
+		}
+		order, comparable := memoryType.compare(comparison.min, comparison.max)
+		if err == nil && (!comparable || order > 0) {
+			err = errors.New("min has to be a number that isn't bigger than max")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w for the %v scan", err, comparison.name)
+	}
+	return comparison, nil
+}
+
+func parseScanValue(params map[string]string, key string, memoryType MemoryType) (uint64, error) {
+	literal, found := params[key]
+	if !found {
+		return 0, fmt.Errorf("no %v found in the parameters", strings.TrimPrefix(key, "woody"))
+	}
+	raw, err := memoryType.encode(literal)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %v as %v", literal, memoryType.name)
+	}
+	return raw, nil
+}
+
+// the region and type for a first scan
+func parseScanSession(params map[string]string, pc *PineConnection) (*scanSession, error) {
+	typeName := params["woodytype"]
+	if typeName == "" {
+		typeName = "u32"
+	}
+	memoryType, err := parseMemoryType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	session := &scanSession{Type: memoryType.name, memoryType: memoryType}
+	var address uint64
+	if addressString, found := params["woodyaddress"]; found {
+		address, err = parseInt(addressString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse address " + addressString)
+		}
+	}
+	session.Address = uint32(address)
+	lengthString, found := params["woodylength"]
+	switch {
+	case found:
+		length, err := parseInt(lengthString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse length " + lengthString)
+		}
+		session.Length = int(length)
+	case pc.target == "pcsx2":
+		session.Length = defaultScanLength - int(min(address, defaultScanLength))
+	default:
+		return nil, errors.New("no length found in the parameters (it's only optional for PCSX2)")
+	}
+	err = checkMemoryRange(session.Address, session.Length)
+	if err != nil {
+		return nil, err
+	}
+	if session.Length < session.width() {
+		return nil, fmt.Errorf("the length has to be at least %v bytes for %v", session.width(), memoryType.name)
+	}
+	session.Alignment = session.width()
+	if alignmentString, found := params["woodyalignment"]; found {
+		alignment, err := strconv.Atoi(alignmentString)
+		if err != nil || (alignment != 1 && alignment != 2 && alignment != 4 && alignment != 8) {
+			return nil, errors.New("the alignment has to be 1, 2, 4 or 8")
+		}
+		session.Alignment = alignment
+	}
+	if session.Address%uint32(session.Alignment) != 0 {
+		return nil, fmt.Errorf("the address has to be a multiple of the alignment (%v)", session.Alignment)
+	}
+	return session, nil
+}
+
+func parseScanPage(params map[string]string) (int, int, error) {
+	offset := 0
+	limit := defaultScanPageSize
+	for _, param := range []struct {
+		key    string
+		target *int
+		limit  int
+	}{{"woodyoffset", &offset, math.MaxInt32}, {"woodylimit", &limit, maxScanPageSize}} {
+		literal, found := params[param.key]
+		if !found {
+			continue
+		}
+		value, err := strconv.Atoi(literal)
+		if err != nil || value < 0 || value > param.limit {
+			return 0, 0, fmt.Errorf("%v has to be a number between 0 and %v", param.key, param.limit)
+		}
+		*param.target = value
+	}
+	return offset, limit, nil
+}
+
+func handleScansHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling scans HTTP request")
+	// for scan sessions:
+	// - a POST to /scans starts a session with a first scan (Woody-Scan is exact, range or unknown) of Woody-Length
+	//   bytes from Woody-Address for values of Woody-Type (u32 by default), every Woody-Alignment bytes
+	// - a POST to /scans/<id>/next narrows the candidates down (Woody-Scan is exact, range, changed, unchanged,
+	//   increased or decreased)
+	// - a GET to /scans lists the sessions and /scans/<id> has a page of candidates from Woody-Offset (up to
+	//   Woody-Limit of them) with their values from the last scan
+	// - a DELETE to /scans/<id> deletes the session
+	// - sessions are saved in the scans directory so they're still there after Woody restarts
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/scans"), "/")
+	id, action, _ := strings.Cut(path, "/")
+
+	switch {
+	case id == "" && httpRequest.Method == http.MethodPost:
+		startScan(httpResponseWriter, params)
+		return
+	case id == "":
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"scans": scans.List()})
+		return
+	}
+	session, err := scans.session(id)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	switch {
+	case action == "next" && httpRequest.Method == http.MethodPost:
+		nextScan(httpResponseWriter, params, session)
+	case action == "" && httpRequest.Method == http.MethodDelete:
+		err = scans.Delete(id)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 404, errMessage)
+			return
+		}
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"id": session.ID, "deleted": true})
+	case action == "" && httpRequest.Method == http.MethodGet:
+		offset, limit, err := parseScanPage(params)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		page, err := scans.Page(session, offset, limit)
+		if err != nil {
+			errMessage := "could not read the scan"
+			logger.Error(errMessage, "id", session.ID, "err", err)
+			sendHTTPError(httpResponseWriter, 500, errMessage)
+			return
+		}
+		session.busy.Lock()
+		result := session.toResult()
+		session.busy.Unlock()
+		result["offset"] = offset
+		result["results"] = page
+		sendHTTPJSON(httpResponseWriter, 200, result)
+	default:
+		errMessage := fmt.Sprintf("unsupported scan request %v %v. Use POST /scans/<id>/next, GET or DELETE /scans/<id>", httpRequest.Method, httpRequest.URL.Path)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+	}
+}
+
+func startScan(httpResponseWriter http.ResponseWriter, params map[string]string) {
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	session, err := parseScanSession(params, pc)
+	var comparison *scanComparison
+	if err == nil {
+		comparison, err = parseScanComparison(params, session.memoryType, true)
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	err = scans.Start(pc, game, session, comparison)
+	sendScanResult(httpResponseWriter, pc, session, err)
+}
+
+func nextScan(httpResponseWriter http.ResponseWriter, params map[string]string, session *scanSession) {
+	comparison, err := parseScanComparison(params, session.memoryType, false)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, session.Target)
+	if !found {
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+	if game.id != session.GameID || game.gameVersion != session.GameVersion {
+		errMessage := fmt.Sprintf("scan %v was started with game \"%v\" but \"%v\" is running", session.ID, session.GameID, game.id)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+
+	err = scans.Next(pc, session, comparison)
+	sendScanResult(httpResponseWriter, pc, session, err)
+}
+
+func sendScanResult(httpResponseWriter http.ResponseWriter, pc *PineConnection, session *scanSession, err error) {
+	switch {
+	case errors.Is(err, ErrTooManyScans):
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+	case errors.Is(err, ErrScanRead):
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while scanning", err)
+	case err != nil:
+		errMessage := "could not read or write the scan files"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 500, errMessage)
+	default:
+		session.busy.Lock()
+		result := session.toResult()
+		session.busy.Unlock()
+		sendHTTPJSON(httpResponseWriter, 200, result)
+	}
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshots keep the bytes of a region of memory (e.g. an inventory) so they can be compared with other snapshots or
+// written back into the emulator later, which brings back part of the game without loading a whole savestate. A
+// snapshot is saved in the snapshots directory as two files:
+// - <name>.json with the region and the game and time it was taken at
+// - <name>.memory.gz with the bytes of the region
+// Snapshots are streamed to and from the files one chunk at a time so they never have to fit in memory.
+
+const maxSnapshots = 64
+const maxSnapshotNameLength = 64
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+var ErrSnapshotExists = errors.New("there is already a snapshot with that name. Delete it first")
+var ErrTooManySnapshots = fmt.Errorf("there can't be more than %v snapshots. Delete one first", maxSnapshots)
+var ErrSnapshotEmulator = errors.New("error while sending the PINE requests for the snapshot")
+var ErrSnapshotsDontOverlap = errors.New("the snapshots don't have any addresses in common")
+
+type SnapshotManager struct {
+	lock      sync.Mutex
+	directory string
+	snapshots map[string]*snapshot
+	// the names of the snapshots that are being taken
+	taking map[string]bool
+}
+
+type snapshot struct {
+	// held while the memory file is read, and exclusively while it's deleted
+	busy sync.RWMutex
+
+	Name string `json:"name"`
+	// the concrete connection name (e.g. "pcsx2:28011") and game that the snapshot was taken from
+	Target      string    `json:"target"`
+	GameID      string    `json:"gameId"`
+	GameVersion string    `json:"gameVersion"`
+	Address     uint32    `json:"address"`
+	Length      int       `json:"length"`
+	Created     time.Time `json:"created"`
+}
+
+// part of the region of a snapshot
+type snapshotRange struct {
+	address uint32
+	length  int
+}
+
+type snapshotDiff struct {
+	address uint32
+	length  int
+	changes int
+	results []map[string]any
+}
+
+type snapshotRestore struct {
+	ranges       []snapshotRange
+	bytesWritten int
+	// non-zero when a write failed, in which case nothing from failedAddress on was written
+	resultCode    uint8
+	failedAddress uint32
+}
+
+// loads the snapshots saved in the directory. A missing directory just means there aren't any yet
+func NewSnapshotManager(directory string) (*SnapshotManager, error) {
+	manager := &SnapshotManager{directory: directory, snapshots: make(map[string]*snapshot), taking: make(map[string]bool)}
+	entries, err := os.ReadDir(directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manager, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the snapshots directory \"%v\": %w", directory, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		current, err := manager.load(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			// a broken snapshot shouldn't stop Woody from starting
+			logger.Warn("skipping snapshot", "file", entry.Name(), "err", err)
+			continue
+		}
+		manager.snapshots[current.Name] = current
+	}
+	if len(manager.snapshots) > 0 {
+		logger.Info("loaded snapshots", "snapshots", len(manager.snapshots))
+	}
+	return manager, nil
+}
+
+func (manager *SnapshotManager) load(path string) (*snapshot, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	current := &snapshot{}
+	err = json.Unmarshal(bytes, current)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSnapshotName(current.Name)
+	if err == nil && current.Name+".json" != filepath.Base(path) {
+		err = errors.New("the name doesn't match the file name")
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(manager.memoryPath(current.Name))
+	if err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (manager *SnapshotManager) memoryPath(name string) string {
+	return filepath.Join(manager.directory, name+".memory.gz")
+}
+
+func (manager *SnapshotManager) snapshotPath(name string) string {
+	return filepath.Join(manager.directory, name+".json")
+}
+
+// names are used for the file names so they're kept to letters, digits, -, _ and .
+func checkSnapshotName(name string) error {
+	if name == "" || len(name) > maxSnapshotNameLength || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("a snapshot name needs between 1 and %v characters and can't start with a .", maxSnapshotNameLength)
+	}
+	for _, character := range name {
+		if !(character >= 'a' && character <= 'z') && !(character >= 'A' && character <= 'Z') && !(character >= '0' && character <= '9') && !strings.ContainsRune("-_.", character) {
+			return fmt.Errorf("a snapshot name can only have letters, digits, -, _ and . (not \"%c\")", character)
+		}
+	}
+	return nil
+}
+
+func (manager *SnapshotManager) snapshot(name string) (*snapshot, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	current, found := manager.snapshots[name]
+	if !found {
+		return nil, fmt.Errorf("%w with name %v", ErrSnapshotNotFound, name)
+	}
+	return current, nil
+}
+
+func (manager *SnapshotManager) List() []map[string]any {
+	manager.lock.Lock()
+	snapshots := make([]*snapshot, 0, len(manager.snapshots))
+	for _, current := range manager.snapshots {
+		snapshots = append(snapshots, current)
+	}
+	manager.lock.Unlock()
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	results := make([]map[string]any, len(snapshots))
+	for i, current := range snapshots {
+		results[i] = manager.result(current)
+	}
+	return results
+}
+
+// reads the region one chunk at a time (so other requests still get their turn) and saves it. Nothing is saved when
+// a chunk can't be read, in which case the result code and the address of the chunk are returned
+func (manager *SnapshotManager) Take(pc *PineConnection, game gameInfo, current *snapshot) (uint8, uint32, error) {
+	manager.lock.Lock()
+	switch {
+	case manager.snapshots[current.Name] != nil || manager.taking[current.Name]:
+		manager.lock.Unlock()
+		return 0, 0, ErrSnapshotExists
+	case len(manager.snapshots)+len(manager.taking) >= maxSnapshots:
+		manager.lock.Unlock()
+		return 0, 0, ErrTooManySnapshots
+	}
+	manager.taking[current.Name] = true
+	manager.lock.Unlock()
+	defer func() {
+		manager.lock.Lock()
+		delete(manager.taking, current.Name)
+		manager.lock.Unlock()
+	}()
+
+	current.Target = pc.name()
+	current.GameID = game.id
+	current.GameVersion = game.gameVersion
+	current.Created = time.Now()
+
+	err := os.MkdirAll(manager.directory, 0o755)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not create the snapshots directory \"%v\": %w", manager.directory, err)
+	}
+	memoryPath := manager.memoryPath(current.Name)
+	memory, err := newScanFileWriter(memoryPath + ".tmp")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer memory.discard()
+	for offset := 0; offset < current.Length; offset += memoryChunkSize {
+		chunkAddress := current.Address + uint32(offset)
+		chunk, resultCode, err := readMemoryChunk(pc, chunkAddress, min(memoryChunkSize, current.Length-offset))
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %w", ErrSnapshotEmulator, err)
+		}
+		if resultCode != 0 {
+			return resultCode, chunkAddress, nil
+		}
+		_, err = memory.Write(chunk)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	err = memory.finish()
+	if err == nil {
+		err = os.Rename(memoryPath+".tmp", memoryPath)
+	}
+	if err == nil {
+		err = manager.save(current)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	manager.snapshots[current.Name] = current
+	return 0, 0, nil
+}
+
+func (manager *SnapshotManager) save(current *snapshot) error {
+	bytes, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return err
+	}
+	path := manager.snapshotPath(current.Name)
+	err = os.WriteFile(path+".tmp", bytes, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (manager *SnapshotManager) Delete(name string) error {
+	current, err := manager.snapshot(name)
+	if err != nil {
+		return err
+	}
+	current.busy.Lock()
+	defer current.busy.Unlock()
+
+	manager.lock.Lock()
+	delete(manager.snapshots, current.Name)
+	manager.lock.Unlock()
+	for _, path := range []string{manager.memoryPath(current.Name), manager.snapshotPath(current.Name)} {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("unable to remove snapshot file", "path", path, "err", err)
+		}
+	}
+	return nil
+}
+
+// compares the values of the memory type that both snapshots have, returning how many changed and the changes from
+// offset on (up to limit of them). Values are at multiples of their width like the values of a scan
+func (manager *SnapshotManager) Diff(from *snapshot, to *snapshot, memoryType MemoryType, offset int, limit int) (*snapshotDiff, error) {
+	width := max(memoryType.width/8, 1)
+	start := max(from.Address, to.Address)
+	start += (uint32(width) - start%uint32(width)) % uint32(width)
+	end := min(int64(from.Address)+int64(from.Length), int64(to.Address)+int64(to.Length))
+	if int64(start)+int64(width) > end {
+		return nil, ErrSnapshotsDontOverlap
+	}
+	diff := &snapshotDiff{address: start, length: int(end-int64(start)) / width * width, results: []map[string]any{}}
+
+	var readers [2]*scanFileReader
+	for i, current := range []*snapshot{from, to} {
+		if i == 0 || to != from {
+			current.busy.RLock()
+			defer current.busy.RUnlock()
+		}
+		reader, err := openScanFile(manager.memoryPath(current.Name))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		_, err = reader.reader.Discard(int(start - current.Address))
+		if err != nil {
+			return nil, err
+		}
+		readers[i] = reader
+	}
+
+	byteOrder := targetByteOrder(from.Target)
+	oldBytes := make([]byte, memoryChunkSize)
+	newBytes := make([]byte, memoryChunkSize)
+	for chunkOffset := 0; chunkOffset < diff.length; chunkOffset += memoryChunkSize {
+		chunkLength := min(memoryChunkSize, diff.length-chunkOffset)
+		_, err := io.ReadFull(readers[0].reader, oldBytes[:chunkLength])
+		if err == nil {
+			_, err = io.ReadFull(readers[1].reader, newBytes[:chunkLength])
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(oldBytes[:chunkLength], newBytes[:chunkLength]) {
+			continue
+		}
+		for i := 0; i < chunkLength; i += width {
+			oldValue := rawScanValue(oldBytes[i:i+width], byteOrder)
+			newValue := rawScanValue(newBytes[i:i+width], byteOrder)
+			if oldValue == newValue {
+				continue
+			}
+			if diff.changes >= offset && len(diff.results) < limit {
+				diff.results = append(diff.results, map[string]any{
+					"address":  fmt.Sprintf("0x%X", start+uint32(chunkOffset+i)),
+					"oldValue": memoryType.decode(oldValue),
+					"newValue": memoryType.decode(newValue),
+				})
+			}
+			diff.changes++
+		}
+	}
+	return diff, nil
+}
+
+// writes the ranges of the snapshot back one chunk at a time, stopping at the first chunk that fails. The ranges
+// have to be inside the region of the snapshot and no ranges means the whole snapshot
+func (manager *SnapshotManager) Restore(pc *PineConnection, current *snapshot, ranges []snapshotRange) (*snapshotRestore, error) {
+	if len(ranges) == 0 {
+		ranges = []snapshotRange{{address: current.Address, length: current.Length}}
+	}
+	restore := &snapshotRestore{ranges: mergeSnapshotRanges(ranges)}
+
+	current.busy.RLock()
+	defer current.busy.RUnlock()
+	memory, err := openScanFile(manager.memoryPath(current.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer memory.Close()
+
+	position := current.Address
+	chunk := make([]byte, memoryChunkSize)
+	for _, restoreRange := range restore.ranges {
+		_, err = memory.reader.Discard(int(restoreRange.address - position))
+		if err != nil {
+			return nil, err
+		}
+		position = restoreRange.address
+		for offset := 0; offset < restoreRange.length; offset += memoryChunkSize {
+			chunkLength := min(memoryChunkSize, restoreRange.length-offset)
+			_, err = io.ReadFull(memory.reader, chunk[:chunkLength])
+			if err != nil {
+				return nil, err
+			}
+			chunkAddress := restoreRange.address + uint32(offset)
+			resultCode, err := writeMemoryChunk(pc, chunkAddress, chunk[:chunkLength])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrSnapshotEmulator, err)
+			}
+			if resultCode != 0 {
+				restore.resultCode = resultCode
+				restore.failedAddress = chunkAddress
+				return restore, nil
+			}
+			restore.bytesWritten += chunkLength
+			position += uint32(chunkLength)
+		}
+	}
+	return restore, nil
+}
+
+// sorts the ranges and joins the ones that overlap or touch so every byte is written once, in order
+func mergeSnapshotRanges(ranges []snapshotRange) []snapshotRange {
+	sorted := append([]snapshotRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].address < sorted[j].address })
+	merged := []snapshotRange{}
+	for _, current := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			lastEnd := int64(last.address) + int64(last.length)
+			if int64(current.address) <= lastEnd {
+				last.length = int(max(lastEnd, int64(current.address)+int64(current.length)) - int64(last.address))
+				continue
+			}
+		}
+		merged = append(merged, current)
+	}
+	return merged
+}
+
+// the byte order that the bytes of a connection are saved in (see memoryByteOrder)
+func targetByteOrder(target string) binary.ByteOrder {
+	targetType, _, _ := strings.Cut(target, ":")
+	if targetType == "rpcs3" {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func (manager *SnapshotManager) result(current *snapshot) map[string]any {
+	result := map[string]any{
+		"name":        current.Name,
+		"target":      current.Target,
+		"gameId":      current.GameID,
+		"gameVersion": current.GameVersion,
+		"address":     fmt.Sprintf("0x%X", current.Address),
+		"length":      current.Length,
+		"created":     current.Created.Format(time.RFC3339Nano),
+	}
+	info, err := os.Stat(manager.memoryPath(current.Name))
+	if err == nil {
+		result["compressedLength"] = info.Size()
+	}
+	return result
+}
+
+func (restore *snapshotRestore) toResult() map[string]any {
+	ranges := make([]map[string]any, len(restore.ranges))
+	for i, restoreRange := range restore.ranges {
+		ranges[i] = map[string]any{"address": fmt.Sprintf("0x%X", restoreRange.address), "length": restoreRange.length}
+	}
+	result := map[string]any{
+		"resultCode":   restore.resultCode,
+		"ranges":       ranges,
+		"bytesWritten": restore.bytesWritten,
+	}
+	if restore.resultCode != 0 {
+		result["failedAddress"] = fmt.Sprintf("0x%X", restore.failedAddress)
+	}
+	return result
+}
+
+func parseSnapshot(params map[string]string, pc *PineConnection) (*snapshot, error) {
+	current := &snapshot{Name: params["woodyname"]}
+	err := checkSnapshotName(current.Name)
+	if err != nil {
+		return nil, err
+	}
+	var address uint64
+	if addressString, found := params["woodyaddress"]; found {
+		address, err = parseInt(addressString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse address " + addressString)
+		}
+	}
+	current.Address = uint32(address)
+	lengthString, found := params["woodylength"]
+	switch {
+	case found:
+		length, err := parseInt(lengthString, 32)
+		if err != nil {
+			return nil, errors.New("unable to parse length " + lengthString)
+		}
+		current.Length = int(length)
+	case pc.target == "pcsx2":
+		current.Length = defaultScanLength - int(min(address, defaultScanLength))
+	default:
+		return nil, errors.New("no length found in the parameters (it's only optional for PCSX2)")
+	}
+	err = checkMemoryRange(current.Address, current.Length)
+	if err != nil {
+		return nil, err
+	}
+	if current.Length == 0 {
+		return nil, errors.New("the length has to be at least 1 byte")
+	}
+	return current, nil
+}
+
+// parses ranges like "0x3545A0:0x40,0x354600:8" that have to be inside the region of the snapshot
+func parseSnapshotRanges(rangesString string, current *snapshot) ([]snapshotRange, error) {
+	var ranges []snapshotRange
+	for _, rangeString := range strings.Split(rangesString, ",") {
+		rangeString = strings.TrimSpace(rangeString)
+		if rangeString == "" {
+			continue
+		}
+		addressString, lengthString, found := strings.Cut(rangeString, ":")
+		address, err := parseInt(addressString, 32)
+		var length uint64
+		if err == nil && found {
+			length, err = parseInt(lengthString, 32)
+		}
+		if err != nil || !found || length == 0 {
+			return nil, fmt.Errorf("unable to parse range \"%v\" (e.g. 0x3545A0:0x40)", rangeString)
+		}
+		if address < uint64(current.Address) || address+length > uint64(current.Address)+uint64(current.Length) {
+			return nil, fmt.Errorf("range \"%v\" isn't inside snapshot %v (0x%X to 0x%X)", rangeString, current.Name, current.Address, uint64(current.Address)+uint64(current.Length))
+		}
+		ranges = append(ranges, snapshotRange{address: uint32(address), length: int(length)})
+	}
+	return ranges, nil
+}
+
+func handleSnapshotsHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling snapshots HTTP request")
+	// for snapshots:
+	// - a POST to /snapshots saves Woody-Length bytes from Woody-Address (all of PS2 EE RAM for PCSX2 by default)
+	//   as the snapshot called Woody-Name
+	// - a GET to /snapshots lists the snapshots and /snapshots/<name> has one of them
+	// - a GET to /snapshots/<name>/diff/<other> has the values of Woody-Type (u8 by default) that are different in the
+	//   other snapshot, from Woody-Offset (up to Woody-Limit of them)
+	// - a POST to /snapshots/<name>/restore writes the snapshot back, or only the Woody-Ranges of it (e.g.
+	//   0x3545A0:0x40,0x354600:8)
+	// - a DELETE to /snapshots/<name> deletes the snapshot
+	// - snapshots are saved in the snapshots directory so they're still there after Woody restarts
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(httpRequest.URL.Path, "/snapshots"), "/")
+	parts := strings.Split(path, "/")
+	name := parts[0]
+
+	switch {
+	case name == "" && httpRequest.Method == http.MethodPost:
+		takeSnapshot(httpResponseWriter, params)
+		return
+	case name == "":
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"snapshots": snapshots.List()})
+		return
+	}
+	current, err := snapshots.snapshot(name)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	switch {
+	case len(parts) == 3 && parts[1] == "diff" && httpRequest.Method == http.MethodGet:
+		diffSnapshots(httpResponseWriter, params, current, parts[2])
+	case len(parts) == 2 && parts[1] == "restore" && httpRequest.Method == http.MethodPost:
+		restoreSnapshot(httpResponseWriter, params, current)
+	case len(parts) == 1 && httpRequest.Method == http.MethodDelete:
+		err = snapshots.Delete(name)
+		if err != nil {
+			errMessage := err.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 404, errMessage)
+			return
+		}
+		sendHTTPJSON(httpResponseWriter, 200, map[string]any{"name": current.Name, "deleted": true})
+	case len(parts) == 1 && httpRequest.Method == http.MethodGet:
+		sendHTTPJSON(httpResponseWriter, 200, snapshots.result(current))
+	default:
+		errMessage := fmt.Sprintf("unsupported snapshot request %v %v. Use GET /snapshots/<name>/diff/<other>, POST /snapshots/<name>/restore, GET or DELETE /snapshots/<name>", httpRequest.Method, httpRequest.URL.Path)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+	}
+}
+
+func takeSnapshot(httpResponseWriter http.ResponseWriter, params map[string]string) {
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	current, err := parseSnapshot(params, pc)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+
+	resultCode, failedAddress, err := snapshots.Take(pc, game, current)
+	switch {
+	case errors.Is(err, ErrSnapshotExists) || errors.Is(err, ErrTooManySnapshots):
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+	case errors.Is(err, ErrSnapshotEmulator):
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while taking the snapshot", err)
+	case err != nil:
+		errMessage := "could not write the snapshot files"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 500, errMessage)
+	case resultCode != 0:
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), map[string]any{
+			"resultCode":    resultCode,
+			"failedAddress": fmt.Sprintf("0x%X", failedAddress),
+		})
+	default:
+		sendHTTPJSON(httpResponseWriter, 200, snapshots.result(current))
+	}
+}
+
+func diffSnapshots(httpResponseWriter http.ResponseWriter, params map[string]string, from *snapshot, otherName string) {
+	to, err := snapshots.snapshot(otherName)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 404, errMessage)
+		return
+	}
+	typeName := params["woodytype"]
+	if typeName == "" {
+		typeName = "u8"
+	}
+	memoryType, err := parseMemoryType(typeName)
+	var offset, limit int
+	if err == nil {
+		offset, limit, err = parseScanPage(params)
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	diff, err := snapshots.Diff(from, to, memoryType, offset, limit)
+	if errors.Is(err, ErrSnapshotsDontOverlap) {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if err != nil {
+		errMessage := "could not read the snapshot files"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 500, errMessage)
+		return
+	}
+	sendHTTPJSON(httpResponseWriter, 200, map[string]any{
+		"from":    from.Name,
+		"to":      to.Name,
+		"address": fmt.Sprintf("0x%X", diff.address),
+		"length":  diff.length,
+		"type":    memoryType.name,
+		"changes": diff.changes,
+		"offset":  offset,
+		"results": diff.results,
+	})
+}
+
+func restoreSnapshot(httpResponseWriter http.ResponseWriter, params map[string]string, current *snapshot) {
+	ranges, err := parseSnapshotRanges(params["woodyranges"], current)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+	game, err := detectGame(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while checking which game is running", err)
+		return
+	}
+	supervisor.GameDetected(pc, game)
+	if game.id != current.GameID || game.gameVersion != current.GameVersion {
+		errMessage := fmt.Sprintf("snapshot %v was taken with game \"%v\" but \"%v\" is running", current.Name, current.GameID, game.id)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+	if targetByteOrder(pc.name()) != targetByteOrder(current.Target) {
+		errMessage := fmt.Sprintf("snapshot %v was taken with %v, which keeps memory in a different byte order", current.Name, current.Target)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 409, errMessage)
+		return
+	}
+
+	restore, err := snapshots.Restore(pc, current, ranges)
+	switch {
+	case errors.Is(err, ErrSnapshotEmulator):
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while restoring the snapshot", err)
+	case err != nil:
+		errMessage := "could not read the snapshot files"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 500, errMessage)
+	default:
+		result := restore.toResult()
+		result["name"] = current.Name
+		sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(restore.resultCode), result)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// the longest string buffer we'll read or write
+const maxStringLength = 64 * 1024
+
+// how many bytes to read at a time when looking for the end of a string
+const stringReadChunkSize = 256
+
+type stringEncoding struct {
+	name string
+	// nil for ASCII and UTF-8 since those are handled without x/text
+	encoding encoding.Encoding
+	// the number of zero bytes at the end of the string
+	terminatorSize int
+}
+
+var stringEncodings = map[string]stringEncoding{
+	"ascii":    {name: "ascii", terminatorSize: 1},
+	"utf8":     {name: "utf8", terminatorSize: 1},
+	"latin1":   {name: "latin1", encoding: charmap.ISO8859_1, terminatorSize: 1},
+	"shiftjis": {name: "shiftjis", encoding: japanese.ShiftJIS, terminatorSize: 1},
+	"eucjp":    {name: "eucjp", encoding: japanese.EUCJP, terminatorSize: 1},
+	"utf16le":  {name: "utf16le", encoding: unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), terminatorSize: 2},
+	"utf16be":  {name: "utf16be", encoding: unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), terminatorSize: 2},
+}
+
+// the encoding defaults to ASCII and names are normalized like parameters (e.g. "Shift-JIS", "shift_jis" and "shiftjis")
+func parseStringEncoding(name string) (stringEncoding, error) {
+	if name == "" {
+		return stringEncodings["ascii"], nil
+	}
+	adjustedName := normalizeParamKey(name)
+	switch adjustedName {
+	case "sjis":
+		adjustedName = "shiftjis"
+	case "utf16":
+		adjustedName = "utf16le"
+	}
+	stringEncoding, found := stringEncodings[adjustedName]
+	if !found {
+		return stringEncoding, errors.New("unknown encoding \"" + name + "\". Supported values are ascii, utf8, latin1, shiftjis, eucjp, utf16le and utf16be")
+	}
+	return stringEncoding, nil
+}
+
+func (stringEncoding stringEncoding) decode(encodedBytes []byte) (string, error) {
+	switch {
+	case stringEncoding.encoding != nil:
+		decodedBytes, err := stringEncoding.encoding.NewDecoder().Bytes(encodedBytes)
+		return string(decodedBytes), err
+	case stringEncoding.name == "ascii":
+		// anything outside of ASCII becomes the replacement character
+		var builder strings.Builder
+		for _, encodedByte := range encodedBytes {
+			if encodedByte > 0x7F {
+				builder.WriteRune(utf8.RuneError)
+			} else {
+				builder.WriteByte(encodedByte)
+			}
+		}
+		return builder.String(), nil
+	default:
+		return strings.ToValidUTF8(string(encodedBytes), string(utf8.RuneError)), nil
+	}
+}
+
+func (stringEncoding stringEncoding) encode(value string) ([]byte, error) {
+	switch {
+	case stringEncoding.encoding != nil:
+		return stringEncoding.encoding.NewEncoder().Bytes([]byte(value))
+	case stringEncoding.name == "ascii":
+		for _, character := range value {
+			if character > 0x7F {
+				return nil, errors.New("\"" + string(character) + "\" can't be written as ASCII")
+			}
+		}
+		return []byte(value), nil
+	default:
+		return []byte(value), nil
+	}
+}
+
+// finds the terminator (which has to be aligned for UTF-16) or returns -1 if there isn't one
+func (stringEncoding stringEncoding) terminatorIndex(encodedBytes []byte) int {
+	terminator := make([]byte, stringEncoding.terminatorSize)
+	for i := 0; i+stringEncoding.terminatorSize <= len(encodedBytes); i += stringEncoding.terminatorSize {
+		if bytes.Equal(encodedBytes[i:i+stringEncoding.terminatorSize], terminator) {
+			return i
+		}
+	}
+	return -1
+}
+
+// encodes as much of the string as fits into maxLength bytes without splitting a character
+func (stringEncoding stringEncoding) encodeTruncated(value string, maxLength int) ([]byte, bool, error) {
+	encodedBytes, err := stringEncoding.encode(value)
+	if err != nil {
+		return nil, false, err
+	}
+	truncated := false
+	for len(encodedBytes) > maxLength {
+		truncated = true
+		if value == "" {
+			// nothing fits, which handleStringRequest checks for before this
+			return nil, truncated, nil
+		}
+		_, lastCharacterSize := utf8.DecodeLastRuneInString(value)
+		value = value[:len(value)-lastCharacterSize]
+		encodedBytes, err = stringEncoding.encode(value)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	return encodedBytes, truncated, nil
+}
+
+func handleStringRequest(httpResponseWriter http.ResponseWriter, pineRequestType string, pineRequestParams map[string]string) {
+	// for string Requests:
+	// - Woody-Address is where the string buffer starts
+	// - Woody-Length is the size of the buffer in bytes (including the terminator)
+	// - Woody-Encoding is one of the stringEncodings (ASCII when not given)
+	// - for ReadString, the buffer is read until the terminator and the string is returned as "string"
+	// - for WriteString, Woody-Data is the string. It is truncated so that it and the terminator fit in the buffer,
+	//   and the rest of the buffer is filled with zeros so that nothing past the end of the buffer is overwritten
+	// - Woody-Address can be a pointer expression, which is followed without anything else being sent in between
+	addressString, found := pineRequestParams["woodyaddress"]
+	if !found {
+		errMessage := "no address provided for " + pineRequestType + " PINE request"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	expression, err := parseAddressExpression(addressString)
+	if err != nil {
+		errMessage := err.Error() + " for " + pineRequestType + " PINE request"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	lengthUInt64, err := parseIntParam(pineRequestParams, "woodylength", "length", 32, pineRequestType)
+	if err == nil && (lengthUInt64 == 0 || lengthUInt64 > maxStringLength) {
+		err = errors.New("length for " + pineRequestType + " PINE request has to be between 1 and 65536")
+	}
+	address, constant := expression.constant()
+	if err == nil && constant {
+		err = checkMemoryRange(address, int(lengthUInt64))
+	}
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	length := int(lengthUInt64)
+	stringEncoding, err := parseStringEncoding(pineRequestParams["woodyencoding"])
+	if err != nil {
+		errMessage := err.Error() + " for " + pineRequestType + " PINE request"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if length < stringEncoding.terminatorSize {
+		errMessage := fmt.Sprintf("length for %v PINE request has to be at least %v for %v since that's the size of the terminator", pineRequestType, stringEncoding.terminatorSize, stringEncoding.name)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	var bufferBytes []byte
+	var encodedLength int
+	var truncated bool
+	if pineRequestType == "writestring" {
+		dataString, found := pineRequestParams["woodydata"]
+		if !found {
+			errMessage := "no data provided for " + pineRequestType + " PINE request"
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		encodedBytes, encodedTruncated, encodeErr := stringEncoding.encodeTruncated(dataString, length-stringEncoding.terminatorSize)
+		if encodeErr != nil {
+			errMessage := "unable to encode data as " + stringEncoding.name + " for " + pineRequestType + " PINE request: " + encodeErr.Error()
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		// pad with zeros so that the rest of the buffer is cleared
+		bufferBytes = make([]byte, length)
+		copy(bufferBytes, encodedBytes)
+		encodedLength = len(encodedBytes)
+		truncated = encodedTruncated
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, pineRequestParams["woodytarget"])
+	if !found {
+		return
+	}
+
+	var resolution *addressResolution
+	resolving := expression.isPointer()
+	var resultCode uint8
+	var result map[string]any
+	err = pc.WithLock(func(sender PineSender) error {
+		if resolving {
+			var err error
+			resolution, err = expression.resolve(sender)
+			if err == nil && resolution.resultCode == 0 && checkMemoryRange(resolution.address, length) != nil {
+				err = fmt.Errorf("%w (the %v byte buffer at 0x%X doesn't fit)", ErrPointerOutOfRange, length, resolution.address)
+			}
+			if err != nil || resolution.resultCode != 0 {
+				return err
+			}
+			address = resolution.address
+			resolving = false
+		}
+		var err error
+		switch pineRequestType {
+		case "readstring":
+			resultCode, result, err = readString(sender, address, length, stringEncoding)
+		case "writestring":
+			resultCode, err = writeMemory(sender, address, bufferBytes)
+			result = map[string]any{"resultCode": resultCode, "bytesWritten": encodedLength, "truncated": truncated}
+		}
+		return err
+	})
+	if resolving && sendPointerError(httpResponseWriter, pc, resolution, err) {
+		return
+	}
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		sendNoEmulatorError(httpResponseWriter, "error while sending requestBytes for "+pineRequestType+" PINE request", err)
+		return
+	}
+	if resultCode != 0 {
+		result = map[string]any{"resultCode": resultCode}
+	}
+	if resolution != nil {
+		resolution.addToResult(result)
+	}
+	sendHTTPJSON(httpResponseWriter, statusCodeForResultCode(resultCode), result)
+}
+
+// reads the string a chunk at a time until the terminator or the end of the buffer
+func readString(sender PineSender, address uint32, length int, stringEncoding stringEncoding) (uint8, map[string]any, error) {
+	var encodedBytes []byte
+	terminated := false
+	for len(encodedBytes) < length {
+		chunkLength := min(stringReadChunkSize, length-len(encodedBytes))
+		chunk, resultCode, err := readMemoryChunk(sender, address+uint32(len(encodedBytes)), chunkLength)
+		if err != nil || resultCode != 0 {
+			return resultCode, nil, err
+		}
+		encodedBytes = append(encodedBytes, chunk...)
+		if terminatorIndex := stringEncoding.terminatorIndex(encodedBytes); terminatorIndex >= 0 {
+			encodedBytes = encodedBytes[:terminatorIndex]
+			terminated = true
+			break
+		}
+	}
+	decodedString, err := stringEncoding.decode(encodedBytes)
+	if err != nil {
+		// this shouldn't happen since invalid bytes are replaced when decoding
+		logger.Error("error while decoding string", "err", err)
+	}
+	return 0, map[string]any{
+		"resultCode": uint8(0),
+		"string":     decodedString,
+		"length":     len(encodedBytes),
+		"terminated": terminated,
+	}, nil
+}
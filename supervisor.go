@@ -0,0 +1,324 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoEmulator = errors.New("not connected to any emulator")
+var ErrUnknownTarget = errors.New("unknown target")
+var ErrWritesPaused = errors.New("freezes and effects are paused while a savestate analysis runs")
+
+// the supervisor owns a PineConnection for every target and slot. It periodically checks which emulators are
+// answering so that Woody notices when an emulator is closed, restarted or swapped for a different one without
+// needing to be restarted itself. Any number of emulators can be connected at the same time.
+type PineSupervisor struct {
+	lock        sync.RWMutex
+	connections []*supervisedConnection
+	// the target (e.g. "pcsx2" or "pcsx2:28011") used when a Request doesn't ask for one
+	defaultTarget string
+	// how often the supervisor checks that the emulators are still there (or looks for them when they aren't)
+	interval time.Duration
+	// used to ask for a check before the next interval (e.g. when sending a Request fails)
+	recheck chan struct{}
+	// so we only log once when we start looking for emulators instead of every interval
+	searching bool
+}
+
+type supervisedConnection struct {
+	connection *PineConnection
+	connected  bool
+	lastError  error
+	lastSeen   time.Time
+	// what is running and the profile for it (nil when there isn't one)
+	game    gameInfo
+	profile *Profile
+	// the number of jobs (e.g. savestate analyses) that need the freezes and effects to leave the memory alone
+	writesPaused int
+}
+
+func NewPineSupervisor(config *Config) (*PineSupervisor, error) {
+	supervisor := &PineSupervisor{
+		defaultTarget: strings.ToLower(config.DefaultTarget),
+		interval:      time.Duration(config.SupervisorInterval),
+		recheck:       make(chan struct{}, 1),
+	}
+	for _, targetConfig := range config.Targets {
+		var connection *PineConnection
+		var err error
+		if targetConfig.SocketPath != "" {
+			connection, err = NewPineConnectionForSocketPath(targetConfig.Target, targetConfig.Slot, targetConfig.SocketPath)
+		} else {
+			connection, err = NewPineConnection(targetConfig.Target, targetConfig.Slot)
+		}
+		if err != nil {
+			return nil, err
+		}
+		connection.timeout = time.Duration(config.Timeout)
+		supervised := &supervisedConnection{connection: connection, lastError: ErrNoEmulator}
+		if supervisor.knowsTarget(supervised.name()) {
+			return nil, fmt.Errorf("target %v is listed more than once", supervised.name())
+		}
+		supervisor.connections = append(supervisor.connections, supervised)
+	}
+	if supervisor.defaultTarget != "" && !supervisor.knowsTarget(supervisor.defaultTarget) {
+		return nil, fmt.Errorf("%w \"%v\" for the default target", ErrUnknownTarget, supervisor.defaultTarget)
+	}
+	return supervisor, nil
+}
+
+// returns the connection for the target (e.g. "pcsx2" or "pcsx2:28011")
+// an empty target means the default target or, if that isn't connected, whichever emulator is connected
+// the error wraps ErrUnknownTarget when the target isn't one we know about and ErrNoEmulator when it isn't connected
+func (supervisor *PineSupervisor) Connection(target string) (*PineConnection, error) {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
+
+	target = strings.ToLower(target)
+	if target != "" && !supervisor.knowsTarget(target) {
+		return nil, fmt.Errorf("%w \"%v\". Known targets are %v", ErrUnknownTarget, target, supervisor.targetNames())
+	}
+
+	// when a target is asked for, we don't fall back to any other emulator
+	preferredTargets := []string{target}
+	if target == "" && supervisor.defaultTarget != "" {
+		preferredTargets = []string{supervisor.defaultTarget, ""}
+	}
+	var lastError error = ErrNoEmulator
+	for _, preferred := range preferredTargets {
+		for _, supervised := range supervisor.connections {
+			if !supervised.matches(preferred) {
+				continue
+			}
+			if supervised.connected {
+				return supervised.connection, nil
+			}
+			lastError = supervised.lastError
+		}
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoEmulator, lastError)
+}
+
+// the state of every connection, for listing them over the API
+func (supervisor *PineSupervisor) Connections() []map[string]any {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
+
+	var connections []map[string]any
+	for _, supervised := range supervisor.connections {
+		connection := map[string]any{
+			"target":    supervised.connection.target,
+			"slot":      supervised.connection.slot,
+			"name":      supervised.name(),
+			"address":   supervised.connection.address,
+			"connected": supervised.connected,
+			"default":   supervisor.defaultTarget != "" && supervised.matches(supervisor.defaultTarget),
+		}
+		if !supervised.lastSeen.IsZero() {
+			connection["lastSeen"] = supervised.lastSeen.Format(time.RFC3339)
+		}
+		if supervised.game.id != "" {
+			connection["id"] = supervised.game.id
+			connection["gameVersion"] = supervised.game.gameVersion
+			connection["uuid"] = supervised.game.uuid
+		}
+		if supervised.profile != nil {
+			connection["profile"] = supervised.profile.Name
+		}
+		if supervised.lastError != nil {
+			connection["lastError"] = supervised.lastError.Error()
+		}
+		connections = append(connections, connection)
+	}
+	return connections
+}
+
+// lets the supervisor know that sending to a connection failed so it can check it right away
+func (supervisor *PineSupervisor) ConnectionFailed(connection *PineConnection) {
+	logger.Info("sending to the emulator failed, asking the supervisor to check the connection", "target", connection.target)
+	select {
+	case supervisor.recheck <- struct{}{}:
+	default:
+		// a check has already been requested
+	}
+}
+
+// records what game is running for a connection (e.g. when a request had to check) and returns its profile
+func (supervisor *PineSupervisor) GameDetected(connection *PineConnection, game gameInfo) *Profile {
+	supervisor.lock.Lock()
+	defer supervisor.lock.Unlock()
+
+	for _, supervised := range supervisor.connections {
+		if supervised.connection == connection {
+			supervised.setGame(game)
+			return supervised.profile
+		}
+	}
+	return profileForGame(profiles, game)
+}
+
+// the game that was running the last time the connection was checked
+func (supervisor *PineSupervisor) Game(connection *PineConnection) gameInfo {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
+
+	for _, supervised := range supervisor.connections {
+		if supervised.connection == connection {
+			return supervised.game
+		}
+	}
+	return gameInfo{}
+}
+
+// stops the freezes and effects for the connection writing to the memory until ResumeWrites is called (e.g. while a
+// savestate analysis loads states, which would otherwise have the frozen values written into them)
+func (supervisor *PineSupervisor) PauseWrites(connection *PineConnection) {
+	supervisor.lock.Lock()
+	defer supervisor.lock.Unlock()
+
+	for _, supervised := range supervisor.connections {
+		if supervised.connection == connection {
+			supervised.writesPaused++
+		}
+	}
+}
+
+func (supervisor *PineSupervisor) ResumeWrites(connection *PineConnection) {
+	supervisor.lock.Lock()
+	defer supervisor.lock.Unlock()
+
+	for _, supervised := range supervisor.connections {
+		if supervised.connection == connection && supervised.writesPaused > 0 {
+			supervised.writesPaused--
+		}
+	}
+}
+
+// whether the freezes and effects for the connection have to wait. It should be checked with the networkLock held so
+// that nothing is written once a job that paused them has started sending its requests
+func (supervisor *PineSupervisor) WritesPaused(connection *PineConnection) bool {
+	supervisor.lock.RLock()
+	defer supervisor.lock.RUnlock()
+
+	for _, supervised := range supervisor.connections {
+		if supervised.connection == connection {
+			return supervised.writesPaused > 0
+		}
+	}
+	return false
+}
+
+func (supervisor *PineSupervisor) Run() {
+	ticker := time.NewTicker(supervisor.interval)
+	defer ticker.Stop()
+	for {
+		supervisor.check()
+		select {
+		case <-ticker.C:
+		case <-supervisor.recheck:
+		}
+	}
+}
+
+func (supervisor *PineSupervisor) check() {
+	anyConnected := false
+	for _, supervised := range supervisor.connections {
+		err := checkPineConnection(supervised.connection)
+		var game gameInfo
+		if err == nil {
+			game, err = detectGame(supervised.connection)
+		}
+
+		supervisor.lock.Lock()
+		wasConnected := supervised.connected
+		supervised.connected = err == nil
+		supervised.lastError = err
+		if err == nil {
+			supervised.lastSeen = time.Now()
+		}
+		supervised.setGame(game)
+		supervisor.lock.Unlock()
+
+		if err == nil {
+			anyConnected = true
+			if !wasConnected {
+				logger.Info("test connection for target " + supervised.name() + " succeeded.")
+			}
+		} else {
+			supervised.connection.Close()
+			if wasConnected {
+				logger.Info("lost the connection to "+supervised.name()+". Will keep trying to reconnect.", "err", err)
+			} else {
+				logger.Debug("test connection for target "+supervised.name()+" failed.", "err", err)
+			}
+		}
+	}
+
+	if anyConnected {
+		supervisor.searching = false
+	} else if !supervisor.searching {
+		logger.Info("could not connect to any targets. Retrying every " + supervisor.interval.String())
+		supervisor.searching = true
+	}
+}
+
+func (supervisor *PineSupervisor) knowsTarget(target string) bool {
+	for _, supervised := range supervisor.connections {
+		if supervised.matches(target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (supervisor *PineSupervisor) targetNames() []string {
+	var names []string
+	for _, supervised := range supervisor.connections {
+		names = append(names, supervised.name())
+	}
+	return names
+}
+
+// picks the profile when the game changes (the supervisor lock must be held)
+func (supervised *supervisedConnection) setGame(game gameInfo) {
+	if game == supervised.game {
+		return
+	}
+	supervised.game = game
+	supervised.profile = profileForGame(profiles, game)
+	if game.id == "" {
+		logger.Info("no game running in " + supervised.name())
+	} else if supervised.profile == nil {
+		logger.Info("game changed in "+supervised.name()+". There isn't a profile for it", "id", game.id, "gameVersion", game.gameVersion)
+	} else {
+		logger.Info("game changed in "+supervised.name()+". Using profile "+supervised.profile.Name, "id", game.id, "gameVersion", game.gameVersion)
+	}
+}
+
+// the target and slot, e.g. "pcsx2:28011"
+func (supervised *supervisedConnection) name() string {
+	return supervised.connection.name()
+}
+
+// an empty target matches everything, a target without a slot matches every slot for that target
+func (supervised *supervisedConnection) matches(target string) bool {
+	return target == "" || target == supervised.connection.target || target == supervised.name()
+}
+
+// an emulator counts as being there if we can connect and it answers a status Request
+func checkPineConnection(connection *PineConnection) error {
+	err := connection.TestConnection()
+	if err != nil {
+		return err
+	}
+	answer := &PineStatusAnswer{}
+	err = connection.SendRequest(PineStatusRequest{}, answer)
+	if err != nil {
+		return err
+	}
+	logger.Debug("status for "+connection.target, "resultCode", answer.resultCode, "status", answer.status)
+	return nil
+}
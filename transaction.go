@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// a transaction changes several values together (e.g. the current and max HP) while holding the networkLock: every
+// value is read, checked against what the client expected, written and then read back. If anything fails after the
+// writes were sent, every value is put back to what it was before so the game is never left half changed.
+
+const maxTransactionOperations = 256
+
+type transactionOperation struct {
+	span       memorySpan
+	memoryType MemoryType
+	// the value that has to be in memory for the transaction to go ahead (compare-and-swap)
+	expected *uint64
+	// the value to write, or the operation to work it out from the value in memory
+	value  uint64
+	modify *modifyOperation
+
+	// filled in while running
+	read       bool
+	original   uint64
+	newValue   uint64
+	saturated  bool
+	written    bool
+	readBack   *uint64
+	restored   *bool
+	resultCode uint8
+}
+
+type transaction struct {
+	operations []*transactionOperation
+	// committed, conflict, failed (nothing was written), rolledBack or rollbackFailed
+	status string
+	// the step that went wrong: read, check, write or verify
+	failedStep string
+	resultCode uint8
+}
+
+func parseTransactionOperation(params map[string]string) (*transactionOperation, error) {
+	addressString, found := params["woodyaddress"]
+	if !found {
+		return nil, fmt.Errorf("no address found")
+	}
+	address, err := parsePlainAddress(addressString)
+	if err != nil {
+		return nil, err
+	}
+	typeName := params["woodytype"]
+	if typeName == "" {
+		typeName = "u32"
+	}
+	memoryType, err := parseMemoryType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	operation := &transactionOperation{
+		span:       memorySpan{address: address, width: max(memoryType.width/8, 1)},
+		memoryType: memoryType,
+	}
+	if expectedString, found := params["woodyexpected"]; found {
+		expected, err := memoryType.encode(expectedString)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse expected value %v as %v", expectedString, memoryType.name)
+		}
+		operation.expected = &expected
+	}
+	if _, found := params["woodyoperation"]; found {
+		operation.modify, err = parseModifyOperation(params, memoryType)
+		return operation, err
+	}
+	dataString, found := params["woodydata"]
+	if !found {
+		return nil, fmt.Errorf("no data or operation found")
+	}
+	operation.value, err = memoryType.encode(dataString)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse data %v as %v", dataString, memoryType.name)
+	}
+	return operation, nil
+}
+
+// runs every step while holding the networkLock. An error means the emulator went away, in which case the values
+// are still put back if possible and the status and failedStep say how far it got
+func (t *transaction) run(pc *PineConnection) error {
+	return pc.WithLock(func(sender PineSender) error {
+		originals, resultCode, err := t.readValues(sender)
+		if err != nil {
+			t.fail("failed", "read", 0)
+			return err
+		}
+		if resultCode != 0 {
+			t.fail("failed", "read", resultCode)
+			return nil
+		}
+		conflict := false
+		for i, operation := range t.operations {
+			operation.read = true
+			operation.original = originals[i]
+			if operation.expected != nil && !operation.matches(*operation.expected, operation.original) {
+				conflict = true
+			}
+			operation.newValue = operation.value
+			if operation.modify != nil {
+				operation.newValue, operation.saturated = operation.modify.apply(operation.original)
+			}
+		}
+		if conflict {
+			t.fail("conflict", "check", 0)
+			return nil
+		}
+
+		spans, values := t.spansAndValues(func(operation *transactionOperation) uint64 { return operation.newValue })
+		resultCode, err = writeMemoryValues(sender, spans, values)
+		if err != nil {
+			// the emulator may have done the writes before the connection went away
+			t.fail("rolledBack", "write", 0)
+			t.rollback(sender)
+			return err
+		}
+		if resultCode != 0 {
+			t.fail("rolledBack", "write", resultCode)
+			t.rollback(sender)
+			return nil
+		}
+		for _, operation := range t.operations {
+			operation.written = true
+		}
+
+		readBack, resultCode, err := t.readValues(sender)
+		if err != nil {
+			t.fail("rolledBack", "verify", 0)
+			t.rollback(sender)
+			return err
+		}
+		mismatch := false
+		for i, operation := range t.operations {
+			if readBack != nil {
+				operation.readBack = &readBack[i]
+				mismatch = mismatch || !operation.matches(operation.newValue, readBack[i])
+			}
+		}
+		if resultCode != 0 || mismatch {
+			t.fail("rolledBack", "verify", resultCode)
+			t.rollback(sender)
+			return nil
+		}
+		t.status = "committed"
+		return nil
+	})
+}
+
+func (t *transaction) fail(status string, step string, resultCode uint8) {
+	t.status = status
+	t.failedStep = step
+	t.resultCode = resultCode
+}
+
+// reads every value with a single batch message. When the batch fails, the values are read one at a time so the
+// report can show which one failed
+func (t *transaction) readValues(sender PineSender) ([]uint64, uint8, error) {
+	spans, _ := t.spansAndValues(nil)
+	values, resultCode, err := readMemoryValues(sender, spans)
+	if err != nil || resultCode == 0 {
+		return values, resultCode, err
+	}
+	for _, operation := range t.operations {
+		_, operation.resultCode, err = readMemoryValue(sender, operation.span)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return nil, resultCode, nil
+}
+
+// puts back the value from before for everything that might have been written, trying one at a time when the batch
+// fails so that as many values as possible are restored. A value that can't be written but still has the value from
+// before (e.g. the write that made the batch fail) counts as restored
+func (t *transaction) rollback(sender PineSender) {
+	spans, values := t.spansAndValues(func(operation *transactionOperation) uint64 { return operation.original })
+	resultCode, err := writeMemoryValues(sender, spans, values)
+	allRestored := err == nil && resultCode == 0
+	for _, operation := range t.operations {
+		restored := allRestored
+		if !allRestored && err == nil {
+			operation.resultCode, err = writeMemoryValue(sender, operation.span, operation.original)
+			restored = err == nil && operation.resultCode == 0
+			if !restored && err == nil {
+				var value uint64
+				var readResultCode uint8
+				value, readResultCode, err = readMemoryValue(sender, operation.span)
+				restored = err == nil && readResultCode == 0 && operation.matches(operation.original, value)
+			}
+		}
+		operation.restored = &restored
+		if !restored {
+			t.status = "rollbackFailed"
+		}
+	}
+	if t.status == "rollbackFailed" {
+		logger.Error("unable to put back every value after a failed transaction", "failedStep", t.failedStep)
+	}
+}
+
+func (t *transaction) spansAndValues(value func(operation *transactionOperation) uint64) ([]memorySpan, []uint64) {
+	spans := make([]memorySpan, len(t.operations))
+	values := make([]uint64, len(t.operations))
+	for i, operation := range t.operations {
+		spans[i] = operation.span
+		if value != nil {
+			values[i] = value(operation)
+		}
+	}
+	return spans, values
+}
+
+// compares the bits that belong to the type (so a bool that reads back as 2 still matches true)
+func (operation *transactionOperation) matches(expected uint64, actual uint64) bool {
+	if operation.memoryType.kind == boolKind {
+		return (expected != 0) == (actual != 0)
+	}
+	mask := operation.memoryType.mask()
+	return expected&mask == actual&mask
+}
+
+func (operation *transactionOperation) toResult() map[string]any {
+	memoryType := operation.memoryType
+	result := map[string]any{
+		"address": fmt.Sprintf("0x%X", operation.span.address),
+		"type":    memoryType.name,
+		"written": operation.written,
+	}
+	if operation.resultCode != 0 {
+		result["resultCode"] = operation.resultCode
+	}
+	if operation.modify != nil {
+		result["operation"] = operation.modify.name
+	}
+	if operation.expected != nil {
+		result["expected"] = memoryType.decode(*operation.expected)
+	}
+	if operation.read {
+		result["oldValue"] = memoryType.decode(operation.original)
+		result["newValue"] = memoryType.decode(operation.newValue)
+		if operation.expected != nil {
+			result["matched"] = operation.matches(*operation.expected, operation.original)
+		}
+		if operation.modify != nil {
+			result["saturated"] = operation.saturated
+		}
+	}
+	if operation.readBack != nil {
+		result["readBack"] = memoryType.decode(*operation.readBack)
+		result["verified"] = operation.matches(operation.newValue, *operation.readBack)
+	}
+	if operation.restored != nil {
+		result["restored"] = *operation.restored
+	}
+	return result
+}
+
+func (t *transaction) toResult() map[string]any {
+	operations := make([]map[string]any, len(t.operations))
+	for i, operation := range t.operations {
+		operations[i] = operation.toResult()
+	}
+	result := map[string]any{
+		"status":     t.status,
+		"resultCode": t.resultCode,
+		"operations": operations,
+	}
+	if t.failedStep != "" {
+		result["failedStep"] = t.failedStep
+	}
+	return result
+}
+
+func (t *transaction) statusCode() int {
+	switch t.status {
+	case "committed":
+		return 200
+	case "conflict":
+		return 409
+	case "failed":
+		return statusCodeForResultCode(t.resultCode)
+	default:
+		return 500
+	}
+}
+
+func handleTransactionHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling transaction HTTP request")
+	// for transactions:
+	// - only POST HTTP requests are supported
+	// - the body is a JSON array where each element is an object with a Woody-Address, Woody-Type (u32 by default)
+	//   and either Woody-Data to write or a Woody-Operation like /modify takes
+	// - Woody-Expected is the value that has to be in memory first. If any value doesn't match, nothing is written
+	//   and a 409 (Conflict) HTTP response code is sent
+	// - everything is written in one batch message and read back, and if anything fails every value is put back
+	// - the emulator can be chosen with a Woody-Target header or woodyTarget URL parameter (not per operation)
+	// - nothing else is sent to the emulator while the transaction runs
+	// - when the emulator goes away, the 503 (Service Unavailable) response still has the report
+	if httpRequest.Method != http.MethodPost {
+		errMessage := "transactions must use POST"
+		logger.Error(errMessage, "method", httpRequest.Method)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+		return
+	}
+	var operationsParams []map[string]any
+	decoder := json.NewDecoder(httpRequest.Body)
+	decoder.UseNumber()
+	err := decoder.Decode(&operationsParams)
+	if err != nil {
+		errMessage := "could not parse the JSON body for the transaction"
+		logger.Error(errMessage, "err", err)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	if len(operationsParams) == 0 || len(operationsParams) > maxTransactionOperations {
+		errMessage := fmt.Sprintf("a transaction needs between 1 and %v operations", maxTransactionOperations)
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	t := &transaction{}
+	for i, operationParams := range operationsParams {
+		operation, err := parseTransactionOperation(parseJSONParams(operationParams))
+		if err == nil {
+			// putting values back would be ambiguous if two operations changed the same bytes
+			for _, other := range t.operations {
+				if operation.span.address < other.span.address+uint32(other.span.width) &&
+					other.span.address < operation.span.address+uint32(operation.span.width) {
+					err = fmt.Errorf("overlaps with the operation for address 0x%X", other.span.address)
+				}
+			}
+		}
+		if err != nil {
+			errMessage := fmt.Sprintf("operation %v in the transaction: %v", i, err)
+			logger.Error(errMessage)
+			sendHTTPError(httpResponseWriter, 400, errMessage)
+			return
+		}
+		t.operations = append(t.operations, operation)
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, findHTTPParam(httpRequest, "woodytarget"))
+	if !found {
+		return
+	}
+	err = t.run(pc)
+	if err != nil {
+		supervisor.ConnectionFailed(pc)
+		// the report says whether every value was put back since the writes may have been sent
+		sendNoEmulatorErrorWithReport(httpResponseWriter, "error while sending the PINE requests for the transaction", err, t.toResult())
+		return
+	}
+	if t.status != "committed" {
+		logger.Error("transaction did not go through", "status", t.status, "failedStep", t.failedStep, "resultCode", t.resultCode)
+	}
+	sendHTTPJSON(httpResponseWriter, t.statusCode(), t.toResult())
+}
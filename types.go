@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type memoryTypeKind int
+
+const (
+	unsignedKind memoryTypeKind = iota
+	signedKind
+	floatKind
+	boolKind
+)
+
+// how the bytes of a value in memory should be interpreted (set with Woody-Type)
+type MemoryType struct {
+	name string
+	// in bits, which also picks the PINE Request (e.g. Read32)
+	width int
+	kind  memoryTypeKind
+}
+
+var memoryTypes = map[string]MemoryType{
+	"u8":   {name: "u8", width: 8, kind: unsignedKind},
+	"u16":  {name: "u16", width: 16, kind: unsignedKind},
+	"u32":  {name: "u32", width: 32, kind: unsignedKind},
+	"u64":  {name: "u64", width: 64, kind: unsignedKind},
+	"i8":   {name: "i8", width: 8, kind: signedKind},
+	"i16":  {name: "i16", width: 16, kind: signedKind},
+	"i32":  {name: "i32", width: 32, kind: signedKind},
+	"i64":  {name: "i64", width: 64, kind: signedKind},
+	"f32":  {name: "f32", width: 32, kind: floatKind},
+	"f64":  {name: "f64", width: 64, kind: floatKind},
+	"bool": {name: "bool", width: 8, kind: boolKind},
+}
+
+func parseMemoryType(name string) (MemoryType, error) {
+	memoryType, found := memoryTypes[strings.ToLower(name)]
+	if !found {
+		return MemoryType{}, fmt.Errorf("unknown type \"%v\". Supported values are u8, u16, u32, u64, i8, i16, i32, i64, f32, f64 and bool", name)
+	}
+	return memoryType, nil
+}
+
+// converts the raw bits from memory into a value that can be put into JSON
+func (memoryType MemoryType) decode(raw uint64) any {
+	raw = raw & memoryType.mask()
+	switch memoryType.kind {
+	case signedKind:
+		// shift left then right so the sign bit is extended
+		shift := 64 - memoryType.width
+		return int64(raw<<shift) >> shift
+	case floatKind:
+		var value float64
+		if memoryType.width == 32 {
+			value = float64(math.Float32frombits(uint32(raw)))
+		} else {
+			value = math.Float64frombits(raw)
+		}
+		// JSON doesn't have NaN or infinity so those are sent as strings
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return strconv.FormatFloat(value, 'g', -1, 64)
+		}
+		return value
+	case boolKind:
+		return raw != 0
+	default:
+		return raw
+	}
+}
+
+// converts a literal (e.g. "-5", "1.5", "0b1010" or "true") into the raw bits to write to memory
+func (memoryType MemoryType) encode(literal string) (uint64, error) {
+	literal = strings.TrimSpace(literal)
+	switch memoryType.kind {
+	case signedKind:
+		// hex and binary are taken as the raw bits
+		if hasRadixPrefix(literal) {
+			return parseInt(literal, memoryType.width)
+		}
+		value, err := strconv.ParseInt(literal, 10, memoryType.width)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(value) & memoryType.mask(), nil
+	case floatKind:
+		value, err := strconv.ParseFloat(literal, memoryType.width)
+		if err != nil {
+			return 0, err
+		}
+		if memoryType.width == 32 {
+			return uint64(math.Float32bits(float32(value))), nil
+		}
+		return math.Float64bits(value), nil
+	case boolKind:
+		switch strings.ToLower(literal) {
+		case "true", "1":
+			return 1, nil
+		case "false", "0":
+			return 0, nil
+		default:
+			return 0, errors.New("expected true, false, 1 or 0 for a bool")
+		}
+	default:
+		return parseInt(literal, memoryType.width)
+	}
+}
+
+// the decoded value as a number for comparing with a min and max. NaN and the infinities aren't numbers
+func (memoryType MemoryType) number(raw uint64) (float64, bool) {
+	switch decoded := memoryType.decode(raw).(type) {
+	case uint64:
+		return float64(decoded), true
+	case int64:
+		return float64(decoded), true
+	case float64:
+		return decoded, true
+	case bool:
+		if decoded {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+func (memoryType MemoryType) mask() uint64 {
+	if memoryType.width == 64 {
+		return math.MaxUint64
+	}
+	return (1 << memoryType.width) - 1
+}
+
+// the same prefixes that parseInt understands
+func hasRadixPrefix(literal string) bool {
+	return strings.HasPrefix(literal, "0x") || strings.HasPrefix(literal, "0b")
+}
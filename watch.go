@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the most values a single watcher can ask for
+const maxWatchItems = 1024
+
+// the most operations sent to the emulator in a single batch message when sampling
+const watchBatchSize = 4096
+
+// how many events can be waiting for a slow client before newer changes are held back
+const watchEventBufferSize = 16
+
+// how often a comment is sent to a watcher when nothing has changed so the connection isn't closed for being idle
+const watchKeepAliveInterval = 15 * time.Second
+
+// a value being watched
+type watchItem struct {
+	// the name the value is reported under (e.g. "0x35459C:u32" or "status")
+	key               string
+	pineRequestType   string
+	pineRequestParams map[string]string
+	// the element of the result that has the value (e.g. "memoryValue" or "status")
+	resultKey string
+}
+
+type watchEvent struct {
+	// "values" or "error"
+	name string
+	data map[string]any
+}
+
+type watchSubscription struct {
+	// an empty target means the default target (just like for a single request)
+	target string
+	items  []watchItem
+	events chan watchEvent
+	// only used by the sampling loop
+	lastValues     map[string]any
+	lastErrMessage string
+}
+
+// the watcher samples everything that is being watched on a shared interval. The union of the values for each target
+// is read with a single batch, so the emulator sees the same request rate however many clients are watching, and each
+// subscription is only sent the values that changed since the last values it was sent.
+type MemoryWatcher struct {
+	lock          sync.Mutex
+	subscriptions map[*watchSubscription]bool
+	interval      time.Duration
+	// the sampling loop only runs while there is something to watch
+	running bool
+}
+
+func NewMemoryWatcher(interval time.Duration) *MemoryWatcher {
+	return &MemoryWatcher{
+		subscriptions: make(map[*watchSubscription]bool),
+		interval:      interval,
+	}
+}
+
+func (watcher *MemoryWatcher) Subscribe(target string, items []watchItem) *watchSubscription {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	subscription := &watchSubscription{
+		target:     target,
+		items:      items,
+		events:     make(chan watchEvent, watchEventBufferSize),
+		lastValues: make(map[string]any),
+	}
+	watcher.subscriptions[subscription] = true
+	logger.Info("added watch subscription", "target", target, "items", len(items), "subscriptions", len(watcher.subscriptions))
+	if !watcher.running {
+		watcher.running = true
+		go watcher.run()
+	}
+	return subscription
+}
+
+func (watcher *MemoryWatcher) Unsubscribe(subscription *watchSubscription) {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	// the events channel is never closed since the sampling loop might still be sending to it (the sends never block)
+	delete(watcher.subscriptions, subscription)
+	logger.Info("removed watch subscription", "subscriptions", len(watcher.subscriptions))
+}
+
+func (watcher *MemoryWatcher) run() {
+	logger.Info("starting the watch sampling loop", "interval", watcher.interval)
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+	for {
+		subscriptions := watcher.currentSubscriptions()
+		if len(subscriptions) == 0 {
+			logger.Info("stopping the watch sampling loop since nothing is being watched")
+			return
+		}
+		watcher.sample(subscriptions)
+		<-ticker.C
+	}
+}
+
+// also marks the loop as stopped when there aren't any subscriptions so the next Subscribe starts it again
+func (watcher *MemoryWatcher) currentSubscriptions() []*watchSubscription {
+	watcher.lock.Lock()
+	defer watcher.lock.Unlock()
+
+	var subscriptions []*watchSubscription
+	for subscription := range watcher.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	if len(subscriptions) == 0 {
+		watcher.running = false
+	}
+	return subscriptions
+}
+
+func (watcher *MemoryWatcher) sample(subscriptions []*watchSubscription) {
+	// subscriptions with different targets can still end up at the same emulator
+	subscriptionsByConnection := make(map[*PineConnection][]*watchSubscription)
+	for _, subscription := range subscriptions {
+		pc, err := supervisor.Connection(subscription.target)
+		if err != nil {
+			subscription.sendError(noEmulatorEventData("no emulator to watch", err))
+			continue
+		}
+		subscriptionsByConnection[pc] = append(subscriptionsByConnection[pc], subscription)
+	}
+
+	for pc, connectionSubscriptions := range subscriptionsByConnection {
+		var items []watchItem
+		seen := make(map[string]bool)
+		for _, subscription := range connectionSubscriptions {
+			for _, item := range subscription.items {
+				if !seen[item.key] {
+					seen[item.key] = true
+					items = append(items, item)
+				}
+			}
+		}
+		values, resultCode, err := sampleWatchItems(pc, items)
+		if err != nil {
+			supervisor.ConnectionFailed(pc)
+			for _, subscription := range connectionSubscriptions {
+				subscription.sendError(noEmulatorEventData("error while sampling watched values", err))
+			}
+			continue
+		}
+		if resultCode == 0 {
+			for _, subscription := range connectionSubscriptions {
+				subscription.sendValues(values)
+			}
+			continue
+		}
+
+		// the emulator only gives a single result code for the whole batch, so a single address that can't be read
+		// fails everything. Sampling each subscription on its own keeps that from breaking every other watcher.
+		logger.Debug("sampling watched values failed, sampling each subscription separately", "resultCode", resultCode)
+		for _, subscription := range connectionSubscriptions {
+			values, resultCode, err := sampleWatchItems(pc, subscription.items)
+			if err != nil {
+				supervisor.ConnectionFailed(pc)
+				subscription.sendError(noEmulatorEventData("error while sampling watched values", err))
+			} else if resultCode != 0 {
+				subscription.sendError(map[string]any{
+					"errMessage": "the emulator failed to read the watched values",
+					"resultCode": resultCode,
+				})
+			} else {
+				subscription.sendValues(values)
+			}
+		}
+	}
+}
+
+// reads every item using as few batch messages as possible and returns the values by key
+func sampleWatchItems(pc *PineConnection, items []watchItem) (map[string]any, uint8, error) {
+	values := make(map[string]any)
+	for start := 0; start < len(items); start += watchBatchSize {
+		batchItems := items[start:min(start+watchBatchSize, len(items))]
+		batchRequest := PineBatchRequest{}
+		batchAnswer := &PineBatchAnswer{}
+		var operations []*pineOperation
+		for _, item := range batchItems {
+			// the items were checked when they were parsed so this can't fail
+			operation, err := newPineOperation(item.pineRequestType, item.pineRequestParams)
+			if err != nil {
+				return nil, 255, err
+			}
+			operations = append(operations, operation)
+			batchRequest.requests = append(batchRequest.requests, operation.request)
+			batchAnswer.answers = append(batchAnswer.answers, operation.answer)
+		}
+		requestBytes, err := batchRequest.toBytes()
+		if err != nil {
+			return nil, 255, err
+		}
+		answerBytes, err := pc.Send(requestBytes)
+		if err != nil {
+			return nil, 0, err
+		}
+		err = batchAnswer.fromBytes(answerBytes)
+		if err != nil {
+			return nil, 0, err
+		}
+		if batchAnswer.resultCode != 0 {
+			return nil, batchAnswer.resultCode, nil
+		}
+		for i, operation := range operations {
+			_, result := operation.result()
+			values[batchItems[i].key] = result[batchItems[i].resultKey]
+		}
+	}
+	return values, 0, nil
+}
+
+// sends the values that changed since the last values event (every value for the first one or after an error)
+func (subscription *watchSubscription) sendValues(values map[string]any) {
+	changed := make(map[string]any)
+	for _, item := range subscription.items {
+		value := values[item.key]
+		lastValue, found := subscription.lastValues[item.key]
+		if !found || lastValue != value {
+			changed[item.key] = value
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	// if the client is too slow to keep up then the changes are held back and sent with the next sample
+	if subscription.trySend(watchEvent{name: "values", data: changed}) {
+		for key, value := range changed {
+			subscription.lastValues[key] = value
+		}
+		subscription.lastErrMessage = ""
+	}
+}
+
+// sends an error unless it is the same as the last one (so a missing emulator isn't reported every interval)
+func (subscription *watchSubscription) sendError(data map[string]any) {
+	errMessage := fmt.Sprint(data)
+	if errMessage == subscription.lastErrMessage {
+		return
+	}
+	if subscription.trySend(watchEvent{name: "error", data: data}) {
+		subscription.lastErrMessage = errMessage
+		// every value is sent again once sampling works again
+		subscription.lastValues = make(map[string]any)
+	}
+}
+
+func (subscription *watchSubscription) trySend(event watchEvent) bool {
+	select {
+	case subscription.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// the same elements as sendNoEmulatorError
+func noEmulatorEventData(errMessage string, err error) map[string]any {
+	return map[string]any{
+		"errMessage": errMessage,
+		"errType":    "noEmulator",
+		"errDetails": err.Error(),
+	}
+}
+
+// parses what to watch:
+//   - Woody-Watch is a comma separated list of address[:type] (e.g. "0x35459C:u32,0x3545A0:f32") where the type is one
+//     of the memoryTypes or just a width (e.g. 16 for u16) and defaults to u32
+//   - Woody-Status, Woody-Title and Woody-ID set to true also watch the emulator's status, the title of the game and
+//     the ID of the game
+func parseWatchItems(params map[string]string) ([]watchItem, error) {
+	var items []watchItem
+	seen := make(map[string]bool)
+	for _, watchString := range strings.Split(params["woodywatch"], ",") {
+		watchString = strings.TrimSpace(watchString)
+		if watchString == "" {
+			continue
+		}
+		addressString, typeString, _ := strings.Cut(watchString, ":")
+		address, err := parseInt(strings.TrimSpace(addressString), 32)
+		if err != nil {
+			return nil, errors.New("unable to parse address " + addressString + " to watch")
+		}
+		typeString = strings.TrimSpace(typeString)
+		switch typeString {
+		case "":
+			typeString = "u32"
+		case "8", "16", "32", "64":
+			typeString = "u" + typeString
+		}
+		memoryType, err := parseMemoryType(typeString)
+		if err != nil {
+			return nil, errors.New(err.Error() + " for watching " + addressString)
+		}
+		key := fmt.Sprintf("0x%X:%v", address, memoryType.name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		items = append(items, watchItem{
+			key:             key,
+			pineRequestType: fmt.Sprintf("read%v", memoryType.width),
+			pineRequestParams: map[string]string{
+				"woodyaddress": fmt.Sprint(address),
+				"woodytype":    memoryType.name,
+			},
+			resultKey: "memoryValue",
+		})
+	}
+	for _, emulatorValue := range []struct {
+		param           string
+		pineRequestType string
+		key             string
+	}{
+		{"woodystatus", "status", "status"},
+		{"woodytitle", "title", "title"},
+		{"woodyid", "id", "id"},
+	} {
+		if strings.ToLower(params[emulatorValue.param]) == "true" {
+			items = append(items, watchItem{
+				key:               emulatorValue.key,
+				pineRequestType:   emulatorValue.pineRequestType,
+				pineRequestParams: map[string]string{},
+				resultKey:         emulatorValue.key,
+			})
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("nothing to watch. Use Woody-Watch with a list of addresses and/or Woody-Status, Woody-Title or Woody-ID")
+	}
+	if len(items) > maxWatchItems {
+		return nil, fmt.Errorf("too many values to watch (%v). At most %v can be watched at once", len(items), maxWatchItems)
+	}
+	for _, item := range items {
+		_, err := newPineOperation(item.pineRequestType, item.pineRequestParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+func handleWatchHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling watch HTTP request")
+	// for watching:
+	// - the response is a Server-Sent Events stream (e.g. for EventSource in a browser)
+	// - parseWatchItems has the parameters for what to watch, and Woody-Target picks the emulator
+	// - a "values" event has a JSON object with only the values that changed since the last "values" event, keyed by
+	//   the normalized address and type (e.g. "0x35459C:u32") or by "status", "title" and "id"
+	// - the first "values" event (and the first one after an error) has every value
+	// - an "error" event has the same JSON elements as an error response (e.g. an errType of noEmulator when the
+	//   emulator goes away). Watching carries on and the values are sent again once the emulator is back
+	// - every watcher shares the same sampling loop so the emulator sees the same request rate however many
+	//   clients are watching
+	params, err := parseHTTPParams(httpRequest)
+	if err != nil {
+		errMessage := "could not parse HTTP form and/or path parameters"
+		logger.Error(errMessage, "httpRequest", httpRequest)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	items, err := parseWatchItems(params)
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	// an emulator that isn't running yet is fine since the watcher will wait for it, but a typo in the target isn't
+	target := params["woodytarget"]
+	_, err = supervisor.Connection(target)
+	if errors.Is(err, ErrUnknownTarget) {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	flusher, ok := httpResponseWriter.(http.Flusher)
+	if !ok {
+		errMessage := "streaming isn't supported for this connection"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 500, errMessage)
+		return
+	}
+
+	httpResponseWriter.Header().Set("Content-Type", "text/event-stream")
+	httpResponseWriter.Header().Set("Cache-Control", "no-cache")
+	httpResponseWriter.WriteHeader(200)
+	flusher.Flush()
+
+	subscription := watcher.Subscribe(target, items)
+	defer watcher.Unsubscribe(subscription)
+	keepAliveTicker := time.NewTicker(watchKeepAliveInterval)
+	defer keepAliveTicker.Stop()
+	for {
+		select {
+		case <-httpRequest.Context().Done():
+			logger.Info("watch client went away")
+			return
+		case event := <-subscription.events:
+			jsonBytes, err := json.Marshal(event.data)
+			if err != nil {
+				logger.Error("error while converting the watch event to JSON", "err", err)
+				continue
+			}
+			_, err = fmt.Fprintf(httpResponseWriter, "event: %v\ndata: %s\n\n", event.name, jsonBytes)
+			if err != nil {
+				logger.Info("watch client went away", "err", err)
+				return
+			}
+		case <-keepAliveTicker.C:
+			_, err = fmt.Fprint(httpResponseWriter, ": keep-alive\n\n")
+			if err != nil {
+				logger.Info("watch client went away", "err", err)
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// the biggest message we'll accept from a WebSocket client
+const maxWebSocketMessageSize = 1024 * 1024
+
+// how long to wait for a slow client to take a message before giving up on it
+const webSocketWriteTimeout = 10 * time.Second
+
+var webSocketUpgrader = websocket.Upgrader{
+	CheckOrigin: checkWebSocketOrigin,
+}
+
+// browsers let any web page open a WebSocket to localhost (unlike reading the responses to its HTTP requests), so only
+// clients that aren't browsers (which don't send an Origin), pages served from the same host and the allowedOrigins
+// in the config can connect
+func checkWebSocketOrigin(httpRequest *http.Request) bool {
+	origin := httpRequest.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	originURL, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(originURL.Host, httpRequest.Host) {
+		return true
+	}
+	for _, allowedOrigin := range config.AllowedOrigins {
+		if allowedOrigin == "*" || strings.EqualFold(strings.TrimSuffix(allowedOrigin, "/"), origin) {
+			return true
+		}
+	}
+	logger.Warn("refused a WebSocket connection from a web page that isn't in allowedOrigins", "origin", origin)
+	return false
+}
+
+// a client connected to the WebSocket API
+type webSocketClient struct {
+	conn *websocket.Conn
+	// only one message can be written to the WebSocket at a time
+	writeLock sync.Mutex
+	// the subscriptions the client has made, by their ID
+	subscriptionsLock sync.Mutex
+	subscriptions     map[string]*webSocketSubscription
+}
+
+type webSocketSubscription struct {
+	id           any
+	subscription *watchSubscription
+	// closed when the client unsubscribes or goes away
+	done chan struct{}
+}
+
+func handleWebSocketHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling WebSocket HTTP request")
+	// for the WebSocket API:
+	// - every message is a JSON object. The "action" is one of request (the default), subscribe or unsubscribe and
+	//   the "id" is sent back in every message about it so clients can match them up
+	// - a request has the same headers/parameters as a single HTTP request
+	//   (e.g. {"id": 1, "Woody-Request-Type": "Read32", "Woody-Address": "0x35459C"}). It's answered with a
+	//   "result" message where "result" has the same JSON elements as the HTTP response, or an "error" message with
+	//   the same JSON elements as an HTTP error response
+	// - a subscribe has the same parameters as /watch and is answered with a "subscribed" message. After that the
+	//   changes are sent in the "values" of "values" messages and problems as "error" messages, all with the id of the
+	//   subscription
+	// - an unsubscribe stops the subscription with the id and is answered with an "unsubscribed" message
+	// - every message sent has a "type" and the "statusCode" that the HTTP API would have used
+	// - requests are handled at the same time, so the results can arrive in a different order than the requests
+	conn, err := webSocketUpgrader.Upgrade(httpResponseWriter, httpRequest, nil)
+	if err != nil {
+		// the upgrader has already sent an error response
+		logger.Error("could not upgrade to a WebSocket", "err", err)
+		return
+	}
+	conn.SetReadLimit(maxWebSocketMessageSize)
+	client := &webSocketClient{conn: conn, subscriptions: make(map[string]*webSocketSubscription)}
+	defer client.close()
+	logger.Info("WebSocket client connected", "remoteAddr", httpRequest.RemoteAddr)
+
+	for {
+		_, messageBytes, err := conn.ReadMessage()
+		if err != nil {
+			logger.Info("WebSocket client went away", "err", err)
+			return
+		}
+		var message map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(messageBytes))
+		decoder.UseNumber()
+		err = decoder.Decode(&message)
+		if err != nil {
+			client.sendError(nil, 400, "could not parse the JSON message")
+			continue
+		}
+		id := message["id"]
+		delete(message, "id")
+		params := parseJSONParams(message)
+		action := normalizeParamKey(params["action"])
+		delete(params, "action")
+		switch action {
+		case "", "request":
+			go client.handleRequest(id, params)
+		case "subscribe":
+			client.subscribe(id, params)
+		case "unsubscribe":
+			client.unsubscribe(id)
+		default:
+			client.sendError(id, 400, "unknown action \""+action+"\". Supported values are request, subscribe and unsubscribe")
+		}
+	}
+}
+
+// runs the request through the same code as the HTTP API and sends whatever it would have responded with
+func (client *webSocketClient) handleRequest(id any, params map[string]string) {
+	pineRequestType := normalizeParamKey(params["woodyrequesttype"])
+	delete(params, "woodyrequesttype")
+	if pineRequestType == "" {
+		client.sendError(id, 400, "no PINE request type found in the message")
+		return
+	}
+	responseWriter := newBufferedResponseWriter()
+	handlePineRequest(responseWriter, pineRequestType, params)
+
+	var body map[string]any
+	err := json.Unmarshal(responseWriter.body.Bytes(), &body)
+	if err != nil {
+		client.sendError(id, 500, "error while converting the response to JSON")
+		return
+	}
+	if _, found := body["errMessage"]; found {
+		client.send("error", id, responseWriter.statusCode, body)
+		return
+	}
+	client.send("result", id, responseWriter.statusCode, map[string]any{"result": body})
+}
+
+func (client *webSocketClient) subscribe(id any, params map[string]string) {
+	key := fmt.Sprint(id)
+	if id == nil {
+		client.sendError(id, 400, "no id provided for the subscription")
+		return
+	}
+	items, err := parseWatchItems(params)
+	if err != nil {
+		client.sendError(id, 400, err.Error())
+		return
+	}
+	target := params["woodytarget"]
+	_, err = supervisor.Connection(target)
+	if errors.Is(err, ErrUnknownTarget) {
+		client.sendError(id, 400, err.Error())
+		return
+	}
+
+	client.subscriptionsLock.Lock()
+	if _, found := client.subscriptions[key]; found {
+		client.subscriptionsLock.Unlock()
+		client.sendError(id, 400, "there is already a subscription with the id "+key)
+		return
+	}
+	subscription := &webSocketSubscription{
+		id:           id,
+		subscription: watcher.Subscribe(target, items),
+		done:         make(chan struct{}),
+	}
+	client.subscriptions[key] = subscription
+	client.subscriptionsLock.Unlock()
+
+	client.send("subscribed", id, 200, nil)
+	go client.forwardEvents(subscription)
+}
+
+func (client *webSocketClient) unsubscribe(id any) {
+	key := fmt.Sprint(id)
+	client.subscriptionsLock.Lock()
+	subscription, found := client.subscriptions[key]
+	delete(client.subscriptions, key)
+	client.subscriptionsLock.Unlock()
+	if !found {
+		client.sendError(id, 400, "no subscription with the id "+key)
+		return
+	}
+	watcher.Unsubscribe(subscription.subscription)
+	close(subscription.done)
+	client.send("unsubscribed", id, 200, nil)
+}
+
+func (client *webSocketClient) forwardEvents(subscription *webSocketSubscription) {
+	for {
+		select {
+		case <-subscription.done:
+			return
+		case event := <-subscription.subscription.events:
+			if event.name == "values" {
+				client.send("values", subscription.id, 200, map[string]any{"values": event.data})
+				continue
+			}
+			statusCode := 500
+			if event.data["errType"] == "noEmulator" {
+				statusCode = 503
+			}
+			client.send(event.name, subscription.id, statusCode, event.data)
+		}
+	}
+}
+
+func (client *webSocketClient) close() {
+	client.subscriptionsLock.Lock()
+	for key, subscription := range client.subscriptions {
+		watcher.Unsubscribe(subscription.subscription)
+		close(subscription.done)
+		delete(client.subscriptions, key)
+	}
+	client.subscriptionsLock.Unlock()
+	client.conn.Close()
+}
+
+func (client *webSocketClient) sendError(id any, statusCode int, errMessage string) {
+	logger.Error(errMessage)
+	client.send("error", id, statusCode, map[string]any{"errMessage": errMessage})
+}
+
+// sends a message with the type, id and status code along with the other elements
+func (client *webSocketClient) send(messageType string, id any, statusCode int, elements map[string]any) {
+	message := map[string]any{}
+	maps.Copy(message, elements)
+	message["type"] = messageType
+	message["statusCode"] = statusCode
+	if id != nil {
+		message["id"] = id
+	}
+
+	client.writeLock.Lock()
+	defer client.writeLock.Unlock()
+	client.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	err := client.conn.WriteJSON(message)
+	if err != nil {
+		// the read loop will notice that the client is gone and clean up
+		logger.Error("error while sending a WebSocket message", "err", err)
+	}
+}
+
+// collects a response from the HTTP handlers so it can be sent as a WebSocket message instead
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newBufferedResponseWriter() *bufferedResponseWriter {
+	return &bufferedResponseWriter{header: make(http.Header), statusCode: 200}
+}
+
+func (responseWriter *bufferedResponseWriter) Header() http.Header {
+	return responseWriter.header
+}
+
+func (responseWriter *bufferedResponseWriter) Write(bytes []byte) (int, error) {
+	return responseWriter.body.Write(bytes)
+}
+
+func (responseWriter *bufferedResponseWriter) WriteHeader(statusCode int) {
+	responseWriter.statusCode = statusCode
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// the biggest body we'll accept for a bulk write (a bit more than all of PS3 main memory)
+const maxWriteBodySize = 512 * 1024 * 1024
+
+func handleWriteHTTPRequest(httpResponseWriter http.ResponseWriter, httpRequest *http.Request) {
+	logger.Info("handling write HTTP request")
+	// for bulk writes:
+	// - only POST HTTP requests are supported and the body has the bytes to write
+	// - parameters have to be headers or URL parameters since the body is the data
+	// - Woody-Address is where to start writing
+	// - Woody-Format is one of binary (the default), hex or base64
+	// - Woody-Verify set to true reads every chunk back after writing it and reports any bytes that don't match
+	// - the bytes are written a chunk at a time using the widest aligned writes possible
+	// - chunks that fail are reported in failedChunks and the rest of the chunks are still written
+	if httpRequest.Method != http.MethodPost {
+		errMessage := "bulk writes must use POST"
+		logger.Error(errMessage, "method", httpRequest.Method)
+		sendHTTPError(httpResponseWriter, 405, errMessage)
+		return
+	}
+	params := parseHTTPParamsWithoutBody(httpRequest)
+	address, err := parseAddressParam(params, "write")
+	if err != nil {
+		errMessage := err.Error()
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+	verify := strings.ToLower(params["woodyverify"]) == "true"
+
+	var body io.Reader = http.MaxBytesReader(httpResponseWriter, httpRequest.Body, maxWriteBodySize)
+	format := strings.ToLower(params["woodyformat"])
+	switch format {
+	case "", "binary":
+		format = "binary"
+	case "hex":
+		// whitespace is allowed between the hex digits
+		body = &truncatedDecodingReader{reader: hex.NewDecoder(&whitespaceSkippingReader{reader: body}), format: format}
+	case "base64":
+		body = &truncatedDecodingReader{reader: base64.NewDecoder(base64.StdEncoding, &whitespaceSkippingReader{reader: body}), format: format}
+	default:
+		errMessage := "unknown format \"" + format + "\" for write. Supported values are binary, hex and base64"
+		logger.Error(errMessage)
+		sendHTTPError(httpResponseWriter, 400, errMessage)
+		return
+	}
+
+	pc, found := connectionForTarget(httpResponseWriter, params["woodytarget"])
+	if !found {
+		return
+	}
+
+	logger.Info("writing memory", "address", address, "format", format, "verify", verify)
+	var failedChunks []map[string]any
+	var mismatches []map[string]any
+	bytesWritten := 0
+	chunk := make([]byte, memoryChunkSize)
+	for {
+		chunkLength, readErr := io.ReadFull(body, chunk)
+		if chunkLength > 0 {
+			chunkAddress := address + uint32(bytesWritten)
+			err = checkMemoryRange(address, bytesWritten+chunkLength)
+			if err != nil {
+				sendWriteReport(httpResponseWriter, 400, bytesWritten, failedChunks, mismatches, err.Error())
+				return
+			}
+			resultCode, err := writeMemoryChunk(pc, chunkAddress, chunk[:chunkLength])
+			if err != nil {
+				supervisor.ConnectionFailed(pc)
+				sendWriteReport(httpResponseWriter, 503, bytesWritten, failedChunks, mismatches, "error while writing memory: "+err.Error())
+				return
+			}
+			if resultCode != 0 {
+				failedChunks = append(failedChunks, map[string]any{"address": chunkAddress, "length": chunkLength, "resultCode": resultCode})
+			} else if verify {
+				readBack, resultCode, err := readMemoryChunk(pc, chunkAddress, chunkLength)
+				if err != nil {
+					supervisor.ConnectionFailed(pc)
+					sendWriteReport(httpResponseWriter, 503, bytesWritten, failedChunks, mismatches, "error while reading memory back: "+err.Error())
+					return
+				}
+				if resultCode != 0 {
+					failedChunks = append(failedChunks, map[string]any{"address": chunkAddress, "length": chunkLength, "resultCode": resultCode, "verify": true})
+				} else {
+					mismatches = append(mismatches, findMismatches(chunkAddress, chunk[:chunkLength], readBack)...)
+				}
+			}
+			bytesWritten += chunkLength
+			logger.Debug("write progress", "bytesWritten", bytesWritten)
+		}
+		// a short last chunk is the end of the body (the decoders' own errors are turned into ErrTruncatedBody)
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			var maxBytesError *http.MaxBytesError
+			statusCode := 400
+			if errors.As(readErr, &maxBytesError) {
+				statusCode = 413
+			}
+			sendWriteReport(httpResponseWriter, statusCode, bytesWritten, failedChunks, mismatches, "error while reading the body: "+readErr.Error())
+			return
+		}
+	}
+	logger.Info("finished writing memory", "bytesWritten", bytesWritten, "failedChunks", len(failedChunks), "mismatches", len(mismatches))
+
+	statusCode := 200
+	if len(failedChunks) > 0 || len(mismatches) > 0 {
+		statusCode = 500
+	}
+	sendWriteReport(httpResponseWriter, statusCode, bytesWritten, failedChunks, mismatches, "")
+}
+
+func sendWriteReport(httpResponseWriter http.ResponseWriter, statusCode int, bytesWritten int, failedChunks []map[string]any, mismatches []map[string]any, errMessage string) {
+	var resultCode uint8 = 0
+	if len(failedChunks) > 0 || len(mismatches) > 0 || errMessage != "" {
+		resultCode = 255
+	}
+	report := map[string]any{
+		"resultCode":   resultCode,
+		"bytesWritten": bytesWritten,
+		"failedChunks": failedChunks,
+	}
+	if failedChunks == nil {
+		report["failedChunks"] = []map[string]any{}
+	}
+	if mismatches != nil {
+		report["mismatches"] = mismatches
+	}
+	if errMessage != "" {
+		logger.Error(errMessage)
+		report["errMessage"] = errMessage
+	}
+	sendHTTPJSON(httpResponseWriter, statusCode, report)
+}
+
+// finds the runs of bytes that weren't what was written
+func findMismatches(address uint32, written []byte, readBack []byte) []map[string]any {
+	var mismatches []map[string]any
+	for i := 0; i < len(written); {
+		if written[i] == readBack[i] {
+			i++
+			continue
+		}
+		start := i
+		for i < len(written) && written[i] != readBack[i] {
+			i++
+		}
+		mismatches = append(mismatches, map[string]any{
+			"address":  address + uint32(start),
+			"length":   i - start,
+			"expected": hex.EncodeToString(written[start:i]),
+			"actual":   hex.EncodeToString(readBack[start:i]),
+		})
+	}
+	return mismatches
+}
+
+var ErrTruncatedBody = errors.New("the body ends part way through a byte")
+
+// the hex and base64 decoders give io.ErrUnexpectedEOF when the body ends part way through a byte, which would look
+// just like io.ReadFull reaching the end of the body after a short last chunk
+type truncatedDecodingReader struct {
+	reader io.Reader
+	format string
+}
+
+func (truncatedDecodingReader *truncatedDecodingReader) Read(buffer []byte) (int, error) {
+	n, err := truncatedDecodingReader.reader.Read(buffer)
+	if err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%w (check the %v for missing or extra characters)", ErrTruncatedBody, truncatedDecodingReader.format)
+	}
+	return n, err
+}
+
+// lets hex and base64 bodies be split over lines
+type whitespaceSkippingReader struct {
+	reader io.Reader
+}
+
+func (whitespaceSkippingReader *whitespaceSkippingReader) Read(buffer []byte) (int, error) {
+	for {
+		n, err := whitespaceSkippingReader.reader.Read(buffer)
+		kept := buffer[:0]
+		for _, character := range buffer[:n] {
+			if !bytes.ContainsRune([]byte(" \t\r\n"), rune(character)) {
+				kept = append(kept, character)
+			}
+		}
+		if len(kept) > 0 || err != nil {
+			return len(kept), err
+		}
+	}
+}